@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SawitProRecruitment/UserService/config"
+)
+
+// parseConfig parses args with flags, along with the flags of the settings,
+// and returns the configuration. It returns nil and the exit code when it
+// fails.
+func parseConfig(flags *flag.FlagSet, args []string) (*config.Config, int) {
+	config.RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, 2
+	}
+	cfg, err := config.Load(config.LoadOptions{Flags: flags})
+	if err != nil {
+		return nil, fail(err)
+	}
+	return cfg, 0
+}
+
+// loadConfig is parseConfig for commands that need a valid configuration.
+func loadConfig(flags *flag.FlagSet, args []string) (*config.Config, int) {
+	cfg, code := parseConfig(flags, args)
+	if cfg == nil {
+		return nil, code
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fail(err)
+	}
+	return cfg, 0
+}
+
+// runConfig prints the effective configuration with its secrets hidden, and
+// what is wrong with it.
+func runConfig(args []string) int {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main config [FLAGS]\n\n"+
+			"Prints the effective configuration, secrets hidden. Settings are read from the YAML file of -config or\n"+
+			"CONFIG_FILE, then from the environment, then from the flags.")
+		flags.PrintDefaults()
+	}
+	cfg, code := parseConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+
+	fmt.Print(cfg.Redacted())
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/SawitProRecruitment/UserService/utils"
+)
+
+func runGenerateKeys(args []string) int {
+	flags := flag.NewFlagSet("generate-keys", flag.ContinueOnError)
+	bits := flags.Int("bits", 4096, "size of the keys")
+	force := flags.Bool("force", false, "overwrite existing files, invalidating every token")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main generate-keys [--bits N] [--force]\n\n"+
+			"Generates the RSA key pair signing and verifying tokens, written to the files of\n"+
+			"auth.private_key_file and auth.public_key_file, private.pem and public.pem when unset.")
+		flags.PrintDefaults()
+	}
+	// The keys are generated before the rest is configured, e.g. the database.
+	cfg, code := parseConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	privatePath, publicPath := cfg.Auth.PrivateKeyFile, cfg.Auth.PublicKeyFile
+	if privatePath == "" {
+		privatePath = "private.pem"
+	}
+	if publicPath == "" {
+		publicPath = "public.pem"
+	}
+
+	privatePEM, publicPEM, err := utils.GenerateRSAKeyPair(*bits)
+	if err != nil {
+		return fail(err)
+	}
+	if err := writeKeyFile(privatePath, privatePEM, 0o600, *force); err != nil {
+		return fail(err)
+	}
+	if err := writeKeyFile(publicPath, publicPEM, 0o644, *force); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("wrote %s and %s\n", privatePath, publicPath)
+	return 0
+}
+
+// writeKeyFile writes a key to path, which must not exist unless force is
+// set: replacing the keys logs every user out.
+func writeKeyFile(path string, key []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, perm)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists, use --force to replace it", path)
+	} else if err != nil {
+		return err
+	}
+	if _, err := file.Write(key); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,31 +1,56 @@
 package main
 
 import (
+	"fmt"
 	"os"
-
-	"github.com/SawitProRecruitment/UserService/generated"
-	"github.com/SawitProRecruitment/UserService/handler"
-	"github.com/SawitProRecruitment/UserService/repository"
-
-	"github.com/labstack/echo/v4"
 )
 
-func main() {
-	e := echo.New()
+const usage = `usage: main [COMMAND] [ARGS]
+
+Commands:
+  serve           start the HTTP server, the default command
+  migrate         apply or revert the schema migrations
+  create-admin    register an administrator
+  reset-password  set the password of a user
+  lock-user       lock a user out, or let them in again
+  normalize-phone-numbers
+                  rewrite phone numbers stored before they were normalized
+  generate-keys   generate the RSA key pair signing tokens
+  export-users    write every user as JSON lines
+  config          print the effective configuration, secrets hidden
 
-	var server generated.ServerInterface = newServer()
+Every command takes the flags of the settings, e.g. -database.url, and
+-config to read them from a YAML file. Run "main COMMAND -h" for the
+arguments of a command.`
 
-	generated.RegisterHandlers(e, server)
-	e.Logger.Fatal(e.Start(":1323"))
+// commands run a subcommand with its arguments and return the exit code.
+var commands = map[string]func(args []string) int{
+	"serve":                   runServe,
+	"migrate":                 runMigrate,
+	"create-admin":            runCreateAdmin,
+	"reset-password":          runResetPassword,
+	"lock-user":               runLockUser,
+	"normalize-phone-numbers": runNormalizePhoneNumbers,
+	"generate-keys":           runGenerateKeys,
+	"export-users":            runExportUsers,
+	"config":                  runConfig,
 }
 
-func newServer() *handler.Server {
-	dbDsn := os.Getenv("DATABASE_URL")
-	var repo repository.RepositoryInterface = repository.NewRepository(repository.NewRepositoryOptions{
-		Dsn: dbDsn,
-	})
-	opts := handler.NewServerOptions{
-		Repository: repo,
+func main() {
+	// Without a command the server starts, as it always did.
+	if len(os.Args) < 2 {
+		os.Exit(runServe(nil))
+	}
+
+	switch name := os.Args[1]; name {
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+	default:
+		run, ok := commands[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+			os.Exit(2)
+		}
+		os.Exit(run(os.Args[2:]))
 	}
-	return handler.NewServer(opts)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+const migrateUsage = `usage: main migrate [FLAGS] COMMAND
+
+Commands:
+  up          apply every pending migration
+  down [N]    revert the last N applied migrations, 1 by default
+  status      list the migrations and when they were applied
+  to VERSION  apply or revert migrations until VERSION is the last applied,
+              0 reverts every migration`
+
+// runMigrate runs the migrate subcommand with args and returns the exit code.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), migrateUsage)
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	if args = flags.Args(); len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	migrator, err := repository.NewMigrator(repository.NewMigratorOptions{Dsn: cfg.Database.URL})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer migrator.Db.Close()
+	if err := waitForDatabase(migrator.Db, cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	var migrated []repository.Migration
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		migrated, err = migrator.Up(ctx)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of migrations %q\n", args[1])
+				return 2
+			}
+		}
+		migrated, err = migrator.Down(ctx, steps)
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			fmt.Fprintf(os.Stderr, "invalid version %q\n", args[1])
+			return 2
+		}
+		migrated, err = migrator.To(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		return printMigrationStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	for _, migration := range migrated {
+		fmt.Printf("migrated %d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(migrated) == 0 {
+		fmt.Println("nothing to migrate")
+	}
+	return 0
+}
+
+func printMigrationStatus(ctx context.Context, migrator *repository.Migrator) int {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	w.Flush()
+	return 0
+}
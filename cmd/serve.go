@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/config"
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// localBlobsPath is where the files of a local blob store are served.
+const localBlobsPath = "/blobs"
+
+// runServe starts the HTTP server, it only returns when the server stops.
+func runServe(args []string) int {
+	cfg, code := loadConfig(flag.NewFlagSet("serve", flag.ContinueOnError), args)
+	if cfg == nil {
+		return code
+	}
+	log.Printf("effective configuration:\n%s", cfg.Redacted())
+
+	e := echo.New()
+	e.HTTPErrorHandler = handler.ErrorHandler
+
+	blobStore, blobDir, err := newBlobStore(cfg.Blob)
+	if err != nil {
+		return fail(err)
+	}
+	if blobDir != "" {
+		e.Static(localBlobsPath, blobDir)
+	}
+	// Bodies are read whole to validate them, they can't be much larger
+	// than an avatar and the rest of its form.
+	e.Use(middleware.BodyLimit(strconv.FormatInt(cfg.Avatar.MaxBytes+1<<20, 10)))
+
+	server, err := newServer(cfg, blobStore)
+	if err != nil {
+		return fail(err)
+	}
+	if cfg.Auth.PrivateKeyFile == "" {
+		log.Print("auth.private_key_file is not set, users can't log in")
+	}
+
+	swagger, err := generated.GetSwagger()
+	if err != nil {
+		return fail(err)
+	}
+	e.Use(handler.NewAuthMiddleware(handler.NewAuthMiddlewareOptions{
+		Swagger:    swagger,
+		Validator:  server.Validator,
+		Utils:      server.Utils,
+		Repository: server.Repository,
+	}))
+	e.Use(handler.NewOpenAPIValidator(handler.NewOpenAPIValidatorOptions{
+		Swagger:           swagger,
+		ValidateResponses: cfg.Server.ValidateResponses,
+	}))
+	e.Use(handler.NewIdempotencyMiddleware(handler.NewIdempotencyMiddlewareOptions{
+		Swagger:    swagger,
+		Repository: server.Repository,
+		TTL:        cfg.Server.IdempotencyTTL,
+	}))
+	go deleteExpiredIdempotencyKeys(server.Repository, e.Logger)
+
+	generated.RegisterHandlers(e, server)
+	e.Logger.Error(e.Start(cfg.Server.Addr))
+	return 1
+}
+
+// newServer returns the server configured by cfg, failing on the first
+// setting it can't use, e.g. a missing file.
+func newServer(cfg *config.Config, blobStore utils.BlobStore) (*handler.Server, error) {
+	peppers, err := utils.LoadPeppers(cfg.Password.PepperFile, cfg.Password.Peppers)
+	if err != nil {
+		return nil, err
+	}
+	argon2Params := cfg.Password.Argon2.Params()
+	hasher := utils.NewArgon2idHasher(argon2Params)
+	if peppers != nil {
+		hasher = utils.NewPepperedArgon2idHasher(argon2Params, peppers)
+	}
+	blocklist, err := utils.NewPasswordBlocklist(utils.NewPasswordBlocklistOptions{
+		BreachedFile: cfg.Password.BreachedFile,
+		CommonFile:   cfg.Password.CommonFile,
+		CommonTopN:   cfg.Password.CommonTopN,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var policy *handler.PasswordPolicy
+	if path := cfg.Password.PolicyFile; path != "" {
+		loaded, err := handler.LoadPasswordPolicy(path)
+		if err != nil {
+			return nil, err
+		}
+		policy = &loaded
+	}
+	phoneNumbers := utils.NewPhoneNumberParser(utils.NewPhoneNumberParserOptions{
+		DefaultRegion:  cfg.PhoneNumber.DefaultRegion,
+		AllowedRegions: cfg.PhoneNumber.Regions,
+	})
+	reservedUsernames := utils.NewReservedUsernames(utils.DefaultReservedUsernames)
+	if path := cfg.Username.ReservedFile; path != "" {
+		reservedUsernames, err = utils.LoadReservedUsernames(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var attributes *handler.AttributeSchema
+	if path := cfg.Profile.CustomAttributesFile; path != "" {
+		attributes, err = handler.LoadAttributeSchema(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	emailVerifier, err := newEmailVerifier(cfg.Email)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, publicKey, err := cfg.Auth.LoadKeys()
+	if err != nil {
+		return nil, err
+	}
+	// The configuration was validated, the isolation level parses.
+	txIsolation, _ := repository.ParseIsolationLevel(cfg.Database.IsolationLevel)
+	repo := repository.NewRepository(repository.NewRepositoryOptions{
+		Dsn:         cfg.Database.URL,
+		Hasher:      hasher,
+		TxIsolation: txIsolation,
+		TxRetries:   cfg.Database.TxRetries,
+		Pool:        poolOptions(cfg.Database),
+	})
+	if err := waitForDatabase(repo.Db, cfg.Database); err != nil {
+		return nil, err
+	}
+	opts := handler.NewServerOptions{
+		Config:            cfg,
+		Repository:        repo,
+		Hasher:            hasher,
+		Blocklist:         blocklist,
+		Policy:            policy,
+		PhoneNumbers:      phoneNumbers,
+		ReservedUsernames: reservedUsernames,
+		Attributes:        attributes,
+		EmailVerifier:     emailVerifier,
+		BlobStore:         blobStore,
+		PrivateKey:        privateKey,
+		PublicKey:         publicKey,
+	}
+	return handler.NewServer(opts), nil
+}
+
+// poolOptions returns the pool settings of cfg, 0 idle connections means
+// none to the configuration but the default to database/sql.
+func poolOptions(cfg config.DatabaseConfig) repository.PoolOptions {
+	opts := repository.PoolOptions{
+		MaxOpenConns:    cfg.MaxOpenConns,
+		MaxIdleConns:    cfg.MaxIdleConns,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.ConnMaxIdleTime,
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = -1
+	}
+	return opts
+}
+
+// waitForDatabase pings db until it answers, logging the failed attempts.
+func waitForDatabase(db *sql.DB, cfg config.DatabaseConfig) error {
+	err := repository.WaitForDatabase(context.Background(), db, repository.WaitOptions{
+		Attempts: cfg.ConnectAttempts,
+		Backoff:  cfg.ConnectBackoff,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			log.Printf("database is unreachable (attempt %d of %d), retrying in %s: %v",
+				attempt, cfg.ConnectAttempts, delay, err)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("database is unreachable: %w", err)
+	}
+	return nil
+}
+
+// deleteExpiredIdempotencyKeys purges the expired idempotency keys every hour.
+func deleteExpiredIdempotencyKeys(repo repository.RepositoryInterface, logger echo.Logger) {
+	for range time.Tick(time.Hour) {
+		if _, err := repo.DeleteExpiredIdempotencyKeys(context.Background()); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// newBlobStore returns the store of uploaded avatars, and the directory to
+// serve when they are stored locally.
+func newBlobStore(cfg config.BlobConfig) (utils.BlobStore, string, error) {
+	if cfg.Store == "s3" {
+		store, err := utils.NewS3BlobStore(utils.NewS3BlobStoreOptions{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PublicURL:       cfg.S3.PublicURL,
+		})
+		return store, "", err
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = localBlobsPath
+	}
+	store, err := utils.NewLocalBlobStore(utils.NewLocalBlobStoreOptions{Dir: cfg.Dir, BaseURL: baseURL})
+	return store, cfg.Dir, err
+}
+
+func newEmailVerifier(cfg config.EmailConfig) (*utils.EmailVerifier, error) {
+	var mailer utils.Mailer
+	if path := cfg.MailFile; path != "" {
+		fileMailer, err := utils.NewFileMailer(path)
+		if err != nil {
+			return nil, err
+		}
+		mailer = fileMailer
+	}
+
+	// The configuration was validated, the key decodes.
+	key, _ := base64.StdEncoding.DecodeString(cfg.VerificationKey)
+	return utils.NewEmailVerifier(utils.NewEmailVerifierOptions{
+		Mailer: mailer,
+		Key:    key,
+		URL:    cfg.VerificationURL,
+	})
+}
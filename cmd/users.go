@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+// exportedUser is a line written by export-users.
+type exportedUser struct {
+	ID string `json:"id"`
+	generated.UserProfile
+	IsAdmin  bool       `json:"is_admin"`
+	LockedAt *time.Time `json:"locked_at,omitempty"`
+}
+
+func runCreateAdmin(args []string) int {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	phoneNumber := flags.String("phone", "", "phone number of the administrator (required)")
+	fullName := flags.String("name", "", "full name of the administrator (required)")
+	email := flags.String("email", "", "email address of the administrator")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main create-admin --phone PHONE --name NAME [--email EMAIL]\n\n"+
+			"The password is read from the standard input.")
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	if *phoneNumber == "" || *fullName == "" {
+		flags.Usage()
+		return 2
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return fail(err)
+	}
+	regRequest := &generated.RegistrationRequest{PhoneNumber: *phoneNumber, FullName: *fullName, Password: password}
+	if *email != "" {
+		regRequest.Email = email
+	}
+
+	server, err := newServer(cfg, nil)
+	if err != nil {
+		return fail(err)
+	}
+	userID, err := server.Service.CreateAdmin(context.Background(), regRequest)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("created administrator %s\n", userID)
+	return 0
+}
+
+func runResetPassword(args []string) int {
+	flags := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	phoneNumber := flags.String("phone", "", "phone number of the user (required)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main reset-password --phone PHONE\n\n"+
+			"The new password is read from the standard input.")
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	if *phoneNumber == "" {
+		flags.Usage()
+		return 2
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return fail(err)
+	}
+	server, err := newServer(cfg, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if err := server.Service.ResetPassword(context.Background(), *phoneNumber, password); err != nil {
+		return fail(err)
+	}
+	fmt.Println("password reset")
+	return 0
+}
+
+func runLockUser(args []string) int {
+	flags := flag.NewFlagSet("lock-user", flag.ContinueOnError)
+	phoneNumber := flags.String("phone", "", "phone number of the user (required)")
+	unlock := flags.Bool("unlock", false, "let the user in again")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main lock-user --phone PHONE [--unlock]")
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	if *phoneNumber == "" {
+		flags.Usage()
+		return 2
+	}
+
+	server, err := newServer(cfg, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if err := server.Service.LockUser(context.Background(), *phoneNumber, !*unlock); err != nil {
+		return fail(err)
+	}
+	if *unlock {
+		fmt.Println("user unlocked")
+	} else {
+		fmt.Println("user locked")
+	}
+	return 0
+}
+
+func runNormalizePhoneNumbers(args []string) int {
+	flags := flag.NewFlagSet("normalize-phone-numbers", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main normalize-phone-numbers\n\n"+
+			"Phone numbers that are not in E.164 format are rewritten in it. The users whose\n"+
+			"number can't be rewritten are listed, it is safe to run again once they are fixed.")
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	server, err := newServer(cfg, nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	normalization, err := server.Service.NormalizePhoneNumbers(context.Background())
+	if err != nil {
+		return fail(err)
+	}
+	userIDs := make([]string, 0, len(normalization.Failed))
+	for userID := range normalization.Failed {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		fmt.Fprintf(os.Stderr, "user %s: %v\n", userID, normalization.Failed[userID])
+	}
+	fmt.Printf("normalized %d phone numbers, %d left as they are\n", normalization.Normalized, len(userIDs))
+	if len(userIDs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runExportUsers(args []string) int {
+	flags := flag.NewFlagSet("export-users", flag.ContinueOnError)
+	output := flags.String("output", "", "file to write to instead of the standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: main export-users [--output FILE]\n\n"+
+			"Every user is written as a line of JSON, without their password.")
+		flags.PrintDefaults()
+	}
+	cfg, code := loadConfig(flags, args)
+	if cfg == nil {
+		return code
+	}
+	server, err := newServer(cfg, nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fail(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	count := 0
+	err = server.Repository.ExportUsers(context.Background(), func(user repository.ExportedUser) error {
+		count++
+		return encoder.Encode(exportedUser{user.ID, user.Profile, user.IsAdmin, user.LockedAt})
+	})
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d users\n", count)
+	return 0
+}
+
+// readPassword reads a password from the first line of the standard input,
+// so it doesn't show in the arguments of the process.
+func readPassword() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("the password is empty")
+	}
+	return password, nil
+}
+
+// fail prints err and returns the exit code of a failed command. Errors of
+// the service are explained as they would be to a client of the API.
+func fail(err error) int {
+	var e *handler.Error
+	if errors.As(err, &e) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", e.Code, e.Error())
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return 1
+}
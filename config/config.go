@@ -0,0 +1,470 @@
+// Package config holds the settings of the service. They are loaded, in
+// increasing order of precedence, from their defaults, a YAML file, the
+// environment and the command line flags.
+package config
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFileEnv names the YAML file to load when the -config flag is not
+	// set.
+	ConfigFileEnv = "CONFIG_FILE"
+	configFlag    = "config"
+
+	redacted = "[redacted]"
+)
+
+// Config holds every setting of the service. Settings are described by the
+// tags of their field: the yaml key, which is also their flag name prefixed
+// by the keys of their sections, the env variable, the usage shown by -h and
+// whether their value is secret. A "url" secret only hides the password of
+// the URL.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Password    PasswordConfig    `yaml:"password"`
+	PhoneNumber PhoneNumberConfig `yaml:"phone_number"`
+	Username    UsernameConfig    `yaml:"username"`
+	Profile     ProfileConfig     `yaml:"profile"`
+	Email       EmailConfig       `yaml:"email"`
+	Avatar      AvatarConfig      `yaml:"avatar"`
+	Blob        BlobConfig        `yaml:"blob"`
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"SERVER_ADDR" usage:"address the HTTP server listens on"`
+	ValidateResponses bool          `yaml:"validate_responses" env:"OPENAPI_VALIDATE_RESPONSES" usage:"check the responses against the OpenAPI spec"`
+	IdempotencyTTL    time.Duration `yaml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" usage:"how long the responses of requests with an Idempotency-Key are replayed"`
+}
+
+type DatabaseConfig struct {
+	URL            string `yaml:"url" env:"DATABASE_URL" secret:"url" usage:"Postgres connection URL"`
+	IsolationLevel string `yaml:"isolation_level" env:"DATABASE_ISOLATION_LEVEL" usage:"isolation level of transactions, the database default when empty"`
+	TxRetries      int    `yaml:"tx_retries" env:"DATABASE_TX_RETRIES" usage:"how many times a transaction is run again after a serialization failure, -1 disables it"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DATABASE_MAX_OPEN_CONNS" usage:"most connections open at once, unlimited when 0"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DATABASE_MAX_IDLE_CONNS" usage:"most idle connections kept open"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DATABASE_CONN_MAX_LIFETIME" usage:"how long a connection is reused, forever when 0"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DATABASE_CONN_MAX_IDLE_TIME" usage:"how long a connection is kept idle, forever when 0"`
+	ConnectAttempts int           `yaml:"connect_attempts" env:"DATABASE_CONNECT_ATTEMPTS" usage:"how many times the database is pinged at startup before giving up"`
+	ConnectBackoff  time.Duration `yaml:"connect_backoff" env:"DATABASE_CONNECT_BACKOFF" usage:"delay after the first failed ping, doubling up to 10s"`
+}
+
+type AuthConfig struct {
+	PrivateKeyFile string        `yaml:"private_key_file" env:"PRIVATE_KEY" usage:"PEM file of the RSA key signing tokens"`
+	PublicKeyFile  string        `yaml:"public_key_file" env:"PUBLIC_KEY" usage:"PEM file of the RSA key verifying tokens"`
+	TokenTTL       time.Duration `yaml:"token_ttl" env:"TOKEN_TTL" usage:"how long tokens are valid"`
+}
+
+type PasswordConfig struct {
+	PepperFile   string       `yaml:"pepper_file" env:"PASSWORD_PEPPER_FILE" usage:"file of the password peppers"`
+	Peppers      string       `yaml:"peppers" env:"PASSWORD_PEPPERS" secret:"true" usage:"password peppers, when there is no pepper file"`
+	PolicyFile   string       `yaml:"policy_file" env:"PASSWORD_POLICY_FILE" usage:"YAML file of the password policy"`
+	BreachedFile string       `yaml:"breached_file" env:"BREACHED_PASSWORDS_FILE" usage:"file of the SHA-1 hashes of breached passwords"`
+	CommonFile   string       `yaml:"common_file" env:"COMMON_PASSWORDS_FILE" usage:"file of common passwords, most common first"`
+	CommonTopN   int          `yaml:"common_top_n" env:"COMMON_PASSWORDS_TOP_N" usage:"how many common passwords are rejected, all of them when 0"`
+	Argon2       Argon2Config `yaml:"argon2"`
+}
+
+// Argon2Config is the cost of password hashes. Raising it rehashes the
+// password of users when they log in.
+type Argon2Config struct {
+	Memory      int `yaml:"memory" env:"PASSWORD_ARGON2_MEMORY" usage:"memory of a password hash in KiB"`
+	Iterations  int `yaml:"iterations" env:"PASSWORD_ARGON2_ITERATIONS" usage:"passes over the memory of a password hash"`
+	Parallelism int `yaml:"parallelism" env:"PASSWORD_ARGON2_PARALLELISM" usage:"threads computing a password hash"`
+}
+
+// Params returns the Argon2id parameters of the cost, the salt and key
+// lengths are the defaults.
+func (c Argon2Config) Params() utils.Argon2idParams {
+	params := utils.DefaultArgon2idParams
+	params.Memory = uint32(c.Memory)
+	params.Iterations = uint32(c.Iterations)
+	params.Parallelism = uint8(c.Parallelism)
+	return params
+}
+
+type PhoneNumberConfig struct {
+	DefaultRegion string   `yaml:"default_region" env:"PHONE_NUMBER_DEFAULT_REGION" usage:"region of the numbers without a country code, the first allowed region when empty"`
+	Regions       []string `yaml:"regions" env:"PHONE_NUMBER_REGIONS" usage:"comma separated regions of the allowed numbers"`
+}
+
+type UsernameConfig struct {
+	ReservedFile   string        `yaml:"reserved_file" env:"RESERVED_USERNAMES_FILE" usage:"file of the reserved usernames, replacing the built-in list"`
+	ChangeInterval time.Duration `yaml:"change_interval" env:"USERNAME_CHANGE_INTERVAL" usage:"minimum time between two username changes"`
+}
+
+type ProfileConfig struct {
+	CustomAttributesFile string `yaml:"custom_attributes_file" env:"CUSTOM_ATTRIBUTES_FILE" usage:"YAML file defining the custom attributes of profiles"`
+}
+
+type EmailConfig struct {
+	MailFile        string `yaml:"mail_file" env:"MAIL_FILE" usage:"file the emails are appended to, the standard output when empty"`
+	VerificationKey string `yaml:"verification_key" env:"EMAIL_VERIFICATION_KEY" secret:"true" usage:"base64 key signing the email verification tokens, random when empty"`
+	VerificationURL string `yaml:"verification_url" env:"EMAIL_VERIFICATION_URL" usage:"URL of the email verification links"`
+}
+
+type AvatarConfig struct {
+	MaxBytes int64 `yaml:"max_bytes" env:"AVATAR_MAX_BYTES" usage:"largest avatar accepted"`
+}
+
+type BlobConfig struct {
+	Store   string   `yaml:"store" env:"BLOB_STORE" usage:"where avatars are stored, local or s3"`
+	Dir     string   `yaml:"dir" env:"BLOB_DIR" usage:"directory of the local blob store"`
+	BaseURL string   `yaml:"base_url" env:"BLOB_BASE_URL" usage:"URL of the files of the local blob store, served by the server when empty"`
+	S3      S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" env:"S3_ENDPOINT" usage:"URL of the S3 compatible service"`
+	Region          string `yaml:"region" env:"S3_REGION" usage:"S3 region"`
+	Bucket          string `yaml:"bucket" env:"S3_BUCKET" usage:"S3 bucket of the avatars"`
+	AccessKeyID     string `yaml:"access_key_id" env:"S3_ACCESS_KEY_ID" usage:"S3 access key ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"S3_SECRET_ACCESS_KEY" secret:"true" usage:"S3 secret access key"`
+	PublicURL       string `yaml:"public_url" env:"S3_PUBLIC_URL" usage:"URL of the bucket, the endpoint followed by the bucket when empty"`
+}
+
+// Default returns the settings used when nothing else sets them.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:           ":1323",
+			IdempotencyTTL: 24 * time.Hour,
+		},
+		Database: DatabaseConfig{
+			TxRetries:       repository.DefaultTxRetries,
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectAttempts: repository.DefaultConnectAttempts,
+			ConnectBackoff:  500 * time.Millisecond,
+		},
+		Auth: AuthConfig{TokenTTL: 24 * time.Hour},
+		Password: PasswordConfig{Argon2: Argon2Config{
+			Memory:      int(utils.DefaultArgon2idParams.Memory),
+			Iterations:  int(utils.DefaultArgon2idParams.Iterations),
+			Parallelism: int(utils.DefaultArgon2idParams.Parallelism),
+		}},
+		Username: UsernameConfig{ChangeInterval: 30 * 24 * time.Hour},
+		Avatar:   AvatarConfig{MaxBytes: utils.DefaultAvatarMaxBytes},
+		Blob:     BlobConfig{Store: "local", Dir: "blobs"},
+	}
+}
+
+type LoadOptions struct {
+	// Flags were registered by RegisterFlags and parsed.
+	Flags *flag.FlagSet
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
+// RegisterFlags adds a flag to flags for every setting, and -config to name
+// the YAML file.
+func RegisterFlags(flags *flag.FlagSet) {
+	flags.Var(&flagValue{}, configFlag, fmt.Sprintf("YAML configuration file (%s)", ConfigFileEnv))
+	for _, s := range Default().settings() {
+		value := &flagValue{s.format(false)}
+		usage := fmt.Sprintf("%s (%s)", s.usage, s.env)
+		if s.value.Kind() == reflect.Bool {
+			flags.Var(&boolFlagValue{*value}, s.name, usage)
+		} else {
+			flags.Var(value, s.name, usage)
+		}
+	}
+}
+
+// Load returns the defaults overridden by the YAML file, then by the
+// environment, then by the flags that are set. Empty environment variables
+// are ignored, as if they were not set.
+func Load(opts LoadOptions) (*Config, error) {
+	lookupEnv := opts.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	flagValues := map[string]string{}
+	if opts.Flags != nil {
+		opts.Flags.Visit(func(f *flag.Flag) { flagValues[f.Name] = f.Value.String() })
+	}
+
+	config := Default()
+	path, ok := flagValues[configFlag]
+	if !ok {
+		path, _ = lookupEnv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range config.settings() {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", s.env, err)
+			}
+		}
+		if value, ok := flagValues[s.name]; ok {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %v", s.name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+// ValidationError lists every invalid setting of a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the settings that can be checked without using them, it
+// returns a *ValidationError.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.IdempotencyTTL > 0, "server.idempotency_ttl must be positive")
+	check(c.Database.URL != "", "database.url is required")
+	_, err := repository.ParseIsolationLevel(c.Database.IsolationLevel)
+	check(err == nil, "database.isolation_level: %v", err)
+	check(c.Database.TxRetries >= -1, "database.tx_retries must be -1 or more")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns can't be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns can't be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns can't be more than database.max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime can't be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time can't be negative")
+	check(c.Database.ConnectAttempts > 0, "database.connect_attempts must be positive")
+	check(c.Database.ConnectBackoff > 0, "database.connect_backoff must be positive")
+	check((c.Auth.PrivateKeyFile == "") == (c.Auth.PublicKeyFile == ""),
+		"auth.private_key_file and auth.public_key_file are set together")
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	check(c.Password.CommonTopN >= 0, "password.common_top_n can't be negative")
+	argon2 := c.Password.Argon2
+	check(argon2.Parallelism >= 1 && argon2.Parallelism <= math.MaxUint8,
+		"password.argon2.parallelism must be between 1 and %d", math.MaxUint8)
+	check(argon2.Iterations >= 1 && int64(argon2.Iterations) <= math.MaxUint32,
+		"password.argon2.iterations must be positive")
+	// Argon2 needs 8 KiB per thread.
+	check(argon2.Memory >= 8*argon2.Parallelism && int64(argon2.Memory) <= math.MaxUint32,
+		"password.argon2.memory must be at least 8 KiB per thread of password.argon2.parallelism")
+	check(c.Username.ChangeInterval > 0, "username.change_interval must be positive")
+	if c.Email.VerificationKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.Email.VerificationKey)
+		check(err == nil && len(key) >= 16, "email.verification_key must be at least 16 bytes encoded in base64")
+	}
+	check(c.Avatar.MaxBytes > 0, "avatar.max_bytes must be positive")
+	switch c.Blob.Store {
+	case "local":
+		check(c.Blob.Dir != "", "blob.dir is required by the local blob store")
+	case "s3":
+		check(c.Blob.S3.Endpoint != "", "blob.s3.endpoint is required by the s3 blob store")
+		check(c.Blob.S3.Bucket != "", "blob.s3.bucket is required by the s3 blob store")
+	default:
+		problems = append(problems, fmt.Sprintf("blob.store must be local or s3, not %q", c.Blob.Store))
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{problems}
+	}
+	return nil
+}
+
+// Redacted returns every setting with its value, one per line, secrets
+// hidden.
+func (c *Config) Redacted() string {
+	var b strings.Builder
+	for _, s := range c.settings() {
+		fmt.Fprintf(&b, "%s = %s\n", s.name, s.format(true))
+	}
+	return b.String()
+}
+
+// LoadKeys reads the keys signing and verifying tokens, they are nil when
+// their file is not set.
+func (c AuthConfig) LoadKeys() (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	var privateKey *rsa.PrivateKey
+	var publicKey *rsa.PublicKey
+	if c.PrivateKeyFile != "" {
+		content, err := ioutil.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read private key file: %v", err)
+		}
+		if privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(content); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+	}
+	if c.PublicKeyFile != "" {
+		content, err := ioutil.ReadFile(c.PublicKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read public key file: %v", err)
+		}
+		if publicKey, err = jwt.ParseRSAPublicKeyFromPEM(content); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse public key: %v", err)
+		}
+	}
+	return privateKey, publicKey, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a field of a Config.
+type setting struct {
+	// name is the path of yaml keys of the field, e.g. "database.url".
+	name   string
+	env    string
+	usage  string
+	secret string
+	value  reflect.Value
+}
+
+// settings returns the settings of c in the order of their fields.
+func (c *Config) settings() []setting {
+	var settings []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(name+".", v.Field(i))
+				continue
+			}
+			settings = append(settings, setting{name, field.Tag.Get("env"), field.Tag.Get("usage"),
+				field.Tag.Get("secret"), v.Field(i)})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return settings
+}
+
+// set parses value as it is written in the environment, lists are comma
+// separated.
+func (s setting) set(value string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(value)
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case s.value.Kind() == reflect.Int || s.value.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(n)
+	case s.value.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", s.value.Type())
+	}
+	return nil
+}
+
+// format returns the value as set parses it, with secrets hidden if redact
+// is set.
+func (s setting) format(redact bool) string {
+	var value string
+	switch {
+	case s.value.Type() == durationType:
+		value = time.Duration(s.value.Int()).String()
+	case s.value.Kind() == reflect.Slice:
+		value = strings.Join(s.value.Interface().([]string), ",")
+	default:
+		value = fmt.Sprint(s.value.Interface())
+	}
+	if !redact || s.secret == "" || value == "" {
+		return value
+	}
+	if s.secret == "url" {
+		if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+	}
+	return redacted
+}
+
+// flagValue holds the value of a flag until Load applies it, so that flags
+// which are not set don't override the other sources.
+type flagValue struct {
+	value string
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+// boolFlagValue can be set without a value, e.g. -server.validate_responses.
+type boolFlagValue struct {
+	flagValue
+}
+
+func (f *boolFlagValue) IsBoolFlag() bool {
+	return true
+}
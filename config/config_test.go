@@ -0,0 +1,224 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/utils"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Config Suite")
+}
+
+var _ = ginkgo.Describe("Load", func() {
+	var (
+		env   map[string]string
+		flags *flag.FlagSet
+		dir   string
+	)
+
+	ginkgo.BeforeEach(func() {
+		env = map[string]string{}
+		flags = flag.NewFlagSet("test", flag.ContinueOnError)
+		RegisterFlags(flags)
+		dir = ginkgo.GinkgoT().TempDir()
+	})
+
+	load := func(args ...string) (*Config, error) {
+		gomega.Expect(flags.Parse(args)).To(gomega.Succeed())
+		return Load(LoadOptions{Flags: flags, LookupEnv: func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		}})
+	}
+
+	writeFile := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		gomega.Expect(os.WriteFile(path, []byte(content), 0o600)).To(gomega.Succeed())
+		return path
+	}
+
+	ginkgo.It("should return the defaults when nothing is set", func() {
+		config, err := load()
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config).To(gomega.Equal(Default()))
+	})
+
+	ginkgo.It("should let the file, then the environment, then the flags override the defaults", func() {
+		env[ConfigFileEnv] = writeFile("server:\n  addr: :8080\nauth:\n  token_ttl: 1h\ndatabase:\n  url: postgres://file\n")
+		env["TOKEN_TTL"] = "2h"
+		env["DATABASE_URL"] = "postgres://env"
+
+		config, err := load("-database.url", "postgres://flag")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config.Server.Addr).To(gomega.Equal(":8080"))
+		gomega.Expect(config.Auth.TokenTTL).To(gomega.Equal(2 * time.Hour))
+		gomega.Expect(config.Database.URL).To(gomega.Equal("postgres://flag"))
+		gomega.Expect(config.Avatar.MaxBytes).To(gomega.Equal(int64(utils.DefaultAvatarMaxBytes)))
+	})
+
+	ginkgo.It("should prefer the file of the -config flag", func() {
+		env[ConfigFileEnv] = filepath.Join(dir, "missing.yaml")
+		config, err := load("-config", writeFile("server:\n  addr: :8080\n"))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config.Server.Addr).To(gomega.Equal(":8080"))
+	})
+
+	ginkgo.It("should ignore empty environment variables", func() {
+		env["SERVER_ADDR"] = ""
+		config, err := load()
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config.Server.Addr).To(gomega.Equal(":1323"))
+	})
+
+	ginkgo.It("should parse every kind of setting", func() {
+		env["PHONE_NUMBER_REGIONS"] = "ID, SG"
+		env["OPENAPI_VALIDATE_RESPONSES"] = "true"
+		config, err := load("-database.tx_retries", "-1", "-avatar.max_bytes", "1024")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config.PhoneNumber.Regions).To(gomega.Equal([]string{"ID", "SG"}))
+		gomega.Expect(config.Server.ValidateResponses).To(gomega.BeTrue())
+		gomega.Expect(config.Database.TxRetries).To(gomega.Equal(-1))
+		gomega.Expect(config.Avatar.MaxBytes).To(gomega.Equal(int64(1024)))
+	})
+
+	ginkgo.It("should set boolean flags without a value", func() {
+		config, err := load("-server.validate_responses")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(config.Server.ValidateResponses).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should reject invalid values", func() {
+		env["TOKEN_TTL"] = "a day"
+		_, err := load()
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("TOKEN_TTL")))
+	})
+
+	ginkgo.It("should reject unknown keys of the file", func() {
+		env[ConfigFileEnv] = writeFile("server:\n  port: 8080\n")
+		_, err := load()
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("port")))
+	})
+
+	ginkgo.It("should fail when the file is missing", func() {
+		env[ConfigFileEnv] = filepath.Join(dir, "missing.yaml")
+		_, err := load()
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
+
+var _ = ginkgo.Describe("Validate", func() {
+	valid := func() *Config {
+		config := Default()
+		config.Database.URL = "postgres://localhost/database"
+		return config
+	}
+
+	ginkgo.It("should accept the defaults with a database", func() {
+		gomega.Expect(valid().Validate()).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should list every problem", func() {
+		config := valid()
+		config.Database.URL = ""
+		config.Auth.TokenTTL = 0
+		config.Auth.PrivateKeyFile = "private.pem"
+		config.Email.VerificationKey = "c2hvcnQ="
+		config.Blob.Store = "s3"
+		config.Database.MaxIdleConns = 50
+		config.Database.ConnectAttempts = 0
+		config.Password.Argon2.Parallelism = 0
+
+		var validationErr *ValidationError
+		gomega.Expect(errors.As(config.Validate(), &validationErr)).To(gomega.BeTrue())
+		gomega.Expect(validationErr.Problems).To(gomega.ConsistOf(
+			"database.url is required",
+			"database.max_idle_conns can't be more than database.max_open_conns",
+			"database.connect_attempts must be positive",
+			"password.argon2.parallelism must be between 1 and 255",
+			"auth.private_key_file and auth.public_key_file are set together",
+			"auth.token_ttl must be positive",
+			"email.verification_key must be at least 16 bytes encoded in base64",
+			"blob.s3.endpoint is required by the s3 blob store",
+			"blob.s3.bucket is required by the s3 blob store",
+		))
+	})
+
+	ginkgo.It("should require 8 KiB of Argon2 memory per thread", func() {
+		config := valid()
+		config.Password.Argon2 = Argon2Config{Memory: 31, Iterations: 1, Parallelism: 4}
+		gomega.Expect(config.Validate()).To(gomega.MatchError(gomega.ContainSubstring("password.argon2.memory")))
+	})
+
+	ginkgo.It("should reject unknown isolation levels", func() {
+		config := valid()
+		config.Database.IsolationLevel = "chaotic"
+		gomega.Expect(config.Validate()).To(gomega.MatchError(gomega.ContainSubstring("database.isolation_level")))
+	})
+})
+
+var _ = ginkgo.Describe("Argon2Config", func() {
+	ginkgo.It("should default to the default Argon2id parameters", func() {
+		gomega.Expect(Default().Password.Argon2.Params()).To(gomega.Equal(utils.DefaultArgon2idParams))
+	})
+
+	ginkgo.It("should set the cost of the parameters", func() {
+		params := Argon2Config{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}.Params()
+		gomega.Expect(params.Memory).To(gomega.Equal(uint32(19 * 1024)))
+		gomega.Expect(params.Iterations).To(gomega.Equal(uint32(2)))
+		gomega.Expect(params.Parallelism).To(gomega.Equal(uint8(1)))
+		gomega.Expect(params.SaltLength).To(gomega.Equal(utils.DefaultArgon2idParams.SaltLength))
+	})
+})
+
+var _ = ginkgo.Describe("Redacted", func() {
+	ginkgo.It("should hide the secrets", func() {
+		config := Default()
+		config.Database.URL = "postgres://postgres:hunter2@db:5432/database"
+		config.Password.Peppers = "pepper"
+		config.Blob.S3.SecretAccessKey = "secret"
+
+		redactedConfig := config.Redacted()
+		gomega.Expect(redactedConfig).To(gomega.ContainSubstring("server.addr = :1323\n"))
+		gomega.Expect(redactedConfig).To(gomega.ContainSubstring("database.url = postgres://postgres:xxxxx@db:5432/database\n"))
+		gomega.Expect(redactedConfig).To(gomega.ContainSubstring("password.peppers = [redacted]\n"))
+		gomega.Expect(redactedConfig).To(gomega.ContainSubstring("blob.s3.secret_access_key = [redacted]\n"))
+		gomega.Expect(redactedConfig).NotTo(gomega.ContainSubstring("hunter2"))
+		gomega.Expect(redactedConfig).NotTo(gomega.ContainSubstring("pepper\n"))
+	})
+})
+
+var _ = ginkgo.Describe("LoadKeys", func() {
+	ginkgo.It("should return no keys when their files are not set", func() {
+		privateKey, publicKey, err := AuthConfig{}.LoadKeys()
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(privateKey).To(gomega.BeNil())
+		gomega.Expect(publicKey).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should read the keys of the files", func() {
+		privatePEM, publicPEM, err := utils.GenerateRSAKeyPair(utils.MinRSAKeyBits)
+		gomega.Expect(err).To(gomega.BeNil())
+		dir := ginkgo.GinkgoT().TempDir()
+		auth := AuthConfig{PrivateKeyFile: filepath.Join(dir, "private.pem"), PublicKeyFile: filepath.Join(dir, "public.pem")}
+		gomega.Expect(os.WriteFile(auth.PrivateKeyFile, privatePEM, 0o600)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(auth.PublicKeyFile, publicPEM, 0o600)).To(gomega.Succeed())
+
+		privateKey, publicKey, err := auth.LoadKeys()
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(publicKey).To(gomega.Equal(&privateKey.PublicKey))
+	})
+
+	ginkgo.It("should fail when a file is missing", func() {
+		_, _, err := AuthConfig{PublicKeyFile: "missing.pem"}.LoadKeys()
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Types of custom attributes.
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeInteger = "integer"
+	AttributeBoolean = "boolean"
+	AttributeDate    = "date"
+)
+
+// AttributeDefinition describes a custom attribute of user profiles.
+type AttributeDefinition struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+	// Pattern is a regular expression string values have to match, it is
+	// not anchored unless it says so.
+	Pattern string `yaml:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// AttributeSchema is the set of custom attributes administrators defined for
+// user profiles. The zero value defines none.
+type AttributeSchema struct {
+	Attributes []AttributeDefinition `yaml:"attributes"`
+}
+
+// AttributeError is a ValidationError of a single custom attribute.
+type AttributeError struct {
+	Attribute string
+	ValidationError
+}
+
+// CustomAttributesError lists every invalid custom attribute.
+type CustomAttributesError struct {
+	Violations []AttributeError
+}
+
+func (e *CustomAttributesError) Error() string {
+	return fmt.Sprintf("%d invalid custom attributes", len(e.Violations))
+}
+
+// LoadAttributeSchema reads a YAML attribute schema, e.g.
+//
+//	attributes:
+//	  - name: employee_id
+//	    type: string
+//	    required: true
+//	    pattern: "^E[0-9]{6}$"
+func LoadAttributeSchema(path string) (*AttributeSchema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attribute schema: %v", err)
+	}
+
+	var schema AttributeSchema
+	if err := yaml.Unmarshal(content, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse attribute schema: %v", err)
+	}
+
+	return &schema, schema.Compile()
+}
+
+// Compile validates the definitions and compiles their patterns, it has to be
+// called before Check on a schema that was not loaded.
+func (s *AttributeSchema) Compile() error {
+	names := map[string]bool{}
+	for i := range s.Attributes {
+		definition := &s.Attributes[i]
+		if definition.Name == "" {
+			return fmt.Errorf("attribute schema: attribute %d has no name", i+1)
+		}
+		if names[definition.Name] {
+			return fmt.Errorf("attribute schema: %s is defined twice", definition.Name)
+		}
+		names[definition.Name] = true
+
+		switch definition.Type {
+		case AttributeString, AttributeNumber, AttributeInteger, AttributeBoolean, AttributeDate:
+		default:
+			return fmt.Errorf("attribute schema: %s has unknown type %q", definition.Name, definition.Type)
+		}
+
+		if definition.Pattern != "" {
+			if definition.Type != AttributeString {
+				return fmt.Errorf("attribute schema: %s has a pattern but is not a string", definition.Name)
+			}
+			pattern, err := regexp.Compile(definition.Pattern)
+			if err != nil {
+				return fmt.Errorf("attribute schema: %s has an invalid pattern: %v", definition.Name, err)
+			}
+			definition.pattern = pattern
+		}
+	}
+	return nil
+}
+
+// Check returns a *CustomAttributesError if attributes has unknown attributes,
+// values not matching their definition or misses required attributes.
+// Violations are sorted by attribute.
+func (s *AttributeSchema) Check(attributes map[string]interface{}) error {
+	var violations []AttributeError
+	definitions := map[string]AttributeDefinition{}
+	for _, definition := range s.Attributes {
+		definitions[definition.Name] = definition
+		if _, ok := attributes[definition.Name]; definition.Required && !ok {
+			violations = append(violations, AttributeError{definition.Name, *newValidationError(CodeRequired)})
+		}
+	}
+
+	for name, value := range attributes {
+		definition, ok := definitions[name]
+		if !ok {
+			violations = append(violations, AttributeError{name, *newValidationError(CodeUnknownAttribute)})
+			continue
+		}
+		if !definition.matches(value) {
+			violations = append(violations, AttributeError{name, *newValidationError(CodeInvalidAttribute,
+				definition.Type)})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Attribute < violations[j].Attribute
+	})
+	return &CustomAttributesError{violations}
+}
+
+// matches reports whether value, as decoded from JSON, is of the type of the
+// attribute and matches its pattern.
+func (d AttributeDefinition) matches(value interface{}) bool {
+	switch d.Type {
+	case AttributeString:
+		s, ok := value.(string)
+		return ok && (d.pattern == nil || d.pattern.MatchString(s))
+	case AttributeNumber:
+		_, ok := value.(float64)
+		return ok
+	case AttributeInteger:
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case AttributeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case AttributeDate:
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(dateLayout, s)
+		return err == nil
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// jwtAuthScheme is the name of the bearer security scheme declared in api.yml.
+	jwtAuthScheme = "jwtAuth"
+	authRealm     = "user-service"
+
+	principalContextKey = "principal"
+
+	// AdminScope is granted to the tokens of administrators.
+	AdminScope = "admin"
+)
+
+var echoPathParamRegex = regexp.MustCompile(`:([^/]+)`)
+
+// GetPrincipal returns the principal put on the context by the auth middleware.
+func GetPrincipal(ctx echo.Context) (Principal, bool) {
+	principal, ok := ctx.Get(principalContextKey).(Principal)
+	return principal, ok
+}
+
+type NewAuthMiddlewareOptions struct {
+	Swagger   *openapi3.T
+	Validator Validator
+	Utils     utils.Utils
+	// Repository is asked for the current access of the user of every token.
+	Repository repository.RepositoryInterface
+}
+
+type authMiddleware struct {
+	Swagger    *openapi3.T
+	Validator  Validator
+	Utils      utils.Utils
+	Repository repository.RepositoryInterface
+}
+
+// NewAuthMiddleware authenticates every request whose operation declares the
+// jwtAuth security requirement in the OpenAPI spec. Missing or invalid
+// credentials are answered with 401, valid credentials lacking the required
+// scopes with 403. Tokens outlive changes to their user, so the user is looked
+// up on every request: the tokens of locked users are answered with 403, and
+// the admin scope is only granted to users who are still administrators.
+func NewAuthMiddleware(opts NewAuthMiddlewareOptions) echo.MiddlewareFunc {
+	m := &authMiddleware{opts.Swagger, opts.Validator, opts.Utils, opts.Repository}
+	return m.handle
+}
+
+func (m *authMiddleware) handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		requirements := m.securityRequirements(ctx)
+		if len(requirements) == 0 {
+			return next(ctx)
+		}
+
+		var scopes []string
+		for _, requirement := range requirements {
+			requiredScopes, ok := requirement[jwtAuthScheme]
+			if !ok {
+				// An empty requirement allows anonymous access.
+				if len(requirement) == 0 {
+					return next(ctx)
+				}
+				continue
+			}
+			scopes = requiredScopes
+			break
+		}
+
+		tokenString, err := m.Utils.ExtractJWTToken(ctx)
+		if err != nil {
+			return unauthorized(ctx, "", err.Error())
+		}
+
+		principal, err := m.authenticate(tokenString)
+		if err != nil {
+			return unauthorized(ctx, "invalid_token", err.Error())
+		}
+
+		access, err := m.Repository.GetUserAccess(ctx.Request().Context(), principal.UserID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return unauthorized(ctx, "invalid_token", "the user of the token no longer exists")
+		}
+		if err != nil {
+			return problem(ctx, err)
+		}
+		if access.Locked {
+			// The token is valid but can't be used, which RFC 6750 has no
+			// error code for.
+			ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf(
+				`Bearer realm="%s", error_description="the user is locked"`, authRealm))
+			return problem(ctx, newError(CodeUserLocked))
+		}
+		if !access.IsAdmin {
+			principal.Scopes = withoutScope(principal.Scopes, AdminScope)
+		}
+
+		if !principal.hasScopes(scopes) {
+			ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf(
+				`Bearer realm="%s", error="insufficient_scope", scope="%s"`, authRealm, strings.Join(scopes, " ")))
+			return problem(ctx, newError(CodeInsufficientScope))
+		}
+
+		ctx.Set(principalContextKey, principal)
+		return next(ctx)
+	}
+}
+
+// securityRequirements returns the security requirements of the operation
+// matched by the router, falling back to the top level requirements of the spec.
+func (m *authMiddleware) securityRequirements(ctx echo.Context) openapi3.SecurityRequirements {
+	if m.Swagger == nil {
+		return nil
+	}
+
+	pathItem := m.Swagger.Paths.Find(echoPathParamRegex.ReplaceAllString(ctx.Path(), "{$1}"))
+	if pathItem == nil {
+		return nil
+	}
+
+	operation := pathItem.GetOperation(ctx.Request().Method)
+	if operation == nil {
+		return nil
+	}
+
+	if operation.Security != nil {
+		return *operation.Security
+	}
+	return m.Swagger.Security
+}
+
+func (m *authMiddleware) authenticate(tokenString string) (Principal, error) {
+	token, err := m.Validator.ValidateJWTToken(tokenString)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Principal{}, fmt.Errorf("invalid token claims")
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok || userID == "" {
+		return Principal{}, fmt.Errorf("invalid token claims: user_id")
+	}
+
+	principal := Principal{UserID: userID}
+	if phoneNumber, ok := claims["phone_number"].(string); ok {
+		principal.PhoneNumber = phoneNumber
+	}
+	if locale, ok := claims["locale"].(string); ok {
+		principal.Language = locale
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	}
+	return principal, nil
+}
+
+// withoutScope returns scopes without scope.
+func withoutScope(scopes []string, scope string) []string {
+	kept := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		if s != scope {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+func unauthorized(ctx echo.Context, errorCode, message string) error {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, authRealm)
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s", error_description="%s"`,
+			errorCode, strings.ReplaceAll(message, `"`, `'`))
+	}
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, challenge)
+
+	code := CodeUnauthorized
+	if errorCode == "invalid_token" {
+		code = CodeInvalidToken
+	}
+	return problem(ctx, &Error{Code: code, Message: message})
+}
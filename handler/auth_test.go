@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("AuthMiddleware", func() {
+	var (
+		e         *echo.Echo
+		utils     mockUtils
+		validator MockValidator
+		repo      mockRepository
+		principal Principal
+		called    bool
+	)
+
+	serve := func(method, path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if authorization != "" {
+			req.Header.Set(echo.HeaderAuthorization, authorization)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	ginkgo.BeforeEach(func() {
+		swagger, err := generated.GetSwagger()
+		gomega.Expect(err).To(gomega.BeNil())
+
+		utils = NewMockUtils()
+		utils.extractJWTTokenFunc = func(ctx echo.Context) (string, error) {
+			if ctx.Request().Header.Get(echo.HeaderAuthorization) == "" {
+				return "", errors.New("Authorization header is missing")
+			}
+			return "token", nil
+		}
+		validator = NewMockValidator()
+		validator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
+			return &jwt.Token{Claims: jwt.MapClaims{"user_id": "some_user_id", "phone_number": "+6281234567890"}}, nil
+		}
+
+		repo = NewMockRepository()
+
+		called = false
+		principal = Principal{}
+		handle := func(ctx echo.Context) error {
+			called = true
+			principal, _ = GetPrincipal(ctx)
+			return ctx.NoContent(http.StatusOK)
+		}
+
+		e = echo.New()
+		e.Use(NewAuthMiddleware(NewAuthMiddlewareOptions{
+			Swagger:    swagger,
+			Validator:  &validator,
+			Utils:      &utils,
+			Repository: &repo,
+		}))
+		e.GET("/profile", handle)
+		e.POST("/login", handle)
+	})
+
+	ginkgo.It("should let requests to unsecured operations through", func() {
+		recorder := serve(http.MethodPost, "/login", "")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+		gomega.Expect(called).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should put the principal on the context for a valid token", func() {
+		recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+		gomega.Expect(principal.UserID).To(gomega.Equal("some_user_id"))
+		gomega.Expect(principal.PhoneNumber).To(gomega.Equal("+6281234567890"))
+	})
+
+	ginkgo.It("should return 401 with a challenge when the token is missing", func() {
+		recorder := serve(http.MethodGet, "/profile", "")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.Equal(`Bearer realm="user-service"`))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return 401 with invalid_token when the token does not validate", func() {
+		validator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
+			return nil, errors.New("token is expired")
+		}
+
+		recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.ContainSubstring(`error="invalid_token"`))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return 401 instead of panicking on a malformed user_id claim", func() {
+		validator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
+			return &jwt.Token{Claims: jwt.MapClaims{"user_id": 42}}, nil
+		}
+
+		recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return 401 with invalid_token when the user no longer exists", func() {
+		repo.getUserAccessFunc = func(ctx context.Context, userID string) (repository.UserAccess, error) {
+			return repository.UserAccess{}, repository.ErrUserNotFound
+		}
+
+		recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.ContainSubstring(`error="invalid_token"`))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return 403 with user_locked for the tokens of a locked user", func() {
+		repo.getUserAccessFunc = func(ctx context.Context, userID string) (repository.UserAccess, error) {
+			gomega.Expect(userID).To(gomega.Equal("some_user_id"))
+			return repository.UserAccess{Locked: true}, nil
+		}
+
+		recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusForbidden))
+		gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"user_locked"`))
+		gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.Equal(
+			`Bearer realm="user-service", error_description="the user is locked"`))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.Context("admin scope", func() {
+		ginkgo.BeforeEach(func() {
+			validator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
+				return &jwt.Token{Claims: jwt.MapClaims{"user_id": "some_user_id", "scope": "admin"}}, nil
+			}
+			swagger, _ := generated.GetSwagger()
+			swagger.Paths.Find("/profile").Get.Security = &openapi3.SecurityRequirements{{"jwtAuth": {"admin"}}}
+			e = echo.New()
+			e.Use(NewAuthMiddleware(NewAuthMiddlewareOptions{
+				Swagger: swagger, Validator: &validator, Utils: &utils, Repository: &repo}))
+			e.GET("/profile", func(ctx echo.Context) error { return ctx.NoContent(http.StatusOK) })
+		})
+
+		ginkgo.It("should let administrators through", func() {
+			repo.getUserAccessFunc = func(ctx context.Context, userID string) (repository.UserAccess, error) {
+				return repository.UserAccess{IsAdmin: true}, nil
+			}
+
+			gomega.Expect(serve(http.MethodGet, "/profile", "Bearer token").Code).To(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("should return 403 when the token lacks a required scope", func() {
+			validator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
+				return &jwt.Token{Claims: jwt.MapClaims{"user_id": "some_user_id"}}, nil
+			}
+			repo.getUserAccessFunc = func(ctx context.Context, userID string) (repository.UserAccess, error) {
+				return repository.UserAccess{IsAdmin: true}, nil
+			}
+
+			recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusForbidden))
+			gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.ContainSubstring(`error="insufficient_scope"`))
+		})
+
+		ginkgo.It("should drop the scope of an administrator whose role was revoked", func() {
+			recorder := serve(http.MethodGet, "/profile", "Bearer token")
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusForbidden))
+			gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.ContainSubstring(`error="insufficient_scope"`))
+		})
+	})
+})
@@ -1,25 +1,29 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/labstack/echo/v4"
 )
 
-func (s *Server) Register(ctx echo.Context) error {
+// Register registers a user. Retries with the Idempotency-Key of params are
+// answered by the idempotency middleware, like those of the other mutating
+// operations.
+func (s *Server) Register(ctx echo.Context, params generated.RegisterParams) error {
 	var regRequest generated.RegistrationRequest
 	if err := ctx.Bind(&regRequest); err != nil {
-		errResp := generated.RegistrationErrResponse{Message: &[]string{"Bad Request"}}
-		return ctx.JSON(http.StatusBadRequest, errResp)
+		return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
 	}
 
-	userID, errs := s.Service.Register(ctx.Request().Context(), &regRequest)
-	if len(errs) > 0 {
-		return ctx.JSON(http.StatusBadRequest, generated.RegistrationErrResponse{
-			Message: &errs,
-		})
+	userID, err := s.Service.Register(ctx.Request().Context(), &regRequest)
+	if err != nil {
+		return problem(ctx, err)
 	}
 
 	return ctx.JSON(http.StatusCreated, map[string]string{"user_id": userID})
@@ -28,51 +32,169 @@ func (s *Server) Register(ctx echo.Context) error {
 func (s *Server) Login(ctx echo.Context) error {
 	var loginRequest generated.LoginRequest
 	if err := ctx.Bind(&loginRequest); err != nil {
-		errResp := generated.ErrorResponse{Message: "Bad Request"}
-		return ctx.JSON(http.StatusBadRequest, errResp)
+		return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
 	}
 
 	token, err := s.Service.Login(ctx.Request().Context(), &loginRequest)
 	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, generated.ErrorResponse{Message: err.Error()})
+		return problem(ctx, err)
 	}
-	expireIn := "24 hours"
-	return ctx.JSON(http.StatusOK, generated.LoginResponse{Token: &token, ExpireIn: &expireIn})
+	ttl := s.Utils.TokenTTL()
+	expireIn, expiresIn := humanDuration(ttl), int(ttl/time.Second)
+	return ctx.JSON(http.StatusOK, generated.LoginResponse{Token: &token, ExpireIn: &expireIn, ExpiresIn: &expiresIn})
 }
 
-func (s *Server) GetProfile(ctx echo.Context) error {
-	token, err := s.Utils.ExtractJWTToken(ctx)
-	if err != nil {
-		return ctx.JSON(http.StatusForbidden, generated.ErrorResponse{Message: err.Error()})
+// humanDuration writes d in hours, minutes or seconds, whichever is the
+// largest unit d is a whole number of, e.g. "24 hours" or "90 minutes".
+func humanDuration(d time.Duration) string {
+	n, unit := int64(d/time.Second), "second"
+	switch {
+	case d%time.Hour == 0:
+		n, unit = int64(d/time.Hour), "hour"
+	case d%time.Minute == 0:
+		n, unit = int64(d/time.Minute), "minute"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func (s *Server) GetProfile(ctx echo.Context, params generated.GetProfileParams) error {
+	principal, ok := GetPrincipal(ctx)
+	if !ok {
+		return unauthorized(ctx, "", "Authentication required.")
 	}
 
-	userProfile, err := s.Service.GetUserProfile(ctx.Request().Context(), token)
+	userProfile, err := s.Service.GetUserProfile(ctx.Request().Context(), principal.UserID)
 	if err != nil {
-		return ctx.JSON(http.StatusForbidden, generated.ErrorResponse{Message: err.Error()})
+		return problem(ctx, err)
 	}
 
-	return ctx.JSON(http.StatusOK, userProfile)
+	if etag := profileETag(userProfile); ifNoneMatch(params.IfNoneMatch, etag) {
+		ctx.Response().Header().Set("ETag", etag)
+		return ctx.NoContent(http.StatusNotModified)
+	}
+	return writeProfile(ctx, userProfile)
 }
 
-func (s *Server) UpdateProfile(ctx echo.Context) error {
-	token, err := s.Utils.ExtractJWTToken(ctx)
-	if err != nil {
-		return ctx.JSON(http.StatusForbidden, generated.ErrorResponse{Message: err.Error()})
+func (s *Server) UpdateProfile(ctx echo.Context, params generated.UpdateProfileParams) error {
+	principal, ok := GetPrincipal(ctx)
+	if !ok {
+		return unauthorized(ctx, "", "Authentication required.")
+	}
+	versions, ok := ifMatchVersions(params.IfMatch)
+	if !ok {
+		return problem(ctx, newError(CodePreconditionFailed))
+	}
+
+	contentType, _, _ := mime.ParseMediaType(ctx.Request().Header.Get(echo.HeaderContentType))
+	if contentType == MergePatchContentType || contentType == JSONPatchContentType {
+		patch, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
+		}
+		userProfile, err := s.Service.PatchUserProfile(ctx.Request().Context(), contentType, patch, versions,
+			principal.UserID)
+		if err != nil {
+			return problem(ctx, err)
+		}
+		return writeProfile(ctx, userProfile)
 	}
 
 	var updateUserProfileRequest generated.UpdateUserProfileRequest
 	if err := ctx.Bind(&updateUserProfileRequest); err != nil {
-		errResp := generated.RegistrationErrResponse{Message: &[]string{"Bad Request"}}
-		return ctx.JSON(http.StatusBadRequest, errResp)
+		return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
+	}
+
+	userProfile, err := s.Service.UpdateUserProfile(ctx.Request().Context(), updateUserProfileRequest, versions,
+		principal.UserID)
+	if err != nil {
+		return problem(ctx, err)
+	}
+	return writeProfile(ctx, userProfile)
+}
+
+func (s *Server) UploadAvatar(ctx echo.Context, params generated.UploadAvatarParams) error {
+	principal, ok := GetPrincipal(ctx)
+	if !ok {
+		return unauthorized(ctx, "", "Authentication required.")
+	}
+
+	file, err := ctx.FormFile("avatar")
+	if errors.Is(err, http.ErrMissingFile) {
+		return problem(ctx, fieldError("avatar", newValidationError(CodeRequired)))
+	} else if err != nil {
+		return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
 	}
+	avatar, err := file.Open()
+	if err != nil {
+		return problem(ctx, err)
+	}
+	defer avatar.Close()
 
-	userProfile, err := s.Service.UpdateUserProfile(ctx.Request().Context(), updateUserProfileRequest, token)
+	userProfile, err := s.Service.UploadAvatar(ctx.Request().Context(), avatar, principal.UserID)
 	if err != nil {
-		status := http.StatusBadRequest
-		if strings.Contains(err.Error(), "Phone numbers already exists.") {
-			status = http.StatusConflict
+		return problem(ctx, err)
+	}
+	return writeProfile(ctx, userProfile)
+}
+
+func (s *Server) ChangePassword(ctx echo.Context, params generated.ChangePasswordParams) error {
+	principal, ok := GetPrincipal(ctx)
+	if !ok {
+		return unauthorized(ctx, "", "Authentication required.")
+	}
+
+	var changePasswordRequest generated.ChangePasswordRequest
+	if err := ctx.Bind(&changePasswordRequest); err != nil {
+		return problem(ctx, &Error{Code: CodeBadRequest, Message: "Bad Request"})
+	}
+
+	if err := s.Service.ChangePassword(ctx.Request().Context(), changePasswordRequest, principal.UserID); err != nil {
+		return problem(ctx, err)
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) CheckUsernameAvailability(ctx echo.Context, name string) error {
+	availability, err := s.Service.CheckUsernameAvailability(ctx.Request().Context(), name)
+	if err != nil {
+		return problem(ctx, err)
+	}
+	if availability.ReasonCode != nil {
+		if reason, ok := localize(requestLanguage(ctx), ErrorCode(*availability.ReasonCode)); ok {
+			availability.Reason = &reason
 		}
-		return ctx.JSON(status, generated.ErrorResponse{Message: err.Error()})
 	}
-	return ctx.JSON(http.StatusOK, userProfile)
+	return ctx.JSON(http.StatusOK, availability)
+}
+
+func (s *Server) SendEmailVerification(ctx echo.Context,
+	params generated.SendEmailVerificationParams) error {
+	principal, ok := GetPrincipal(ctx)
+	if !ok {
+		return unauthorized(ctx, "", "Authentication required.")
+	}
+
+	if err := s.Service.SendEmailVerification(ctx.Request().Context(), principal.UserID); err != nil {
+		return problem(ctx, err)
+	}
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+func (s *Server) VerifyEmail(ctx echo.Context, params generated.VerifyEmailParams) error {
+	if err := s.Service.VerifyEmail(ctx.Request().Context(), params.Token); err != nil {
+		return problem(ctx, err)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) HealthCheck(ctx echo.Context) error {
+	health := s.Service.Health(ctx.Request().Context())
+	if health.Status != generated.Ok {
+		return ctx.JSON(http.StatusServiceUnavailable, health)
+	}
+	return ctx.JSON(http.StatusOK, health)
 }
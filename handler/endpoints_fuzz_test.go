@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// FuzzUpdateProfileFullName sends hostile full names through PATCH /profile
+// down to the SQL driver and checks they only ever reach it as a bound
+// parameter of the expected statement.
+func FuzzUpdateProfileFullName(f *testing.F) {
+	for _, seed := range []string{
+		"John Doe",
+		"O'Brien",
+		"Robert'); DROP TABLE public.user; --",
+		"x', phone_number = '+6280000000000' --",
+		"' OR '1'='1",
+		"$1, phone_number = $2",
+		"\\'; SELECT pg_sleep(10); --",
+		"Nama \x00 Null",
+		"名前名前名前",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, fullName string) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		body, err := json.Marshal(map[string]string{"full_name": fullName})
+		if err != nil {
+			t.Skip()
+		}
+		// The name as the handler will see it, invalid UTF-8 is replaced while encoding.
+		var decoded map[string]string
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		fullName = decoded["full_name"]
+
+		server := NewServer(NewServerOptions{Repository: &repository.Repository{Db: db}})
+		normalized, err := server.Validator.NormalizeFullName(fullName)
+		isValid := err == nil
+		if isValid {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE public.user SET full_name = $1, version = version + 1 WHERE id = $2").
+				WithArgs(normalized, "1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("SELECT full_name, phone_number, email, email_verified_at IS NOT NULL, username, language, " +
+				"to_char(date_of_birth, 'YYYY-MM-DD'), gender, address, avatar_url, avatar_thumbnails, timezone, " +
+				"custom_attributes, version " +
+				"FROM public.user WHERE id = $1").
+				WithArgs("1").
+				WillReturnRows(sqlmock.NewRows([]string{"full_name", "phone_number", "email", "email_verified", "username",
+					"language", "date_of_birth", "gender", "address", "avatar_url", "avatar_thumbnails", "timezone",
+					"custom_attributes", "version"}).
+					AddRow(normalized, "+6281234567890", nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 2))
+			mock.ExpectCommit()
+		}
+
+		e := echo.New()
+		wrapper := generated.ServerInterfaceWrapper{Handler: server}
+		e.PATCH("/profile", wrapper.UpdateProfile, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				ctx.Set(principalContextKey, Principal{UserID: "1"})
+				return next(ctx)
+			}
+		})
+
+		req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+
+		if isValid && recorder.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %q, got %d: %s", fullName, recorder.Code, recorder.Body.String())
+		}
+		if !isValid && recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %q, got %d: %s", fullName, recorder.Code, recorder.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -1,44 +1,81 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/labstack/echo/v4"
 	ginkgo "github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 )
 
 type mockService struct {
-	RegisterFunc          func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string)
+	RegisterFunc          func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error)
 	LoginFunc             func(context.Context, *generated.LoginRequest) (string, error)
-	GetProfilefunc        func(ctx context.Context, token string) (generated.UserProfile, error)
-	UpdateUserProfileFunc func(ctx context.Context,
-		updateUserProfileRequest generated.UpdateUserProfileRequest, token string) (generated.UserProfile, error)
+	ChangePasswordFunc    func(ctx context.Context, req generated.ChangePasswordRequest, userID string) error
+	GetProfilefunc        func(ctx context.Context, userID string) (generated.UserProfile, error)
+	UpdateUserProfileFunc func(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
+		versions []int, userID string) (generated.UserProfile, error)
+	PatchUserProfileFunc func(ctx context.Context, contentType string, patch []byte, versions []int,
+		userID string) (generated.UserProfile, error)
+	UploadAvatarFunc              func(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error)
+	CheckUsernameAvailabilityFunc func(ctx context.Context, username string) (generated.UsernameAvailability, error)
+	SendEmailVerificationFunc     func(ctx context.Context, userID string) error
+	VerifyEmailFunc               func(ctx context.Context, token string) error
+	HealthFunc                    func(ctx context.Context) generated.Health
 }
 
 func NewMockService() mockService {
 	return mockService{
-		RegisterFunc: func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string) {
-			return "", []string{}
+		RegisterFunc: func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+			return "", nil
 		},
 		LoginFunc: func(ctx context.Context, lr *generated.LoginRequest) (string, error) {
 			return "", nil
 		},
+		ChangePasswordFunc: func(ctx context.Context,
+			changePasswordRequest generated.ChangePasswordRequest, userID string) error {
+			return nil
+		},
 		GetProfilefunc: func(ctx context.Context, userID string) (generated.UserProfile, error) {
 			return generated.UserProfile{}, nil
 		},
-		UpdateUserProfileFunc: func(ctx context.Context,
-			updateUserProfileRequest generated.UpdateUserProfileRequest, token string) (generated.UserProfile, error) {
+		UpdateUserProfileFunc: func(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
+			versions []int, userID string) (generated.UserProfile, error) {
+			return generated.UserProfile{}, nil
+		},
+		PatchUserProfileFunc: func(ctx context.Context, contentType string, patch []byte, versions []int,
+			userID string) (generated.UserProfile, error) {
+			return generated.UserProfile{}, nil
+		},
+		UploadAvatarFunc: func(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error) {
 			return generated.UserProfile{}, nil
 		},
+		CheckUsernameAvailabilityFunc: func(ctx context.Context, username string) (generated.UsernameAvailability, error) {
+			return generated.UsernameAvailability{Username: username, Available: true}, nil
+		},
+		SendEmailVerificationFunc: func(ctx context.Context, userID string) error {
+			return nil
+		},
+		VerifyEmailFunc: func(ctx context.Context, token string) error {
+			return nil
+		},
+		HealthFunc: func(ctx context.Context) generated.Health {
+			return generated.Health{Status: generated.Ok}
+		},
 	}
 }
 
-func (m *mockService) Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string) {
+func (m *mockService) Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
 	return m.RegisterFunc(ctx, regRequest)
 }
 
@@ -46,14 +83,61 @@ func (m *mockService) Login(ctx context.Context, loginRequest *generated.LoginRe
 	return m.LoginFunc(ctx, loginRequest)
 }
 
+func (m *mockService) ChangePassword(ctx context.Context,
+	changePasswordRequest generated.ChangePasswordRequest, userID string) error {
+	return m.ChangePasswordFunc(ctx, changePasswordRequest, userID)
+}
+
 func (m *mockService) GetUserProfile(ctx context.Context, token string) (generated.UserProfile, error) {
 	return m.GetProfilefunc(ctx, token)
 }
 
-func (m *mockService) UpdateUserProfile(ctx context.Context,
-	updateUserProfileRequest generated.UpdateUserProfileRequest, token string) (generated.UserProfile, error) {
-	return m.UpdateUserProfileFunc(ctx, updateUserProfileRequest, token)
+func (m *mockService) UpdateUserProfile(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
+	versions []int, token string) (generated.UserProfile, error) {
+	return m.UpdateUserProfileFunc(ctx, updateUserProfileRequest, versions, token)
+
+}
+
+func (m *mockService) PatchUserProfile(ctx context.Context, contentType string, patch []byte, versions []int,
+	userID string) (generated.UserProfile, error) {
+	return m.PatchUserProfileFunc(ctx, contentType, patch, versions, userID)
+}
+
+func (m *mockService) UploadAvatar(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error) {
+	return m.UploadAvatarFunc(ctx, avatar, userID)
+}
+
+func (m *mockService) CheckUsernameAvailability(ctx context.Context,
+	username string) (generated.UsernameAvailability, error) {
+	return m.CheckUsernameAvailabilityFunc(ctx, username)
+}
 
+func (m *mockService) SendEmailVerification(ctx context.Context, userID string) error {
+	return m.SendEmailVerificationFunc(ctx, userID)
+}
+
+func (m *mockService) VerifyEmail(ctx context.Context, token string) error {
+	return m.VerifyEmailFunc(ctx, token)
+}
+
+func (m *mockService) CreateAdmin(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+	return "", nil
+}
+
+func (m *mockService) ResetPassword(ctx context.Context, phoneNumber, password string) error {
+	return nil
+}
+
+func (m *mockService) LockUser(ctx context.Context, phoneNumber string, locked bool) error {
+	return nil
+}
+
+func (m *mockService) NormalizePhoneNumbers(ctx context.Context) (PhoneNumberNormalization, error) {
+	return PhoneNumberNormalization{}, nil
+}
+
+func (m *mockService) Health(ctx context.Context) generated.Health {
+	return m.HealthFunc(ctx)
 }
 
 var _ = ginkgo.Describe("endpoints", func() {
@@ -61,15 +145,18 @@ var _ = ginkgo.Describe("endpoints", func() {
 		server *Server
 		svc    mockService
 		repo   mockRepository
+		utils  mockUtils
 	)
 
 	ginkgo.BeforeEach(func() {
 		repo = NewMockRepository()
 		svc = NewMockService()
+		utils = NewMockUtils()
 
 		server = &Server{
 			Repository: &repo,
 			Service:    &svc,
+			Utils:      &utils,
 		}
 	})
 
@@ -77,8 +164,8 @@ var _ = ginkgo.Describe("endpoints", func() {
 		ginkgo.Context("when request body is valid", func() {
 			ginkgo.It("should return 201 Created with user_id", func() {
 				// Prepare the request and response recorder
-				svc.RegisterFunc = func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string) {
-					return "some_user_id", []string{}
+				svc.RegisterFunc = func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+					return "some_user_id", nil
 				}
 				body := `{"username": "testuser", "password": "password123"}`
 				req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
@@ -86,7 +173,7 @@ var _ = ginkgo.Describe("endpoints", func() {
 				recorder := httptest.NewRecorder()
 
 				// Call the function
-				err := server.Register(echo.New().NewContext(req, recorder))
+				err := server.Register(echo.New().NewContext(req, recorder), generated.RegisterParams{})
 
 				// Assertions
 				gomega.Expect(err).To(gomega.BeNil())
@@ -98,9 +185,9 @@ var _ = ginkgo.Describe("endpoints", func() {
 		})
 
 		ginkgo.Context("when request body is invalid", func() {
-			ginkgo.It("should return 400 Bad Request with error message", func() {
-				svc.RegisterFunc = func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string) {
-					return "", []string{"Bad Request"}
+			ginkgo.It("should return 400 Bad Request with the invalid fields", func() {
+				svc.RegisterFunc = func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+					return "", fieldError("full_name", newValidationError(CodeInvalidFullName))
 				}
 				// Prepare the request and response recorder
 				body := `{"invalid_field": "testuser", "password": "password123"}`
@@ -110,15 +197,497 @@ var _ = ginkgo.Describe("endpoints", func() {
 				ctx := echo.New().NewContext(req, recorder)
 
 				// Call the function
-				err := server.Register(ctx)
+				err := server.Register(ctx, generated.RegisterParams{})
 
 				// Assertions
 				gomega.Expect(err).To(gomega.BeNil())
 				gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
 
-				expectedResponse := `{"message": ["Bad Request"]}`
+				gomega.Expect(recorder.Header().Get(echo.HeaderContentType)).To(gomega.Equal("application/problem+json"))
+				expectedResponse := `{
+					"type": "about:blank",
+					"title": "Bad Request",
+					"status": 400,
+					"detail": "The request has invalid fields.",
+					"code": "validation_failed",
+					"errors": [{
+						"field": "full_name",
+						"code": "invalid_full_name",
+						"message": "Full name must be at minimum 3 characters and maximum 60 characters."
+					}]
+				}`
 				gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(expectedResponse))
 			})
 		})
 	})
+
+	ginkgo.Describe("Login", func() {
+		ginkgo.It("should tell how long the token is valid from the configured TTL", func() {
+			svc.LoginFunc = func(ctx context.Context, lr *generated.LoginRequest) (string, error) {
+				return "some_token", nil
+			}
+			utils.tokenTTL = 90 * time.Minute
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+
+			gomega.Expect(server.Login(echo.New().NewContext(req, recorder))).To(gomega.Succeed())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(
+				`{"token": "some_token", "expire_in": "90 minutes", "expires_in": 5400}`))
+		})
+	})
+
+	ginkgo.DescribeTable("humanDuration",
+		func(d time.Duration, expected string) {
+			gomega.Expect(humanDuration(d)).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("hours", 24*time.Hour, "24 hours"),
+		ginkgo.Entry("one hour", time.Hour, "1 hour"),
+		ginkgo.Entry("minutes", 90*time.Minute, "90 minutes"),
+		ginkgo.Entry("seconds", 90*time.Second+500*time.Millisecond, "90 seconds"),
+	)
+
+	ginkgo.Describe("GetProfile", func() {
+		ginkgo.It("should return 401 when no principal is on the context", func() {
+			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			recorder := httptest.NewRecorder()
+
+			err := server.GetProfile(echo.New().NewContext(req, recorder), generated.GetProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnauthorized))
+			gomega.Expect(recorder.Header().Get(echo.HeaderWWWAuthenticate)).To(gomega.HavePrefix("Bearer"))
+		})
+
+		ginkgo.It("should return the profile of the authenticated user", func() {
+			fullName := "John Doe"
+			svc.GetProfilefunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+				gomega.Expect(userID).To(gomega.Equal("some_user_id"))
+				return generated.UserProfile{FullName: &fullName}, nil
+			}
+			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.GetProfile(ctx, generated.GetProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(`{"full_name": "John Doe"}`))
+		})
+
+		ginkgo.Context("with a version", func() {
+			var ctx echo.Context
+			var recorder *httptest.ResponseRecorder
+
+			ginkgo.BeforeEach(func() {
+				version := 7
+				svc.GetProfilefunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+					return generated.UserProfile{Version: &version}, nil
+				}
+				recorder = httptest.NewRecorder()
+				ctx = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/profile", nil), recorder)
+				ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+			})
+
+			ginkgo.It("should return the ETag of the version", func() {
+				ifNoneMatch := `"6"`
+				err := server.GetProfile(ctx, generated.GetProfileParams{IfNoneMatch: &ifNoneMatch})
+
+				gomega.Expect(err).To(gomega.BeNil())
+				gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+				gomega.Expect(recorder.Header().Get("ETag")).To(gomega.Equal(`"7"`))
+			})
+
+			ginkgo.It("should return 304 Not Modified when the cached version is current", func() {
+				ifNoneMatch := `"6", W/"7"`
+				err := server.GetProfile(ctx, generated.GetProfileParams{IfNoneMatch: &ifNoneMatch})
+
+				gomega.Expect(err).To(gomega.BeNil())
+				gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusNotModified))
+				gomega.Expect(recorder.Header().Get("ETag")).To(gomega.Equal(`"7"`))
+				gomega.Expect(recorder.Body.Len()).To(gomega.BeZero())
+			})
+		})
+	})
+
+	ginkgo.Describe("ChangePassword", func() {
+		ginkgo.It("should return 204 No Content when the password was changed", func() {
+			svc.ChangePasswordFunc = func(ctx context.Context, req generated.ChangePasswordRequest, userID string) error {
+				gomega.Expect(userID).To(gomega.Equal("some_user_id"))
+				gomega.Expect(req.NewPassword).To(gomega.Equal("N3w-P@ssword"))
+				return nil
+			}
+			body := `{"current_password": "P@ssw0rd", "new_password": "N3w-P@ssword"}`
+			req := httptest.NewRequest(http.MethodPut, "/profile/password", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.ChangePassword(ctx, generated.ChangePasswordParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusNoContent))
+		})
+
+		ginkgo.It("should return 400 Bad Request with every error", func() {
+			svc.ChangePasswordFunc = func(ctx context.Context, req generated.ChangePasswordRequest, userID string) error {
+				return fieldError("current_password", newValidationError(CodeWrongPassword))
+			}
+			body := `{"current_password": "wrong", "new_password": "N3w-P@ssword"}`
+			req := httptest.NewRequest(http.MethodPut, "/profile/password", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.ChangePassword(ctx, generated.ChangePasswordParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(
+				`"errors":[{"field":"current_password","code":"wrong_password","message":"Wrong password"}]`))
+		})
+	})
+
+	ginkgo.Describe("UploadAvatar", func() {
+		upload := func(field string) (*httptest.ResponseRecorder, error) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, err := form.CreateFormFile(field, "me.png")
+			gomega.Expect(err).To(gomega.BeNil())
+			_, err = part.Write([]byte("image"))
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(form.Close()).To(gomega.Succeed())
+
+			req := httptest.NewRequest(http.MethodPut, "/profile/avatar", &body)
+			req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+			return recorder, server.UploadAvatar(ctx, generated.UploadAvatarParams{})
+		}
+
+		ginkgo.It("should pass the uploaded file to the service", func() {
+			var uploaded []byte
+			svc.UploadAvatarFunc = func(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error) {
+				uploaded, _ = io.ReadAll(avatar)
+				avatarURL := "/blobs/avatars/some_user_id/512.jpg"
+				return generated.UserProfile{AvatarUrl: &avatarURL}, nil
+			}
+
+			recorder, err := upload("avatar")
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(uploaded).To(gomega.Equal([]byte("image")))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(`{"avatar_url": "/blobs/avatars/some_user_id/512.jpg"}`))
+		})
+
+		ginkgo.It("should require the avatar field", func() {
+			recorder, err := upload("picture")
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"field":"avatar","code":"required"`))
+		})
+	})
+
+	ginkgo.Describe("UpdateProfile", func() {
+		ginkgo.It("should return 429 Too Many Requests when the username changed recently", func() {
+			svc.UpdateUserProfileFunc = func(ctx context.Context,
+				req generated.UpdateUserProfileRequest, versions []int, userID string) (generated.UserProfile, error) {
+				return generated.UserProfile{}, repositoryError(&repository.UsernameChangeTooSoonError{
+					NextChangeAt: time.Now().Add(time.Hour),
+				})
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"username": "john"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusTooManyRequests))
+			gomega.Expect(recorder.Header().Get("Retry-After")).To(gomega.Equal("3600"))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"username_change_too_soon"`))
+		})
+
+		ginkgo.It("should return 409 Conflict when the username is taken", func() {
+			svc.UpdateUserProfileFunc = func(ctx context.Context,
+				req generated.UpdateUserProfileRequest, versions []int, userID string) (generated.UserProfile, error) {
+				return generated.UserProfile{}, fieldError("username",
+					newValidationError(CodeUsernameTaken))
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"username": "john"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusConflict))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"conflict"`))
+		})
+
+		ginkgo.It("should return 500 without details for unexpected errors", func() {
+			svc.UpdateUserProfileFunc = func(ctx context.Context,
+				req generated.UpdateUserProfileRequest, versions []int, userID string) (generated.UserProfile, error) {
+				return generated.UserProfile{}, errors.New("pq: connection refused")
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"username": "john"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusInternalServerError))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"internal_error"`))
+			gomega.Expect(recorder.Body.String()).NotTo(gomega.ContainSubstring("pq:"))
+		})
+
+		ginkgo.It("should pass the versions of If-Match to the service", func() {
+			var versions []int
+			svc.UpdateUserProfileFunc = func(ctx context.Context,
+				req generated.UpdateUserProfileRequest, v []int, userID string) (generated.UserProfile, error) {
+				versions = v
+				version := 8
+				return generated.UserProfile{Version: &version}, nil
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"address": "Jakarta"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			ifMatch := `"6", W/"5", "7"`
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{IfMatch: &ifMatch})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Header().Get("ETag")).To(gomega.Equal(`"8"`))
+			gomega.Expect(versions).To(gomega.Equal([]int{6, 7}))
+		})
+
+		ginkgo.It("should return 412 Precondition Failed when If-Match can't match", func() {
+			called := false
+			svc.UpdateUserProfileFunc = func(ctx context.Context,
+				req generated.UpdateUserProfileRequest, versions []int, userID string) (generated.UserProfile, error) {
+				called = true
+				return generated.UserProfile{}, nil
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"address": "Jakarta"}`))
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			ifMatch := `W/"7"`
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{IfMatch: &ifMatch})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusPreconditionFailed))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"precondition_failed"`))
+			gomega.Expect(called).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should pass patches to the service with their content type", func() {
+			var contentType, patch string
+			svc.PatchUserProfileFunc = func(ctx context.Context, ct string, p []byte, versions []int,
+				userID string) (generated.UserProfile, error) {
+				contentType, patch = ct, string(p)
+				return generated.UserProfile{}, nil
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"address": null}`))
+			req.Header.Set("Content-Type", "application/merge-patch+json; charset=utf-8")
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			ctx.Set(principalContextKey, Principal{UserID: "some_user_id"})
+
+			err := server.UpdateProfile(ctx, generated.UpdateProfileParams{})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(contentType).To(gomega.Equal(MergePatchContentType))
+			gomega.Expect(patch).To(gomega.Equal(`{"address": null}`))
+		})
+	})
+
+	ginkgo.Describe("CheckUsernameAvailability", func() {
+		ginkgo.It("should return the availability of the normalized username", func() {
+			svc.CheckUsernameAvailabilityFunc = func(ctx context.Context,
+				username string) (generated.UsernameAvailability, error) {
+				gomega.Expect(username).To(gomega.Equal("John"))
+				reason, reasonCode := "Username is reserved.", "username_reserved"
+				return generated.UsernameAvailability{Username: "john", Available: false, Reason: &reason,
+					ReasonCode: &reasonCode}, nil
+			}
+			req := httptest.NewRequest(http.MethodGet, "/usernames/John/availability", nil)
+			recorder := httptest.NewRecorder()
+
+			err := server.CheckUsernameAvailability(echo.New().NewContext(req, recorder), "John")
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(
+				`{"username": "john", "available": false, "reason": "Username is reserved.",
+				"reason_code": "username_reserved"}`))
+		})
+
+		ginkgo.It("should return 400 Bad Request for invalid usernames", func() {
+			svc.CheckUsernameAvailabilityFunc = func(ctx context.Context,
+				username string) (generated.UsernameAvailability, error) {
+				return generated.UsernameAvailability{}, fieldError("name",
+					newValidationError(CodeInvalidUsername))
+			}
+			req := httptest.NewRequest(http.MethodGet, "/usernames/j/availability", nil)
+			recorder := httptest.NewRecorder()
+
+			err := server.CheckUsernameAvailability(echo.New().NewContext(req, recorder), "j")
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+		})
+	})
+
+	ginkgo.Describe("HealthCheck", func() {
+		ginkgo.It("should return 200 OK when the database is up", func() {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			recorder := httptest.NewRecorder()
+
+			err := server.HealthCheck(echo.New().NewContext(req, recorder))
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"status":"ok"`))
+		})
+
+		ginkgo.It("should return 503 Service Unavailable when the database is down", func() {
+			svc.HealthFunc = func(ctx context.Context) generated.Health {
+				return generated.Health{Status: generated.Unavailable}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			recorder := httptest.NewRecorder()
+
+			err := server.HealthCheck(echo.New().NewContext(req, recorder))
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusServiceUnavailable))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"status":"unavailable"`))
+		})
+	})
+
+	ginkgo.Describe("ErrorHandler", func() {
+		ginkgo.It("should render echo errors as problems", func() {
+			req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+			recorder := httptest.NewRecorder()
+
+			ErrorHandler(echo.ErrNotFound, echo.New().NewContext(req, recorder))
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusNotFound))
+			gomega.Expect(recorder.Header().Get(echo.HeaderContentType)).To(gomega.Equal("application/problem+json"))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(
+				`{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "Not Found", "code": "not_found"}`))
+		})
+
+		ginkgo.DescribeTable("should give echo errors the code of their status",
+			func(httpErr *echo.HTTPError, code ErrorCode) {
+				req := httptest.NewRequest(http.MethodPost, "/register", nil)
+				recorder := httptest.NewRecorder()
+
+				ErrorHandler(httpErr, echo.New().NewContext(req, recorder))
+
+				gomega.Expect(recorder.Code).To(gomega.Equal(httpErr.Code))
+				gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"code":"` + string(code) + `"`))
+			},
+			ginkgo.Entry("400", echo.ErrBadRequest, CodeBadRequest),
+			ginkgo.Entry("401", echo.ErrUnauthorized, CodeUnauthorized),
+			ginkgo.Entry("404", echo.ErrNotFound, CodeNotFound),
+			ginkgo.Entry("405", echo.ErrMethodNotAllowed, CodeMethodNotAllowed),
+			ginkgo.Entry("413", echo.ErrStatusRequestEntityTooLarge, CodePayloadTooLarge),
+			ginkgo.Entry("415", echo.ErrUnsupportedMediaType, CodeUnsupportedMediaType),
+		)
+	})
+
+	ginkgo.Describe("localized messages", func() {
+		var invalidFullName error
+
+		ginkgo.BeforeEach(func() {
+			invalidFullName = fieldError("full_name", newValidationError(CodeInvalidFullName))
+			svc.RegisterFunc = func(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+				return "", invalidFullName
+			}
+		})
+
+		register := func(acceptLanguage string, principal *Principal) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Language", acceptLanguage)
+			recorder := httptest.NewRecorder()
+			ctx := echo.New().NewContext(req, recorder)
+			if principal != nil {
+				ctx.Set(principalContextKey, *principal)
+			}
+			gomega.Expect(server.Register(ctx, generated.RegisterParams{})).To(gomega.Succeed())
+			return recorder
+		}
+
+		ginkgo.It("should answer in the language preferred in Accept-Language with the same codes", func() {
+			recorder := register("fr-FR, id;q=0.8, en;q=0.5", nil)
+
+			gomega.Expect(recorder.Header().Get("Content-Language")).To(gomega.Equal("id-ID"))
+			gomega.Expect(recorder.Body.String()).To(gomega.MatchJSON(`{
+				"type": "about:blank",
+				"title": "Bad Request",
+				"status": 400,
+				"detail": "Permintaan memiliki isian yang tidak valid.",
+				"code": "validation_failed",
+				"errors": [{
+					"field": "full_name",
+					"code": "invalid_full_name",
+					"message": "Nama lengkap harus terdiri dari minimal 3 karakter dan maksimal 60 karakter."
+				}]
+			}`))
+		})
+
+		ginkgo.It("should fill in the arguments of the messages", func() {
+			invalidFullName = fieldError("password", newValidationError(CodePasswordTooShort, 8))
+
+			recorder := register("id-ID", nil)
+
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring("Kata sandi harus memiliki minimal 8 karakter."))
+		})
+
+		ginkgo.It("should prefer the language on the profile of the user", func() {
+			recorder := register("id-ID", &Principal{UserID: "1", Language: "en-US"})
+
+			gomega.Expect(recorder.Header().Get("Content-Language")).To(gomega.Equal("en-US"))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring("The request has invalid fields."))
+		})
+
+		ginkgo.It("should fall back to English", func() {
+			recorder := register("fr-FR", nil)
+
+			gomega.Expect(recorder.Header().Get("Content-Language")).To(gomega.Equal("en-US"))
+		})
+
+		ginkgo.It("should have every English message in every language", func() {
+			for _, lang := range supportedLanguages {
+				for code := range catalog[english] {
+					_, ok := localize(lang, code)
+					gomega.Expect(ok).To(gomega.BeTrue(), "%s has no message for %s", lang, code)
+				}
+			}
+		})
+	})
 })
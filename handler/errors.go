@@ -0,0 +1,381 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorCode identifies a kind of failure. Codes are part of the API, clients
+// branch on them instead of on messages, so they must never change once
+// released.
+type ErrorCode string
+
+// Codes of whole requests.
+const (
+	CodeBadRequest            ErrorCode = "bad_request"
+	CodeValidationFailed      ErrorCode = "validation_failed"
+	CodeConflict              ErrorCode = "conflict"
+	CodeInvalidCredentials    ErrorCode = "invalid_credentials"
+	CodeUserLocked            ErrorCode = "user_locked"
+	CodePasswordExpired       ErrorCode = "password_expired"
+	CodeUnauthorized          ErrorCode = "unauthorized"
+	CodeInvalidToken          ErrorCode = "invalid_token"
+	CodeInsufficientScope     ErrorCode = "insufficient_scope"
+	CodeUserNotFound          ErrorCode = "user_not_found"
+	CodeNotFound              ErrorCode = "not_found"
+	CodeMethodNotAllowed      ErrorCode = "method_not_allowed"
+	CodePayloadTooLarge       ErrorCode = "payload_too_large"
+	CodeUnsupportedMediaType  ErrorCode = "unsupported_media_type"
+	CodeUsernameChangeTooSoon ErrorCode = "username_change_too_soon"
+	CodeNoEmail               ErrorCode = "no_email"
+	CodeEmailAlreadyVerified  ErrorCode = "email_already_verified"
+	CodeInvalidEmailToken     ErrorCode = "invalid_email_verification_token"
+	CodeInvalidPatch          ErrorCode = "invalid_patch"
+	CodePatchTestFailed       ErrorCode = "patch_test_failed"
+	CodePreconditionFailed    ErrorCode = "precondition_failed"
+	CodeInvalidIdempotencyKey ErrorCode = "invalid_idempotency_key"
+	CodeIdempotencyKeyNotUUID ErrorCode = "idempotency_key_not_uuid"
+	CodeIdempotencyInProgress ErrorCode = "idempotency_key_in_progress"
+	CodeIdempotencyKeyReused  ErrorCode = "idempotency_key_reused"
+	CodeInternal              ErrorCode = "internal_error"
+)
+
+// Codes of single fields.
+const (
+	CodeInvalidPhoneNumber          ErrorCode = "invalid_phone_number"
+	CodePhoneNumberRegionNotAllowed ErrorCode = "phone_number_region_not_allowed"
+	CodePhoneNumberTaken            ErrorCode = "phone_number_taken"
+	CodeInvalidEmail                ErrorCode = "invalid_email"
+	CodeEmailTaken                  ErrorCode = "email_taken"
+	CodeInvalidUsername             ErrorCode = "invalid_username"
+	CodeMixedScriptUsername         ErrorCode = "mixed_script_username"
+	CodeUsernameReserved            ErrorCode = "username_reserved"
+	CodeUsernameTaken               ErrorCode = "username_taken"
+	CodeInvalidFullName             ErrorCode = "invalid_full_name"
+	CodeRequired                    ErrorCode = "required"
+	CodeInvalidValue                ErrorCode = "invalid_value"
+	CodeUnsupportedLanguage         ErrorCode = "unsupported_language"
+	CodeInvalidDateOfBirth          ErrorCode = "invalid_date_of_birth"
+	CodeInvalidGender               ErrorCode = "invalid_gender"
+	CodeInvalidAddress              ErrorCode = "invalid_address"
+	CodeInvalidAvatarURL            ErrorCode = "invalid_avatar_url"
+	CodeInvalidAvatar               ErrorCode = "invalid_avatar"
+	CodeAvatarTooLarge              ErrorCode = "avatar_too_large"
+	CodeInvalidTimezone             ErrorCode = "invalid_timezone"
+	CodeUnknownField                ErrorCode = "unknown_field"
+	CodeUnknownAttribute            ErrorCode = "unknown_attribute"
+	CodeInvalidAttribute            ErrorCode = "invalid_attribute"
+	CodePasswordTooShort            ErrorCode = "password_too_short"
+	CodePasswordTooLong             ErrorCode = "password_too_long"
+	CodePasswordNoUpper             ErrorCode = "password_no_upper"
+	CodePasswordNoLower             ErrorCode = "password_no_lower"
+	CodePasswordNoDigit             ErrorCode = "password_no_digit"
+	CodePasswordNoSpecial           ErrorCode = "password_no_special"
+	CodePasswordTooWeak             ErrorCode = "password_too_weak"
+	CodePasswordPersonalInfo        ErrorCode = "password_contains_personal_info"
+	CodePasswordCommon              ErrorCode = "password_common"
+	CodePasswordBreached            ErrorCode = "password_breached"
+	CodePasswordReused              ErrorCode = "password_reused"
+	CodeWrongPassword               ErrorCode = "wrong_password"
+)
+
+var statusByCode = map[ErrorCode]int{
+	CodeBadRequest:            http.StatusBadRequest,
+	CodeValidationFailed:      http.StatusBadRequest,
+	CodeConflict:              http.StatusConflict,
+	CodeInvalidCredentials:    http.StatusBadRequest,
+	CodeUserLocked:            http.StatusForbidden,
+	CodePasswordExpired:       http.StatusForbidden,
+	CodeUnauthorized:          http.StatusUnauthorized,
+	CodeInvalidToken:          http.StatusUnauthorized,
+	CodeInsufficientScope:     http.StatusForbidden,
+	CodeUserNotFound:          http.StatusNotFound,
+	CodeNotFound:              http.StatusNotFound,
+	CodeMethodNotAllowed:      http.StatusMethodNotAllowed,
+	CodePayloadTooLarge:       http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMediaType:  http.StatusUnsupportedMediaType,
+	CodeUsernameChangeTooSoon: http.StatusTooManyRequests,
+	CodeNoEmail:               http.StatusBadRequest,
+	CodeEmailAlreadyVerified:  http.StatusBadRequest,
+	CodeInvalidEmailToken:     http.StatusBadRequest,
+	CodeInvalidPatch:          http.StatusBadRequest,
+	CodePatchTestFailed:       http.StatusConflict,
+	CodePreconditionFailed:    http.StatusPreconditionFailed,
+	CodeInvalidIdempotencyKey: http.StatusBadRequest,
+	CodeIdempotencyKeyNotUUID: http.StatusBadRequest,
+	CodeIdempotencyInProgress: http.StatusConflict,
+	CodeIdempotencyKeyReused:  http.StatusUnprocessableEntity,
+	CodeInternal:              http.StatusInternalServerError,
+}
+
+// conflictCodes are the field codes of values that are valid, but taken by
+// another user.
+var conflictCodes = map[ErrorCode]bool{
+	CodePhoneNumberTaken: true,
+	CodeEmailTaken:       true,
+	CodeUsernameTaken:    true,
+}
+
+// ValidationError is a value rejected by the Validator. Message is in the
+// default language, Args fill in the message of Code in other languages.
+type ValidationError struct {
+	Code    ErrorCode
+	Message string
+	Args    []interface{}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func newValidationError(code ErrorCode, args ...interface{}) *ValidationError {
+	message, _ := localize(supportedLanguages[0], code, args...)
+	return &ValidationError{code, message, args}
+}
+
+// FieldError is a ValidationError of a field of the request.
+type FieldError struct {
+	Field   string        `json:"field"`
+	Code    ErrorCode     `json:"code"`
+	Message string        `json:"message"`
+	Args    []interface{} `json:"-"`
+}
+
+// Error is a failure reported to the client as problem details. Errors that
+// are not an *Error are reported as internal errors without any detail.
+type Error struct {
+	Code ErrorCode
+	// Message is in the default language, Args fill in the message of Code
+	// in other languages. Codes without a message in the catalog of a
+	// language keep Message.
+	Message string
+	Args    []interface{}
+	Fields  []FieldError
+	// RetryAfter is sent in the Retry-After header when it is set.
+	RetryAfter time.Duration
+	// Err is the cause, it is not shown to the client.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Message))
+	}
+	return strings.Join(messages, " ")
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns an error with the message of code in the catalog.
+func newError(code ErrorCode, args ...interface{}) *Error {
+	message, _ := localize(supportedLanguages[0], code, args...)
+	return &Error{Code: code, Message: message, Args: args}
+}
+
+// duplicateCodes are the field codes of the values rejected by the unique
+// constraints of the repository, by column.
+var duplicateCodes = map[string]ErrorCode{
+	"phone_number": CodePhoneNumberTaken,
+	"email":        CodeEmailTaken,
+	"username":     CodeUsernameTaken,
+}
+
+// repositoryError translates the errors of the repository the client can act
+// upon, other errors are returned as they are.
+func repositoryError(err error) error {
+	var tooSoon *repository.UsernameChangeTooSoonError
+	var duplicate *repository.DuplicateError
+	switch {
+	case errors.As(err, &duplicate):
+		// Values taken since the validator checked them are only caught by
+		// the unique constraints.
+		e := newError(CodeConflict)
+		if code, ok := duplicateCodes[duplicate.Column]; ok {
+			e.Fields = []FieldError{{duplicate.Column, code, newValidationError(code).Message, nil}}
+		}
+		e.Err = err
+		return e
+	case errors.As(err, &tooSoon):
+		e := newError(CodeUsernameChangeTooSoon, tooSoon.NextChangeAt.UTC().Format(time.RFC3339))
+		e.RetryAfter, e.Err = time.Until(tooSoon.NextChangeAt), err
+		return e
+	case errors.Is(err, repository.ErrUserNotFound):
+		e := newError(CodeUserNotFound)
+		e.Err = err
+		return e
+	case errors.Is(err, repository.ErrVersionMismatch):
+		e := newError(CodePreconditionFailed)
+		e.Err = err
+		return e
+	}
+	return err
+}
+
+// fieldErrors collects the validation errors of a request.
+type fieldErrors []FieldError
+
+// add records err for field. Errors that are not validation errors are
+// returned, they can't be blamed on the request.
+func (f *fieldErrors) add(field string, err error) error {
+	var validationErr *ValidationError
+	var policyErr *PasswordPolicyError
+	var attributesErr *CustomAttributesError
+	switch {
+	case errors.As(err, &policyErr):
+		for _, violation := range policyErr.Violations {
+			*f = append(*f, FieldError{field, violation.Code, violation.Message, violation.Args})
+		}
+	case errors.As(err, &attributesErr):
+		for _, violation := range attributesErr.Violations {
+			*f = append(*f, FieldError{field + "." + violation.Attribute, violation.Code, violation.Message,
+				violation.Args})
+		}
+	case errors.As(err, &validationErr):
+		*f = append(*f, FieldError{field, validationErr.Code, validationErr.Message, validationErr.Args})
+	default:
+		return err
+	}
+	return nil
+}
+
+// err returns nil without field errors. It is a conflict when every field is
+// only taken by another user, so clients can tell the user to pick another
+// value rather than to fix it.
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	code := CodeConflict
+	for _, field := range f {
+		if !conflictCodes[field.Code] {
+			code = CodeValidationFailed
+			break
+		}
+	}
+	e := newError(code)
+	e.Fields = f
+	return e
+}
+
+// fieldError is the error of a request with a single invalid field.
+func fieldError(field string, err error) error {
+	var errs fieldErrors
+	if err := errs.add(field, err); err != nil {
+		return err
+	}
+	return errs.err()
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   ErrorCode    `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+const problemContentType = "application/problem+json"
+
+// problem writes err as problem details, with the status belonging to its
+// code.
+func problem(ctx echo.Context, err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		log.Printf("%s %s: %v", ctx.Request().Method, ctx.Request().URL.Path, err)
+		e = newError(CodeInternal)
+	}
+
+	status, ok := statusByCode[e.Code]
+	if !ok {
+		status = http.StatusBadRequest
+	}
+	return writeProblem(ctx, status, e)
+}
+
+// writeProblem writes e in the language of the request, the codes stay the
+// same in every language.
+func writeProblem(ctx echo.Context, status int, e *Error) error {
+	if e.RetryAfter > 0 {
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+
+	lang := requestLanguage(ctx)
+	detail, ok := localize(lang, e.Code, e.Args...)
+	if !ok {
+		detail = e.Message
+	}
+	var fields []FieldError
+	for _, field := range e.Fields {
+		if message, ok := localize(lang, field.Code, field.Args...); ok {
+			field.Message = message
+		}
+		fields = append(fields, field)
+	}
+
+	body, err := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   e.Code,
+		Errors: fields,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.Response().Header().Set("Content-Language", lang.String())
+	return ctx.Blob(status, problemContentType, body)
+}
+
+// ErrorHandler renders the errors returned to echo, e.g. by the router or the
+// generated parameter binding, as problem details.
+func ErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		code := CodeBadRequest
+		switch httpErr.Code {
+		case http.StatusNotFound:
+			code = CodeNotFound
+		case http.StatusMethodNotAllowed:
+			code = CodeMethodNotAllowed
+		case http.StatusRequestEntityTooLarge:
+			code = CodePayloadTooLarge
+		case http.StatusUnsupportedMediaType:
+			code = CodeUnsupportedMediaType
+		case http.StatusUnauthorized:
+			code = CodeUnauthorized
+		}
+		if httpErr.Code < http.StatusInternalServerError {
+			err = writeProblem(ctx, httpErr.Code, &Error{Code: code, Message: fmt.Sprint(httpErr.Message)})
+		} else {
+			err = problem(ctx, httpErr)
+		}
+	} else {
+		err = problem(ctx, err)
+	}
+	if err != nil {
+		ctx.Logger().Error(err)
+	}
+}
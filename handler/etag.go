@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/labstack/echo/v4"
+)
+
+// profileETag is the strong ETag of the version of profile, e.g. `"7"`.
+func profileETag(profile generated.UserProfile) string {
+	if profile.Version == nil {
+		return ""
+	}
+	return strconv.Quote(strconv.Itoa(*profile.Version))
+}
+
+// writeProfile writes profile along with its ETag.
+func writeProfile(ctx echo.Context, profile generated.UserProfile) error {
+	if etag := profileETag(profile); etag != "" {
+		ctx.Response().Header().Set("ETag", etag)
+	}
+	return ctx.JSON(http.StatusOK, profile)
+}
+
+// etags splits a list of entity tags of If-Match or If-None-Match. Entity tags
+// with commas are not split correctly, but profiles never have one.
+func etags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ifMatchVersions returns the versions of the profile an If-Match header
+// allows updating, nil for any version. ok is false when none can match,
+// weak ETags never match If-Match.
+func ifMatchVersions(header *string) (versions []int, ok bool) {
+	if header == nil {
+		return nil, true
+	}
+	versions = []int{}
+	for _, tag := range etags(*header) {
+		if tag == "*" {
+			return nil, true
+		}
+		value, err := strconv.Unquote(tag)
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+		if version, err := strconv.Atoi(value); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, len(versions) > 0
+}
+
+// ifNoneMatch reports whether an If-None-Match header has etag, comparing
+// weakly as RFC 9110 requires.
+func ifNoneMatch(header *string, etag string) bool {
+	if header == nil || etag == "" {
+		return false
+	}
+	for _, tag := range etags(*header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on the responses replayed for a retry.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 255
+	// idempotencyLockTimeout is how long a request in progress holds its
+	// key, in case the service stops before it completes.
+	idempotencyLockTimeout = time.Minute
+	// idempotencyRetryAfter is suggested to clients retrying a request in
+	// progress.
+	idempotencyRetryAfter = time.Second
+)
+
+// uuidRegex matches UUIDs in their canonical form.
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// replayedHeaders are the headers of a response replayed along with its body.
+var replayedHeaders = []string{echo.HeaderContentType, "ETag", echo.HeaderLocation, "Retry-After"}
+
+type NewIdempotencyMiddlewareOptions struct {
+	Swagger    *openapi3.T
+	Repository repository.RepositoryInterface
+	// TTL is how long responses are replayed, it defaults to
+	// DefaultIdempotencyTTL.
+	TTL time.Duration
+}
+
+type idempotencyMiddleware struct {
+	Swagger    *openapi3.T
+	Repository repository.RepositoryInterface
+	TTL        time.Duration
+}
+
+// NewIdempotencyMiddleware makes the operations declaring an Idempotency-Key
+// header in the OpenAPI spec safe to retry. The response of the first request
+// with a key is stored, and replayed to the requests retrying it until the TTL
+// expires. Reusing a key for a different request is answered with 422, and
+// retrying a request still in progress with 409. Keys are kept apart by
+// principal, so it must run after the auth middleware. Anonymous clients all
+// share one scope, they have to send UUIDs so that they can't guess or
+// squat the keys of each other.
+func NewIdempotencyMiddleware(opts NewIdempotencyMiddlewareOptions) echo.MiddlewareFunc {
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	m := &idempotencyMiddleware{opts.Swagger, opts.Repository, ttl}
+	return m.handle
+}
+
+func (m *idempotencyMiddleware) handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		key := ctx.Request().Header.Get(IdempotencyKeyHeader)
+		if key == "" || !m.acceptsKey(ctx) {
+			return next(ctx)
+		}
+		if !validIdempotencyKey(key) {
+			return problem(ctx, newError(CodeInvalidIdempotencyKey, maxIdempotencyKeyLength))
+		}
+
+		var scope string
+		if principal, ok := GetPrincipal(ctx); ok {
+			scope = principal.UserID
+		} else if !uuidRegex.MatchString(key) {
+			return problem(ctx, newError(CodeIdempotencyKeyNotUUID))
+		}
+
+		fingerprint, err := requestFingerprint(ctx.Request())
+		if err != nil {
+			return err
+		}
+
+		request, reserved, err := m.Repository.ReserveIdempotencyKey(ctx.Request().Context(),
+			repository.IdempotentRequest{
+				Scope:       scope,
+				Key:         key,
+				Fingerprint: fingerprint,
+				ExpiresAt:   time.Now().Add(idempotencyLockTimeout),
+			})
+		if err != nil {
+			return problem(ctx, err)
+		}
+		if !reserved {
+			return replay(ctx, request, fingerprint)
+		}
+		return m.record(ctx, next, scope, key)
+	}
+}
+
+// acceptsKey reports whether the operation matched by the router declares the
+// Idempotency-Key header.
+func (m *idempotencyMiddleware) acceptsKey(ctx echo.Context) bool {
+	if m.Swagger == nil {
+		return false
+	}
+
+	pathItem := m.Swagger.Paths.Find(echoPathParamRegex.ReplaceAllString(ctx.Path(), "{$1}"))
+	if pathItem == nil {
+		return false
+	}
+
+	operation := pathItem.GetOperation(ctx.Request().Method)
+	if operation == nil {
+		return false
+	}
+
+	return operation.Parameters.GetByInAndName(openapi3.ParameterInHeader, IdempotencyKeyHeader) != nil
+}
+
+// record stores the response of the request holding the key, or releases the
+// key when the request failed unexpectedly so it can be retried.
+func (m *idempotencyMiddleware) record(ctx echo.Context, next echo.HandlerFunc, scope, key string) error {
+	response := ctx.Response()
+	writer := &recordingResponseWriter{ResponseWriter: response.Writer}
+	response.Writer = writer
+	if err := next(ctx); err != nil {
+		// The error is written now to record its response too.
+		ctx.Error(err)
+	}
+	response.Writer = writer.ResponseWriter
+
+	// Clients often retry because they gave up waiting, the request context
+	// may already be canceled.
+	storeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !response.Committed || response.Status >= http.StatusInternalServerError {
+		if err := m.Repository.ReleaseIdempotencyKey(storeCtx, scope, key); err != nil {
+			log.Printf("releasing idempotency key %q: %v", key, err)
+		}
+		return nil
+	}
+
+	header := http.Header{}
+	for _, name := range replayedHeaders {
+		for _, value := range response.Header().Values(name) {
+			header.Add(name, value)
+		}
+	}
+	err := m.Repository.CompleteIdempotencyKey(storeCtx, scope, key, repository.IdempotentResponse{
+		Status: response.Status,
+		Header: header,
+		Body:   writer.body.Bytes(),
+	}, time.Now().Add(m.TTL))
+	if err != nil {
+		log.Printf("storing the response of idempotency key %q: %v", key, err)
+	}
+	return nil
+}
+
+// replay answers a request retrying the request that took its key.
+func replay(ctx echo.Context, request repository.IdempotentRequest, fingerprint string) error {
+	switch {
+	case request.Fingerprint != fingerprint:
+		return problem(ctx, newError(CodeIdempotencyKeyReused))
+	case request.Response == nil:
+		e := newError(CodeIdempotencyInProgress)
+		e.RetryAfter = idempotencyRetryAfter
+		return problem(ctx, e)
+	}
+
+	response := ctx.Response()
+	for name, values := range request.Response.Header {
+		for _, value := range values {
+			response.Header().Add(name, value)
+		}
+	}
+	response.Header().Set(IdempotentReplayedHeader, "true")
+	response.WriteHeader(request.Response.Status)
+	_, err := response.Write(request.Response.Body)
+	return err
+}
+
+// validIdempotencyKey reports whether key has 1 to maxIdempotencyKeyLength
+// visible ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint is the hex SHA-256 of the method, URI, content type and
+// body of req. The body is read whole and put back for the handler.
+func requestFingerprint(req *http.Request) (string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{req.Method, req.URL.RequestURI(), req.Header.Get(echo.HeaderContentType)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recordingResponseWriter keeps a copy of the body of a response.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
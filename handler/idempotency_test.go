@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/labstack/echo/v4"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("IdempotencyMiddleware", func() {
+	// anonymousKey is the UUID anonymous clients have to send.
+	const anonymousKey = "0b0c4a5e-3f6e-4d3a-9c1b-2f8e7d6a5b4c"
+
+	var (
+		e         *echo.Echo
+		repo      mockRepository
+		requests  map[string]repository.IdempotentRequest
+		calls     int
+		status    int
+		principal *Principal
+	)
+
+	serve := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	problemCode := func(recorder *httptest.ResponseRecorder) ErrorCode {
+		var body Problem
+		gomega.Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(gomega.Succeed())
+		return body.Code
+	}
+
+	ginkgo.BeforeEach(func() {
+		swagger, err := generated.GetSwagger()
+		gomega.Expect(err).To(gomega.BeNil())
+
+		requests = map[string]repository.IdempotentRequest{}
+		repo = NewMockRepository()
+		repo.reserveIdempotencyKeyFunc = func(ctx context.Context,
+			request repository.IdempotentRequest) (repository.IdempotentRequest, bool, error) {
+			if existing, ok := requests[request.Scope+"/"+request.Key]; ok {
+				return existing, false, nil
+			}
+			requests[request.Scope+"/"+request.Key] = request
+			return request, true, nil
+		}
+		repo.completeIdempotencyKeyFunc = func(ctx context.Context, scope, key string,
+			response repository.IdempotentResponse, expiresAt time.Time) error {
+			request := requests[scope+"/"+key]
+			request.Response, request.ExpiresAt = &response, expiresAt
+			requests[scope+"/"+key] = request
+			return nil
+		}
+		repo.releaseIdempotencyKeyFunc = func(ctx context.Context, scope, key string) error {
+			delete(requests, scope+"/"+key)
+			return nil
+		}
+
+		calls, status, principal = 0, http.StatusCreated, nil
+		handle := func(ctx echo.Context) error {
+			calls++
+			if status >= http.StatusInternalServerError {
+				return errors.New("database is down")
+			}
+			ctx.Response().Header().Set("ETag", `"1"`)
+			return ctx.JSON(status, map[string]int{"call": calls})
+		}
+
+		e = echo.New()
+		e.HTTPErrorHandler = ErrorHandler
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				if principal != nil {
+					ctx.Set(principalContextKey, *principal)
+				}
+				return next(ctx)
+			}
+		})
+		e.Use(NewIdempotencyMiddleware(NewIdempotencyMiddlewareOptions{
+			Swagger:    swagger,
+			Repository: &repo,
+			TTL:        time.Hour,
+		}))
+		e.POST("/register", handle)
+		e.POST("/login", handle)
+	})
+
+	ginkgo.It("should replay the response of a retried request", func() {
+		first := serve("/register", anonymousKey, `{"full_name":"John Doe"}`)
+		gomega.Expect(first.Code).To(gomega.Equal(http.StatusCreated))
+		gomega.Expect(first.Header().Get(IdempotentReplayedHeader)).To(gomega.BeEmpty())
+		gomega.Expect(requests["/"+anonymousKey].ExpiresAt).To(gomega.BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		retry := serve("/register", anonymousKey, `{"full_name":"John Doe"}`)
+		gomega.Expect(calls).To(gomega.Equal(1))
+		gomega.Expect(retry.Code).To(gomega.Equal(http.StatusCreated))
+		gomega.Expect(retry.Body.String()).To(gomega.Equal(first.Body.String()))
+		gomega.Expect(retry.Header().Get(echo.HeaderContentType)).To(gomega.HavePrefix(echo.MIMEApplicationJSON))
+		gomega.Expect(retry.Header().Get("ETag")).To(gomega.Equal(`"1"`))
+		gomega.Expect(retry.Header().Get(IdempotentReplayedHeader)).To(gomega.Equal("true"))
+	})
+
+	ginkgo.It("should replay client errors too", func() {
+		status = http.StatusConflict
+		serve("/register", anonymousKey, `{}`)
+		status = http.StatusCreated
+
+		retry := serve("/register", anonymousKey, `{}`)
+		gomega.Expect(calls).To(gomega.Equal(1))
+		gomega.Expect(retry.Code).To(gomega.Equal(http.StatusConflict))
+	})
+
+	ginkgo.It("should reject a key reused for a different request", func() {
+		serve("/register", anonymousKey, `{"full_name":"John Doe"}`)
+
+		recorder := serve("/register", anonymousKey, `{"full_name":"Jane Doe"}`)
+		gomega.Expect(calls).To(gomega.Equal(1))
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusUnprocessableEntity))
+		gomega.Expect(problemCode(recorder)).To(gomega.Equal(CodeIdempotencyKeyReused))
+	})
+
+	ginkgo.It("should ask to retry a request still in progress later", func() {
+		repo.reserveIdempotencyKeyFunc = func(ctx context.Context,
+			request repository.IdempotentRequest) (repository.IdempotentRequest, bool, error) {
+			return request, false, nil
+		}
+
+		recorder := serve("/register", anonymousKey, `{}`)
+		gomega.Expect(calls).To(gomega.Equal(0))
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusConflict))
+		gomega.Expect(problemCode(recorder)).To(gomega.Equal(CodeIdempotencyInProgress))
+		gomega.Expect(recorder.Header().Get("Retry-After")).To(gomega.Equal("1"))
+	})
+
+	ginkgo.It("should release the key of a request that failed unexpectedly", func() {
+		status = http.StatusInternalServerError
+		gomega.Expect(serve("/register", anonymousKey, `{}`).Code).To(gomega.Equal(http.StatusInternalServerError))
+		gomega.Expect(requests).To(gomega.BeEmpty())
+
+		status = http.StatusCreated
+		gomega.Expect(serve("/register", anonymousKey, `{}`).Code).To(gomega.Equal(http.StatusCreated))
+		gomega.Expect(calls).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should keep the keys of different users apart", func() {
+		principal = &Principal{UserID: "1"}
+		serve("/register", "user-key", `{}`)
+		principal = &Principal{UserID: "2"}
+		recorder := serve("/register", "user-key", `{}`)
+
+		gomega.Expect(calls).To(gomega.Equal(2))
+		gomega.Expect(recorder.Header().Get(IdempotentReplayedHeader)).To(gomega.BeEmpty())
+		gomega.Expect(requests).To(gomega.HaveKey("1/user-key"))
+		gomega.Expect(requests).To(gomega.HaveKey("2/user-key"))
+	})
+
+	ginkgo.It("should ignore requests without a key or to operations not declaring it", func() {
+		serve("/register", "", `{}`)
+		serve("/register", "", `{}`)
+		serve("/login", anonymousKey, `{}`)
+		serve("/login", anonymousKey, `{}`)
+
+		gomega.Expect(calls).To(gomega.Equal(4))
+		gomega.Expect(requests).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should require anonymous clients to send UUIDs", func() {
+		recorder := serve("/register", "key-1", `{}`)
+		gomega.Expect(calls).To(gomega.Equal(0))
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(problemCode(recorder)).To(gomega.Equal(CodeIdempotencyKeyNotUUID))
+		gomega.Expect(requests).To(gomega.BeEmpty())
+
+		principal = &Principal{UserID: "1"}
+		gomega.Expect(serve("/register", "key-1", `{}`).Code).To(gomega.Equal(http.StatusCreated))
+	})
+
+	ginkgo.It("should reject keys with invisible or non-ASCII characters", func() {
+		recorder := serve("/register", "kunci rahasia", `{}`)
+		gomega.Expect(calls).To(gomega.Equal(0))
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(problemCode(recorder)).To(gomega.Equal(CodeInvalidIdempotencyKey))
+	})
+})
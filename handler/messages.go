@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+var (
+	english    = language.AmericanEnglish
+	indonesian = language.MustParse("id-ID")
+
+	// supportedLanguages have a complete catalog, the first one is the
+	// default.
+	supportedLanguages = []language.Tag{english, indonesian}
+	languageMatcher    = language.NewMatcher(supportedLanguages)
+)
+
+// catalog holds the messages of the error codes as fmt formats, filled in
+// with the Args of the error. Codes without an English message have a
+// message depending on the cause, e.g. the reason a token was rejected, that
+// is only replaced by a generic message in the other languages.
+var catalog = map[language.Tag]map[ErrorCode]string{
+	english: {
+		CodeValidationFailed:      "The request has invalid fields.",
+		CodeConflict:              "Some values are already taken by another user.",
+		CodeInvalidCredentials:    "Wrong phone number, email address or password.",
+		CodeUserLocked:            "This account is locked, contact support to unlock it.",
+		CodePasswordExpired:       "The password expired, log in again with a new_password to change it.",
+		CodeInsufficientScope:     "Insufficient scope.",
+		CodeUserNotFound:          "User not found.",
+		CodeUsernameChangeTooSoon: "Usernames can be changed again after %s.",
+		CodeNoEmail:               "No email address to verify.",
+		CodeEmailAlreadyVerified:  "Email address is already verified.",
+		CodeInvalidEmailToken:     "The email verification link is invalid or expired.",
+		CodeInvalidPatch:          "The patch can't be applied: %s.",
+		CodePatchTestFailed:       "The profile does not match the tests of the patch.",
+		CodePreconditionFailed:    "The profile changed since it was read, get it again before updating it.",
+		CodeInvalidIdempotencyKey: "Idempotency-Key must have 1 to %d visible ASCII characters.",
+		CodeIdempotencyKeyNotUUID: "Requests without a token must send a random UUID as Idempotency-Key.",
+		CodeIdempotencyInProgress: "A request with the same Idempotency-Key is in progress, retry later.",
+		CodeIdempotencyKeyReused:  "The Idempotency-Key was already used for a different request.",
+		CodeInternal:              "An unexpected error occurred.",
+
+		CodeInvalidPhoneNumber:          "Phone numbers must be valid numbers in international format, e.g. +6281234567890.",
+		CodePhoneNumberRegionNotAllowed: "Phone numbers must be from one of the supported countries: %s.",
+		CodePhoneNumberTaken:            "Phone numbers already exists.",
+		CodeInvalidEmail:                "Email addresses must be valid, e.g. name@example.com.",
+		CodeEmailTaken:                  "Email address already exists.",
+		CodeInvalidUsername: `Usernames must have 3 to 30 letters, numbers, "_" or ".", ` +
+			"and start and end with a letter or number.",
+		CodeMixedScriptUsername:  "Usernames must not mix letters of different scripts.",
+		CodeUsernameReserved:     "Username is reserved.",
+		CodeUsernameTaken:        "Username already exists.",
+		CodeInvalidFullName:      "Full name must be at minimum 3 characters and maximum 60 characters.",
+		CodeUnsupportedLanguage:  "Languages must be one of the supported languages: %s.",
+		CodeInvalidDateOfBirth:   `Dates of birth must be past dates written as "YYYY-MM-DD", e.g. "1990-12-31".`,
+		CodeInvalidGender:        `Gender must be "female", "male" or "other".`,
+		CodeInvalidAddress:       "Addresses must have at most %d characters and no control characters.",
+		CodeInvalidAvatarURL:     "Avatar URLs must be absolute http or https URLs.",
+		CodeInvalidAvatar:        "Avatars must be JPEG, PNG or GIF images of at least %d×%d pixels.",
+		CodeAvatarTooLarge:       "Avatars must be at most %d MB.",
+		CodeInvalidTimezone:      `Time zones must be IANA time zone names, e.g. "Asia/Jakarta".`,
+		CodeUnknownField:         "This field does not exist or can't be changed.",
+		CodeUnknownAttribute:     "This attribute is not defined.",
+		CodeInvalidAttribute:     "This value is not a valid %s for this attribute.",
+		CodeRequired:             "This field is required.",
+		CodeInvalidValue:         "This value does not match the API specification: %s.",
+		CodePasswordTooShort:     "Passwords must have at least %d characters.",
+		CodePasswordTooLong:      "Passwords must have at most %d characters.",
+		CodePasswordNoUpper:      "Passwords must contain at least 1 capital letter.",
+		CodePasswordNoLower:      "Passwords must contain at least 1 lowercase letter.",
+		CodePasswordNoDigit:      "Passwords must contain at least 1 number.",
+		CodePasswordNoSpecial:    "Passwords must contain at least 1 special character.",
+		CodePasswordTooWeak:      "Passwords must be harder to guess.",
+		CodePasswordPersonalInfo: "Passwords must not contain your phone number or name.",
+		CodePasswordCommon:       "Passwords must not be a commonly used password.",
+		CodePasswordBreached:     "Passwords must not have appeared in a data breach.",
+		CodePasswordReused:       "Passwords must not be one of your last %d passwords.",
+		CodeWrongPassword:        "Wrong password",
+	},
+	indonesian: {
+		CodeBadRequest:            "Permintaan tidak valid.",
+		CodeValidationFailed:      "Permintaan memiliki isian yang tidak valid.",
+		CodeConflict:              "Beberapa nilai sudah digunakan oleh pengguna lain.",
+		CodeInvalidCredentials:    "Nomor telepon, alamat email, atau kata sandi salah.",
+		CodeUserLocked:            "Akun ini dikunci, hubungi dukungan untuk membukanya.",
+		CodePasswordExpired:       "Kata sandi sudah kedaluwarsa, masuk lagi dengan new_password untuk menggantinya.",
+		CodeUnauthorized:          "Autentikasi diperlukan.",
+		CodeInvalidToken:          "Token tidak valid atau sudah kedaluwarsa.",
+		CodeInsufficientScope:     "Cakupan akses tidak mencukupi.",
+		CodeUserNotFound:          "Pengguna tidak ditemukan.",
+		CodeNotFound:              "Sumber daya tidak ditemukan.",
+		CodeMethodNotAllowed:      "Metode tidak diizinkan.",
+		CodePayloadTooLarge:       "Isi permintaan terlalu besar.",
+		CodeUnsupportedMediaType:  "Jenis media tidak didukung.",
+		CodeUsernameChangeTooSoon: "Nama pengguna dapat diubah lagi setelah %s.",
+		CodeNoEmail:               "Tidak ada alamat email untuk diverifikasi.",
+		CodeEmailAlreadyVerified:  "Alamat email sudah terverifikasi.",
+		CodeInvalidEmailToken:     "Tautan verifikasi email tidak valid atau sudah kedaluwarsa.",
+		CodeInvalidPatch:          "Patch tidak dapat diterapkan: %s.",
+		CodePatchTestFailed:       "Profil tidak sesuai dengan pengujian pada patch.",
+		CodePreconditionFailed:    "Profil telah berubah sejak dibaca, ambil lagi sebelum mengubahnya.",
+		CodeInvalidIdempotencyKey: "Idempotency-Key harus terdiri dari 1 sampai %d karakter ASCII yang terlihat.",
+		CodeIdempotencyKeyNotUUID: "Permintaan tanpa token harus mengirim UUID acak sebagai Idempotency-Key.",
+		CodeIdempotencyInProgress: "Permintaan dengan Idempotency-Key yang sama sedang diproses, coba lagi nanti.",
+		CodeIdempotencyKeyReused:  "Idempotency-Key sudah digunakan untuk permintaan yang berbeda.",
+		CodeInternal:              "Terjadi kesalahan yang tidak terduga.",
+
+		CodeInvalidPhoneNumber: "Nomor telepon harus berupa nomor yang valid dalam format internasional, " +
+			"mis. +6281234567890.",
+		CodePhoneNumberRegionNotAllowed: "Nomor telepon harus berasal dari salah satu negara yang didukung: %s.",
+		CodePhoneNumberTaken:            "Nomor telepon sudah terdaftar.",
+		CodeInvalidEmail:                "Alamat email harus valid, mis. nama@contoh.com.",
+		CodeEmailTaken:                  "Alamat email sudah terdaftar.",
+		CodeInvalidUsername: `Nama pengguna harus terdiri dari 3 sampai 30 huruf, angka, "_" atau ".", ` +
+			"serta diawali dan diakhiri dengan huruf atau angka.",
+		CodeMixedScriptUsername:  "Nama pengguna tidak boleh mencampur huruf dari aksara yang berbeda.",
+		CodeUsernameReserved:     "Nama pengguna sudah dicadangkan.",
+		CodeUsernameTaken:        "Nama pengguna sudah digunakan.",
+		CodeInvalidFullName:      "Nama lengkap harus terdiri dari minimal 3 karakter dan maksimal 60 karakter.",
+		CodeUnsupportedLanguage:  "Bahasa harus salah satu dari bahasa yang didukung: %s.",
+		CodeInvalidDateOfBirth:   `Tanggal lahir harus berupa tanggal yang telah lewat dengan format "YYYY-MM-DD", mis. "1990-12-31".`,
+		CodeInvalidGender:        `Jenis kelamin harus "female", "male", atau "other".`,
+		CodeInvalidAddress:       "Alamat harus memiliki maksimal %d karakter dan tanpa karakter kontrol.",
+		CodeInvalidAvatarURL:     "URL avatar harus berupa URL http atau https yang absolut.",
+		CodeInvalidAvatar:        "Avatar harus berupa gambar JPEG, PNG, atau GIF minimal %d×%d piksel.",
+		CodeAvatarTooLarge:       "Avatar harus berukuran maksimal %d MB.",
+		CodeInvalidTimezone:      `Zona waktu harus berupa nama zona waktu IANA, mis. "Asia/Jakarta".`,
+		CodeUnknownField:         "Isian ini tidak ada atau tidak dapat diubah.",
+		CodeUnknownAttribute:     "Atribut ini tidak didefinisikan.",
+		CodeInvalidAttribute:     "Nilai ini bukan %s yang valid untuk atribut ini.",
+		CodeRequired:             "Isian ini wajib diisi.",
+		CodeInvalidValue:         "Nilai ini tidak sesuai dengan spesifikasi API: %s.",
+		CodePasswordTooShort:     "Kata sandi harus memiliki minimal %d karakter.",
+		CodePasswordTooLong:      "Kata sandi harus memiliki maksimal %d karakter.",
+		CodePasswordNoUpper:      "Kata sandi harus mengandung minimal 1 huruf kapital.",
+		CodePasswordNoLower:      "Kata sandi harus mengandung minimal 1 huruf kecil.",
+		CodePasswordNoDigit:      "Kata sandi harus mengandung minimal 1 angka.",
+		CodePasswordNoSpecial:    "Kata sandi harus mengandung minimal 1 karakter khusus.",
+		CodePasswordTooWeak:      "Kata sandi harus lebih sulit ditebak.",
+		CodePasswordPersonalInfo: "Kata sandi tidak boleh mengandung nomor telepon atau nama Anda.",
+		CodePasswordCommon:       "Kata sandi tidak boleh berupa kata sandi yang umum digunakan.",
+		CodePasswordBreached:     "Kata sandi tidak boleh pernah muncul dalam kebocoran data.",
+		CodePasswordReused:       "Kata sandi tidak boleh sama dengan %d kata sandi terakhir Anda.",
+		CodeWrongPassword:        "Kata sandi salah",
+	},
+}
+
+// localize returns the message of code in lang, or false if the catalog of
+// lang has none.
+func localize(lang language.Tag, code ErrorCode, args ...interface{}) (string, bool) {
+	format, ok := catalog[lang][code]
+	if !ok {
+		return "", false
+	}
+	if len(args) == 0 {
+		return format, true
+	}
+	return fmt.Sprintf(format, args...), true
+}
+
+// matchLanguage returns the supported language closest to the preferred
+// ones, or the default language if none is close enough.
+func matchLanguage(preferred ...language.Tag) (language.Tag, bool) {
+	_, index, confidence := languageMatcher.Match(preferred...)
+	if confidence < language.High {
+		return supportedLanguages[0], false
+	}
+	return supportedLanguages[index], true
+}
+
+// requestLanguage is the language of the messages of a request: the language
+// on the profile of the authenticated user, or else the one preferred in
+// Accept-Language.
+func requestLanguage(ctx echo.Context) language.Tag {
+	if principal, ok := GetPrincipal(ctx); ok && principal.Language != "" {
+		if tag, err := language.Parse(principal.Language); err == nil {
+			if lang, ok := matchLanguage(tag); ok {
+				return lang
+			}
+		}
+	}
+
+	preferred, _, err := language.ParseAcceptLanguage(ctx.Request().Header.Get("Accept-Language"))
+	if err != nil || len(preferred) == 0 {
+		return supportedLanguages[0]
+	}
+	lang, _ := matchLanguage(preferred...)
+	return lang
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/labstack/echo/v4"
+)
+
+func init() {
+	// kin-openapi decodes JSON Patches, but not JSON Merge Patches.
+	openapi3filter.RegisterBodyDecoder(MergePatchContentType,
+		openapi3filter.RegisteredBodyDecoder(echo.MIMEApplicationJSON))
+}
+
+type NewOpenAPIValidatorOptions struct {
+	Swagger *openapi3.T
+	// ValidateResponses checks every response against the spec too, and
+	// replaces the ones that don't match with a 500. It buffers every
+	// response, so it is meant for development and tests.
+	ValidateResponses bool
+}
+
+type openAPIValidator struct {
+	Swagger           *openapi3.T
+	ValidateResponses bool
+}
+
+// NewOpenAPIValidator validates every request of an operation of the spec
+// against it, before the handler sees it. Violations are answered with 400
+// and every invalid field. Authentication is left to the auth middleware,
+// which has to run first.
+func NewOpenAPIValidator(opts NewOpenAPIValidatorOptions) echo.MiddlewareFunc {
+	v := &openAPIValidator{opts.Swagger, opts.ValidateResponses}
+	return v.handle
+}
+
+func (v *openAPIValidator) handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		route := v.route(ctx)
+		if route == nil {
+			return next(ctx)
+		}
+
+		pathParams := make(map[string]string, len(ctx.ParamNames()))
+		for i, name := range ctx.ParamNames() {
+			pathParams[name] = ctx.ParamValues()[i]
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    ctx.Request(),
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(ctx.Request().Context(), input); err != nil {
+			return problem(ctx, requestValidationError(err))
+		}
+
+		if !v.ValidateResponses {
+			return next(ctx)
+		}
+		return v.validateResponse(ctx, next, input)
+	}
+}
+
+// route finds the operation of the spec matched by the echo router.
+func (v *openAPIValidator) route(ctx echo.Context) *routers.Route {
+	if v.Swagger == nil {
+		return nil
+	}
+
+	path := echoPathParamRegex.ReplaceAllString(ctx.Path(), "{$1}")
+	pathItem := v.Swagger.Paths.Find(path)
+	if pathItem == nil {
+		return nil
+	}
+
+	method := ctx.Request().Method
+	operation := pathItem.GetOperation(method)
+	if operation == nil {
+		return nil
+	}
+
+	return &routers.Route{Spec: v.Swagger, Path: path, PathItem: pathItem, Method: method, Operation: operation}
+}
+
+func (v *openAPIValidator) validateResponse(ctx echo.Context, next echo.HandlerFunc,
+	input *openapi3filter.RequestValidationInput) error {
+	response := ctx.Response()
+	// The headers set before the handler ran, e.g. by other middlewares, are
+	// kept on the problem replacing an invalid response.
+	header := response.Header().Clone()
+	writer := &bufferedResponseWriter{ResponseWriter: response.Writer, status: http.StatusOK}
+	response.Writer = writer
+	err := next(ctx)
+	response.Writer = writer.ResponseWriter
+	if err != nil {
+		return err
+	}
+
+	validationErr := openapi3filter.ValidateResponse(ctx.Request().Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 writer.status,
+		Header:                 response.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(writer.body.Bytes())),
+		Options:                &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true},
+	})
+	if validationErr == nil {
+		return writer.flush()
+	}
+
+	// The headers of the handler, e.g. an ETag, don't describe the problem.
+	for name := range response.Header() {
+		delete(response.Header(), name)
+	}
+	for name, values := range header {
+		response.Header()[name] = values
+	}
+	response.Committed = false
+	// The problem logs validationErr as an unexpected error.
+	return problem(ctx, validationErr)
+}
+
+// requestValidationError translates the errors of openapi3filter to field
+// errors, or to a bad request if the request could not be decoded at all.
+func requestValidationError(err error) error {
+	var errs fieldErrors
+	for _, err := range flattenErrors(err) {
+		// With MultiError, the schema errors of the body are not wrapped in
+		// a RequestError.
+		if schemaErr, ok := err.(*openapi3.SchemaError); ok {
+			errs.add(schemaErrorField("", schemaErr), schemaValidationError(schemaErr))
+			continue
+		}
+
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			return err
+		}
+
+		var field string
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+
+		schemaErrs := schemaErrors(requestErr.Err)
+		switch {
+		case len(schemaErrs) > 0:
+			for _, schemaErr := range schemaErrs {
+				errs.add(schemaErrorField(field, schemaErr), schemaValidationError(schemaErr))
+			}
+		case field != "" && errors.Is(requestErr.Err, openapi3filter.ErrInvalidRequired):
+			errs.add(field, newValidationError(CodeRequired))
+		case field != "":
+			errs.add(field, newValidationError(CodeInvalidValue, requestErr.Reason))
+		default:
+			return &Error{Code: CodeBadRequest, Message: requestErr.Error(), Err: err}
+		}
+	}
+	return errs.err()
+}
+
+func flattenErrors(err error) []error {
+	var multiErr openapi3.MultiError
+	if !errors.As(err, &multiErr) {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, err := range multiErr {
+		errs = append(errs, flattenErrors(err)...)
+	}
+	return errs
+}
+
+func schemaErrors(err error) []*openapi3.SchemaError {
+	if err == nil {
+		return nil
+	}
+
+	var schemaErrs []*openapi3.SchemaError
+	for _, err := range flattenErrors(err) {
+		if schemaErr, ok := err.(*openapi3.SchemaError); ok {
+			schemaErrs = append(schemaErrs, schemaErr)
+		}
+	}
+	return schemaErrs
+}
+
+// schemaErrorField names the field of a schema error in the request body with
+// its JSON path, e.g. "full_name", or the parameter it is nested in.
+func schemaErrorField(parameter string, err *openapi3.SchemaError) string {
+	path := err.JSONPointer()
+	if parameter != "" {
+		path = append([]string{parameter}, path...)
+	}
+	if len(path) == 0 {
+		return "body"
+	}
+	return strings.Join(path, ".")
+}
+
+func schemaValidationError(err *openapi3.SchemaError) *ValidationError {
+	if err.SchemaField == "required" {
+		return newValidationError(CodeRequired)
+	}
+	return newValidationError(CodeInvalidValue, err.Reason)
+}
+
+// bufferedResponseWriter holds the response back until it is validated.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) flush() error {
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	return err
+}
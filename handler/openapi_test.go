@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/labstack/echo/v4"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("OpenAPIValidator", func() {
+	var (
+		e      *echo.Echo
+		called bool
+		reply  func(ctx echo.Context) error
+	)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	decode := func(recorder *httptest.ResponseRecorder) Problem {
+		var p Problem
+		gomega.Expect(json.Unmarshal(recorder.Body.Bytes(), &p)).To(gomega.Succeed())
+		return p
+	}
+
+	setup := func(validateResponses bool) {
+		swagger, err := generated.GetSwagger()
+		gomega.Expect(err).To(gomega.BeNil())
+
+		e = echo.New()
+		e.HTTPErrorHandler = ErrorHandler
+		e.Use(NewOpenAPIValidator(NewOpenAPIValidatorOptions{Swagger: swagger, ValidateResponses: validateResponses}))
+		handle := func(ctx echo.Context) error {
+			called = true
+			return reply(ctx)
+		}
+		e.POST("/register", handle)
+		e.GET("/unknown", handle)
+	}
+
+	ginkgo.BeforeEach(func() {
+		called = false
+		reply = func(ctx echo.Context) error {
+			return ctx.JSON(http.StatusCreated, generated.RegistrationResponse{UserId: new(string)})
+		}
+		setup(false)
+	})
+
+	ginkgo.It("should let valid requests through", func() {
+		recorder := serve(http.MethodPost, "/register",
+			`{"phone_number": "+6281234567890", "full_name": "John Doe", "password": "Password1!"}`)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusCreated))
+		gomega.Expect(called).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should let requests outside of the spec through", func() {
+		recorder := serve(http.MethodGet, "/unknown", "")
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusCreated))
+		gomega.Expect(called).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should report every field violating the spec", func() {
+		recorder := serve(http.MethodPost, "/register", `{"phone_number": "+6281234567890", "full_name": "Jo"}`)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(recorder.Header().Get(echo.HeaderContentType)).To(gomega.Equal(problemContentType))
+		gomega.Expect(called).To(gomega.BeFalse())
+		p := decode(recorder)
+		gomega.Expect(p.Code).To(gomega.Equal(CodeValidationFailed))
+		gomega.Expect(p.Errors).To(gomega.ConsistOf(
+			gomega.And(
+				gomega.HaveField("Field", "full_name"),
+				gomega.HaveField("Code", CodeInvalidValue),
+				gomega.HaveField("Message", gomega.ContainSubstring("minimum string length is 3")),
+			),
+			gomega.And(gomega.HaveField("Field", "password"), gomega.HaveField("Code", CodeRequired)),
+		))
+	})
+
+	ginkgo.It("should count the length of names in characters", func() {
+		recorder := serve(http.MethodPost, "/register",
+			`{"phone_number": "+6281234567890", "full_name": "李小龍", "password": "Password1!"}`)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusCreated))
+	})
+
+	ginkgo.It("should leave the limit of 60 characters written with combining marks to the service", func() {
+		// 60 characters, 120 code points.
+		fullName := strings.Repeat("e\u0301", 60)
+		recorder := serve(http.MethodPost, "/register",
+			`{"phone_number": "+6281234567890", "full_name": "`+fullName+`", "password": "Password1!"}`)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusCreated))
+	})
+
+	ginkgo.It("should let file uploads through", func() {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("avatar", "me.png")
+		gomega.Expect(err).To(gomega.BeNil())
+		_, err = part.Write([]byte{0x89, 'P', 'N', 'G', 0xff})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(form.Close()).To(gomega.Succeed())
+
+		var uploaded []byte
+		e.PUT("/profile/avatar", func(ctx echo.Context) error {
+			file, err := ctx.FormFile("avatar")
+			if err != nil {
+				return err
+			}
+			f, _ := file.Open()
+			defer f.Close()
+			uploaded, _ = io.ReadAll(f)
+			return ctx.NoContent(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodPut, "/profile/avatar", &body)
+		req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, req)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+		gomega.Expect(uploaded).To(gomega.Equal([]byte{0x89, 'P', 'N', 'G', 0xff}))
+	})
+
+	ginkgo.It("should let patches with null values through", func() {
+		e.PATCH("/profile", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		for contentType, patch := range map[string]string{
+			MergePatchContentType: `{"address": null}`,
+			JSONPatchContentType:  `[{"op": "replace", "path": "/address", "value": null}]`,
+		} {
+			req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(patch))
+			req.Header.Set(echo.HeaderContentType, contentType)
+			recorder := httptest.NewRecorder()
+			e.ServeHTTP(recorder, req)
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK), contentType)
+		}
+	})
+
+	ginkgo.It("should return 400 for a body that is not JSON", func() {
+		recorder := serve(http.MethodPost, "/register", `{"phone_number":`)
+
+		gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(decode(recorder).Code).To(gomega.Equal(CodeBadRequest))
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+
+	ginkgo.Context("with response validation", func() {
+		ginkgo.BeforeEach(func() {
+			setup(true)
+		})
+
+		ginkgo.It("should pass responses matching the spec", func() {
+			recorder := serve(http.MethodPost, "/register",
+				`{"phone_number": "+6281234567890", "full_name": "John Doe", "password": "Password1!"}`)
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusCreated))
+			gomega.Expect(recorder.Body.String()).To(gomega.ContainSubstring(`"user_id"`))
+		})
+
+		ginkgo.It("should replace responses drifting from the spec with a 500", func() {
+			reply = func(ctx echo.Context) error {
+				return ctx.JSON(http.StatusCreated, map[string]int64{"user_id": 42})
+			}
+
+			recorder := serve(http.MethodPost, "/register",
+				`{"phone_number": "+6281234567890", "full_name": "John Doe", "password": "Password1!"}`)
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusInternalServerError))
+			gomega.Expect(decode(recorder).Code).To(gomega.Equal(CodeInternal))
+		})
+
+		ginkgo.It("should drop the headers of the invalid response", func() {
+			reply = func(ctx echo.Context) error {
+				ctx.Response().Header().Set("ETag", `"1"`)
+				ctx.Response().Header().Set(echo.HeaderLocation, "/users/42")
+				return ctx.JSON(http.StatusCreated, map[string]int64{"user_id": 42})
+			}
+
+			recorder := serve(http.MethodPost, "/register",
+				`{"phone_number": "+6281234567890", "full_name": "John Doe", "password": "Password1!"}`)
+
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusInternalServerError))
+			gomega.Expect(recorder.Header().Get("ETag")).To(gomega.BeEmpty())
+			gomega.Expect(recorder.Header().Get(echo.HeaderLocation)).To(gomega.BeEmpty())
+			gomega.Expect(recorder.Header().Get(echo.HeaderContentType)).To(gomega.Equal("application/problem+json"))
+		})
+	})
+})
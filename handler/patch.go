@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+)
+
+// Content types of the patches of PATCH /profile, besides application/json
+// which only sets the fields it has.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// editableProfileFields are the members of the document patches apply to, in
+// the order they are validated.
+var editableProfileFields = []string{"full_name", "phone_number", "email", "username", "language",
+	"date_of_birth", "gender", "address", "avatar_url", "timezone", "custom_attributes"}
+
+// clearableProfileFields are the editable fields a patch can remove. Custom
+// attributes are removed one by one.
+var clearableProfileFields = map[string]bool{
+	"email":         true,
+	"language":      true,
+	"date_of_birth": true,
+	"gender":        true,
+	"address":       true,
+	"avatar_url":    true,
+	"timezone":      true,
+}
+
+// profileDocument returns the editable fields of profile as decoded from JSON,
+// fields without a value are absent.
+func profileDocument(profile generated.UserProfile) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(encoded, &members); err != nil {
+		return nil, err
+	}
+
+	document := map[string]interface{}{}
+	for _, name := range editableProfileFields {
+		if value, ok := members[name]; ok && value != nil {
+			document[name] = value
+		}
+	}
+	if _, ok := document["custom_attributes"]; !ok {
+		document["custom_attributes"] = map[string]interface{}{}
+	}
+	return document, nil
+}
+
+// profileChanges compares the patched document with the current one. Fields
+// with a new value are set in the request, as application/json would, and
+// removed fields are returned to be cleared. Every field that can't change
+// this way is reported at once.
+func profileChanges(current, patched map[string]interface{}) (generated.UpdateUserProfileRequest, []string,
+	error) {
+
+	var request generated.UpdateUserProfileRequest
+	var clear []string
+	var errs fieldErrors
+
+	editable := map[string]bool{}
+	for _, name := range editableProfileFields {
+		editable[name] = true
+	}
+	var unknown []string
+	for name := range patched {
+		if !editable[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs.add(name, newValidationError(CodeUnknownField))
+	}
+
+	for _, name := range editableProfileFields {
+		value := patched[name]
+		if name == "custom_attributes" {
+			if value == nil {
+				value = map[string]interface{}{}
+			}
+			if attributes, ok := value.(map[string]interface{}); ok {
+				value = attributeChanges(current[name].(map[string]interface{}), attributes)
+				if len(value.(map[string]interface{})) == 0 {
+					continue
+				}
+			}
+		} else if value == nil {
+			if current[name] == nil {
+				continue
+			}
+			if clearableProfileFields[name] {
+				clear = append(clear, name)
+			} else {
+				errs.add(name, newValidationError(CodeRequired))
+			}
+			continue
+		} else if reflect.DeepEqual(value, current[name]) {
+			continue
+		}
+
+		// Decoding the field alone into the request checks its type, the
+		// values are validated like those of application/json.
+		encoded, err := json.Marshal(map[string]interface{}{name: value})
+		if err != nil {
+			return generated.UpdateUserProfileRequest{}, nil, err
+		}
+		var typeErr *json.UnmarshalTypeError
+		if err := json.Unmarshal(encoded, &request); err != nil {
+			if !errors.As(err, &typeErr) {
+				return generated.UpdateUserProfileRequest{}, nil, err
+			}
+			expected := "a string"
+			if typeErr.Type.Kind() == reflect.Map {
+				expected = "an object"
+			}
+			errs.add(name, newValidationError(CodeInvalidValue, "value must be "+expected))
+		}
+	}
+
+	return request, clear, errs.err()
+}
+
+// attributeChanges returns the custom attributes to set to turn current into
+// patched, removed attributes are set to null.
+func attributeChanges(current, patched map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for name := range current {
+		if _, ok := patched[name]; !ok {
+			changes[name] = nil
+		}
+	}
+	for name, value := range patched {
+		if !reflect.DeepEqual(value, current[name]) {
+			changes[name] = value
+		}
+	}
+	return changes
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PasswordPolicy describes the rules a new password has to satisfy.
+type PasswordPolicy struct {
+	MinLength      int  `yaml:"min_length"`
+	MaxLength      int  `yaml:"max_length"`
+	RequireUpper   bool `yaml:"require_upper"`
+	RequireLower   bool `yaml:"require_lower"`
+	RequireDigit   bool `yaml:"require_digit"`
+	RequireSpecial bool `yaml:"require_special"`
+	// MinScore is the minimum strength score from 0 (too guessable) to 4
+	// (very unguessable), on the same scale as zxcvbn.
+	MinScore int `yaml:"min_score"`
+	// HistoryDepth is how many previous passwords can't be reused.
+	HistoryDepth int `yaml:"history_depth"`
+	// MaxAge after which a password has to be changed, 0 means never.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:      6,
+	MaxLength:      64,
+	RequireUpper:   true,
+	RequireDigit:   true,
+	RequireSpecial: true,
+}
+
+// PasswordPolicyError lists every rule of the policy a password violates.
+type PasswordPolicyError struct {
+	Violations []ValidationError
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return strings.Join(messages, " ")
+}
+
+// LoadPasswordPolicy reads a YAML password policy. Rules missing from the file
+// keep their DefaultPasswordPolicy value.
+func LoadPasswordPolicy(path string) (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return policy, fmt.Errorf("failed to read password policy: %v", err)
+	}
+
+	if err := yaml.Unmarshal(content, &policy); err != nil {
+		return policy, fmt.Errorf("failed to parse password policy: %v", err)
+	}
+
+	return policy, policy.Validate()
+}
+
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength < 1 {
+		return fmt.Errorf("password policy: min_length must be positive")
+	}
+	if p.MaxLength < p.MinLength {
+		return fmt.Errorf("password policy: max_length must not be less than min_length")
+	}
+	if p.MinScore < 0 || p.MinScore > 4 {
+		return fmt.Errorf("password policy: min_score must be between 0 and 4")
+	}
+	if p.HistoryDepth < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("password policy: history_depth and max_age must not be negative")
+	}
+	return nil
+}
+
+// Check returns nil if password satisfies the policy, a *PasswordPolicyError
+// otherwise.
+func (p PasswordPolicy) Check(password string) error {
+	var violations []ValidationError
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, *newValidationError(CodePasswordTooShort, p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, *newValidationError(CodePasswordTooLong, p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSpecial = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, *newValidationError(CodePasswordNoUpper))
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, *newValidationError(CodePasswordNoLower))
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, *newValidationError(CodePasswordNoDigit))
+	}
+	if p.RequireSpecial && !hasSpecial {
+		violations = append(violations, *newValidationError(CodePasswordNoSpecial))
+	}
+
+	if p.MinScore > 0 && PasswordScore(password) < p.MinScore {
+		violations = append(violations, *newValidationError(CodePasswordTooWeak))
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{violations}
+	}
+	return nil
+}
+
+// PasswordScore estimates how hard password is to guess on the zxcvbn scale
+// from 0 to 4. It is a simplified estimation: the entropy of the character
+// pool the password draws from, where repeated characters and runs like "abc"
+// or "123" count as a single character.
+func PasswordScore(password string) int {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial, hasOther bool
+	for _, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			hasOther = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSpecial = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{hasUpper, 26}, {hasLower, 26}, {hasDigit, 10}, {hasSpecial, 33}, {hasOther, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+
+	effectiveLength := 1
+	for i := 1; i < len(runes); i++ {
+		delta := runes[i] - runes[i-1]
+		if delta >= -1 && delta <= 1 {
+			continue
+		}
+		effectiveLength++
+	}
+
+	guessesLog10 := float64(effectiveLength) * math.Log10(float64(pool))
+	switch {
+	case guessesLog10 < 3:
+		return 0
+	case guessesLog10 < 6:
+		return 1
+	case guessesLog10 < 8:
+		return 2
+	case guessesLog10 < 10:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"crypto/rsa"
+
+	"github.com/SawitProRecruitment/UserService/config"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/SawitProRecruitment/UserService/utils"
 )
@@ -8,25 +11,66 @@ import (
 type Server struct {
 	Repository repository.RepositoryInterface
 	Service    Service
+	Validator  Validator
 	Utils      utils.Utils
 }
 
 type NewServerOptions struct {
+	// Config holds the settings of the server, it defaults to
+	// config.Default().
+	Config     *config.Config
 	Repository repository.RepositoryInterface
 	Service    Service
+	Hasher     utils.PasswordHasher
+	Blocklist  utils.PasswordBlocklist
+	Policy     *PasswordPolicy
+	// PhoneNumbers defaults to Indonesian numbers only.
+	PhoneNumbers      *utils.PhoneNumberParser
+	ReservedUsernames *utils.ReservedUsernames
+	// Attributes defines the custom attributes of user profiles, there are
+	// none by default.
+	Attributes    *AttributeSchema
+	EmailVerifier *utils.EmailVerifier
+	// BlobStore stores uploaded avatars, they can't be uploaded without one.
+	BlobStore utils.BlobStore
+	// PrivateKey signs and PublicKey verifies tokens, they are loaded from
+	// the files of Config.Auth.
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
 }
 
 func NewServer(opts NewServerOptions) *Server {
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = config.Default()
+	}
 	optsValidator := NewValidatorOptions{
-		Repository: opts.Repository,
+		Repository:        opts.Repository,
+		Blocklist:         opts.Blocklist,
+		Policy:            opts.Policy,
+		PhoneNumbers:      opts.PhoneNumbers,
+		ReservedUsernames: opts.ReservedUsernames,
+		Attributes:        opts.Attributes,
+		PublicKey:         opts.PublicKey,
 	}
 
 	optsService := NewServiceOptions{
 		Repository: opts.Repository,
 		Validator:  NewValidator(optsValidator),
-		Utils:      utils.NewUtils(),
+		Utils: utils.NewUtils(utils.NewUtilsOptions{
+			Hasher:     opts.Hasher,
+			PrivateKey: opts.PrivateKey,
+			TokenTTL:   cfg.Auth.TokenTTL,
+		}),
+		EmailVerifier:          opts.EmailVerifier,
+		UsernameChangeInterval: cfg.Username.ChangeInterval,
+		BlobStore:              opts.BlobStore,
+		AvatarMaxBytes:         cfg.Avatar.MaxBytes,
+	}
+	if opts.Policy != nil {
+		optsService.PasswordHistoryDepth = opts.Policy.HistoryDepth
 	}
 
 	service := NewService(optsService)
-	return &Server{opts.Repository, service, optsService.Utils}
+	return &Server{opts.Repository, service, optsService.Validator, optsService.Utils}
 }
@@ -2,80 +2,228 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/repository"
 	"github.com/SawitProRecruitment/UserService/utils"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type Service interface {
-	Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string)
+	Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error)
 	Login(ctx context.Context, loginRequest *generated.LoginRequest) (string, error)
-	GetUserProfile(ctx context.Context, token string) (generated.UserProfile, error)
-	UpdateUserProfile(ctx context.Context,
-		updateUserProfileRequest generated.UpdateUserProfileRequest, token string) (generated.UserProfile, error)
+	ChangePassword(ctx context.Context, changePasswordRequest generated.ChangePasswordRequest, userID string) error
+	GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error)
+	// UpdateUserProfile and PatchUserProfile only update profiles with one
+	// of versions, any version if it is nil.
+	UpdateUserProfile(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
+		versions []int, userID string) (generated.UserProfile, error)
+	PatchUserProfile(ctx context.Context, contentType string, patch []byte, versions []int,
+		userID string) (generated.UserProfile, error)
+	UploadAvatar(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error)
+	CheckUsernameAvailability(ctx context.Context, username string) (generated.UsernameAvailability, error)
+	SendEmailVerification(ctx context.Context, userID string) error
+	VerifyEmail(ctx context.Context, token string) error
+	// CreateAdmin, ResetPassword, LockUser and NormalizePhoneNumbers are
+	// operator tasks, they are not exposed by the API.
+	CreateAdmin(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error)
+	ResetPassword(ctx context.Context, phoneNumber, password string) error
+	LockUser(ctx context.Context, phoneNumber string, locked bool) error
+	NormalizePhoneNumbers(ctx context.Context) (PhoneNumberNormalization, error)
+	Health(ctx context.Context) generated.Health
 }
 
+const defaultUsernameChangeInterval = 30 * 24 * time.Hour
+
 type service struct {
-	Repository repository.RepositoryInterface
-	Validator  Validator
-	Utils      utils.Utils
+	Repository             repository.RepositoryInterface
+	Validator              Validator
+	Utils                  utils.Utils
+	EmailVerifier          *utils.EmailVerifier
+	UsernameChangeInterval time.Duration
+	BlobStore              utils.BlobStore
+	AvatarMaxBytes         int64
+	PasswordHistoryDepth   int
 }
 
 type NewServiceOptions struct {
 	Repository repository.RepositoryInterface
 	Validator  Validator
 	Utils      utils.Utils
+	// EmailVerifier is optional, email addresses can't be verified, and so
+	// can't be used to log in, when it is not set.
+	EmailVerifier *utils.EmailVerifier
+	// UsernameChangeInterval is the minimum time between two username
+	// changes, defaults to 30 days.
+	UsernameChangeInterval time.Duration
+	// BlobStore is optional, avatars can't be uploaded when it is not set.
+	BlobStore utils.BlobStore
+	// AvatarMaxBytes defaults to utils.DefaultAvatarMaxBytes.
+	AvatarMaxBytes int64
+	// PasswordHistoryDepth is the history depth of the password policy, the
+	// older passwords are deleted when a password changes.
+	PasswordHistoryDepth int
 }
 
 func NewService(opts NewServiceOptions) *service {
-	return &service{opts.Repository, opts.Validator, opts.Utils}
+	if opts.UsernameChangeInterval <= 0 {
+		opts.UsernameChangeInterval = defaultUsernameChangeInterval
+	}
+	if opts.AvatarMaxBytes <= 0 {
+		opts.AvatarMaxBytes = utils.DefaultAvatarMaxBytes
+	}
+	return &service{opts.Repository, opts.Validator, opts.Utils, opts.EmailVerifier, opts.UsernameChangeInterval,
+		opts.BlobStore, opts.AvatarMaxBytes, opts.PasswordHistoryDepth}
 }
 
-func (s *service) Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, []string) {
-	errs := []string{}
-	if err := s.Validator.IsValidPhoneNumber(regRequest.PhoneNumber); err != nil {
-		errs = append(errs, fmt.Sprintf("phone_number: %s", err.Error()))
+func (s *service) Register(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+	return s.register(ctx, regRequest, false)
+}
+
+func (s *service) register(ctx context.Context, regRequest *generated.RegistrationRequest, admin bool) (string, error) {
+	var errs fieldErrors
+	if normalized, err := s.Validator.NormalizePhoneNumber(regRequest.PhoneNumber); err == nil {
+		regRequest.PhoneNumber = normalized
+	}
+	if err := errs.add("phone_number", s.Validator.IsValidPhoneNumber(regRequest.PhoneNumber)); err != nil {
+		return "", err
+	}
+
+	if regRequest.Email != nil {
+		if normalized, err := s.Validator.NormalizeEmail(*regRequest.Email); err == nil {
+			regRequest.Email = &normalized
+		}
+		if err := errs.add("email", s.Validator.IsValidEmail(*regRequest.Email)); err != nil {
+			return "", err
+		}
 	}
 
-	if err := s.Validator.IsValidFullName(regRequest.FullName); err != nil {
-		errs = append(errs, fmt.Sprintf("full_name: %s", err.Error()))
+	if normalized, err := s.Validator.NormalizeFullName(regRequest.FullName); err == nil {
+		regRequest.FullName = normalized
+	} else if err := errs.add("full_name", err); err != nil {
+		return "", err
 	}
 
-	if err := s.Validator.IsValidPassword(regRequest.Password); err != nil {
-		errs = append(errs, fmt.Sprintf("password: %s", err.Error()))
+	passwordErr := s.Validator.IsValidPassword(regRequest.Password)
+	if passwordErr == nil {
+		passwordErr = s.Validator.IsSafePassword(regRequest.Password, regRequest.PhoneNumber, regRequest.FullName)
+	}
+	if err := errs.add("password", passwordErr); err != nil {
+		return "", err
 	}
 
-	if len(errs) > 0 {
-		return "", errs
+	if err := errs.err(); err != nil {
+		return "", err
 	}
 
-	salt := s.Utils.GenerateRandomSalt()
-	temp, err := s.Utils.HashingPassword(regRequest.Password, salt)
+	temp, err := s.Utils.HashingPassword(regRequest.Password)
 	if err != nil {
-		errs = append(errs, err.Error())
-		return "", errs
+		return "", err
 	}
 
 	regRequest.Password = temp
-	userID, err := s.Repository.Register(ctx, *regRequest, salt)
+	register := s.Repository.Register
+	if admin {
+		register = s.Repository.RegisterAdmin
+	}
+	userID, err := register(ctx, *regRequest)
 	if err != nil {
-		errs = append(errs, err.Error())
+		return "", repositoryError(err)
 	}
-	return userID, errs
+
+	if regRequest.Email != nil {
+		// The account exists at this point, the user can ask for another
+		// link if this one is not delivered.
+		if err := s.sendEmailVerification(ctx, userID, *regRequest.Email); err != nil {
+			log.Printf("failed to send email verification to user %s: %v", userID, err)
+		}
+	}
+	return userID, nil
 }
 
 func (s *service) Login(ctx context.Context, loginRequest *generated.LoginRequest) (string, error) {
 	data := make(map[string]interface{})
-	userID, err := s.Repository.Login(ctx, *loginRequest)
+	var identifier string
+	if loginRequest.Identifier != nil {
+		identifier = *loginRequest.Identifier
+	} else if loginRequest.PhoneNumber != nil {
+		identifier = *loginRequest.PhoneNumber
+	}
+
+	// Identifiers that can't be normalized are never stored, the lookup fails
+	// the same way as for an unknown user.
+	credentials := repository.LoginCredentials{Password: loginRequest.Password}
+	if strings.Contains(identifier, "@") {
+		credentials.Email = identifier
+		if normalized, err := s.Validator.NormalizeEmail(identifier); err == nil {
+			credentials.Email = normalized
+		}
+	} else {
+		credentials.PhoneNumber = identifier
+		if normalized, err := s.Validator.NormalizePhoneNumber(identifier); err == nil {
+			credentials.PhoneNumber = normalized
+		}
+	}
+
+	userID, err := s.Repository.Login(ctx, credentials)
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrWrongPassword) {
+		// Both fail the same way, so the response doesn't tell who has an
+		// account.
+		invalidCredentials := newError(CodeInvalidCredentials)
+		invalidCredentials.Err = err
+		return "", invalidCredentials
+	}
+	if errors.Is(err, repository.ErrUserLocked) {
+		locked := newError(CodeUserLocked)
+		locked.Err = err
+		return "", locked
+	}
 	if err != nil {
 		return "", err
 	}
 
+	// The new password is only set once the current one is verified, it is
+	// how users whose password expired change it.
+	if loginRequest.NewPassword != nil {
+		if err := s.setPassword(ctx, userID, "new_password", *loginRequest.NewPassword); err != nil {
+			return "", err
+		}
+	} else {
+		expired, err := s.Validator.IsPasswordExpired(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		if expired {
+			return "", newError(CodePasswordExpired)
+		}
+	}
+
 	data["user_id"] = userID
-	data["phone_number"] = loginRequest.PhoneNumber
+	if credentials.PhoneNumber != "" {
+		data["phone_number"] = credentials.PhoneNumber
+	}
+	// The preferred language is carried in the token, so that messages can
+	// be localized without loading the profile on every request.
+	userProfile, err := s.Repository.GetUserProfile(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if userProfile.Language != nil {
+		data["locale"] = *userProfile.Language
+	}
+	admin, err := s.Repository.IsAdmin(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if admin {
+		data["scope"] = AdminScope
+	}
 	jwtToken, err := s.Utils.GenerateJWTToken(data)
 	if err != nil {
 		return "", err
@@ -83,51 +231,506 @@ func (s *service) Login(ctx context.Context, loginRequest *generated.LoginReques
 	return jwtToken, nil
 }
 
-func (s *service) GetUserProfile(ctx context.Context, token string) (generated.UserProfile, error) {
-	jwtToken, err := s.Validator.ValidateJWTToken(token)
+func (s *service) ChangePassword(ctx context.Context, changePasswordRequest generated.ChangePasswordRequest,
+	userID string) error {
+	ok, err := s.Repository.CheckPassword(ctx, userID, changePasswordRequest.CurrentPassword)
 	if err != nil {
-		return generated.UserProfile{}, err
+		return repositoryError(err)
 	}
-
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
 	if !ok {
-		return generated.UserProfile{}, err
+		return fieldError("current_password", newValidationError(CodeWrongPassword))
 	}
 
-	userID := claims["user_id"].(string)
-	return s.Repository.GetUserProfile(ctx, userID)
+	return s.setPassword(ctx, userID, "new_password", changePasswordRequest.NewPassword)
+}
+
+// CreateAdmin registers a user with the administrator role, validated like
+// Register does. The user is stored with the role at once, there is no
+// ordinary user left behind when granting it fails.
+func (s *service) CreateAdmin(ctx context.Context, regRequest *generated.RegistrationRequest) (string, error) {
+	return s.register(ctx, regRequest, true)
+}
+
+// ResetPassword replaces the password of the user with phoneNumber, which
+// must satisfy the same rules as a password the user picks.
+func (s *service) ResetPassword(ctx context.Context, phoneNumber, password string) error {
+	userID, err := s.userIDByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+	return s.setPassword(ctx, userID, "password", password)
+}
+
+// LockUser locks the user with phoneNumber out, or lets them in again. The
+// auth middleware rejects the tokens they already have as well.
+func (s *service) LockUser(ctx context.Context, phoneNumber string, locked bool) error {
+	userID, err := s.userIDByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+	return repositoryError(s.Repository.SetLocked(ctx, userID, locked))
+}
+
+// PhoneNumberNormalization is the outcome of NormalizePhoneNumbers.
+type PhoneNumberNormalization struct {
+	// Normalized is the number of phone numbers rewritten in E.164 format.
+	Normalized int
+	// Failed holds why the numbers left as they are couldn't be rewritten,
+	// by user ID.
+	Failed map[string]error
+}
+
+// NormalizePhoneNumbers rewrites the phone numbers stored before numbers were
+// normalized in E.164 format, lookups by the normalized number miss them.
+// Invalid numbers, and numbers another user has in E.164 format, are left as
+// they are and reported. Running it again only retries those.
+func (s *service) NormalizePhoneNumbers(ctx context.Context) (PhoneNumberNormalization, error) {
+	normalization := PhoneNumberNormalization{Failed: map[string]error{}}
+
+	// The changes are collected first, the rows are not updated while they
+	// are read.
+	pending := map[string]string{}
+	var userIDs []string
+	err := s.Repository.ListPhoneNumbers(ctx, func(userID, phoneNumber string) error {
+		normalized, err := s.Validator.NormalizePhoneNumber(phoneNumber)
+		switch {
+		case err != nil:
+			normalization.Failed[userID] = err
+		case normalized != phoneNumber:
+			pending[userID] = normalized
+			userIDs = append(userIDs, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return normalization, err
+	}
+
+	for _, userID := range userIDs {
+		err := repositoryError(s.Repository.SetPhoneNumber(ctx, userID, pending[userID]))
+		var e *Error
+		switch {
+		case err == nil:
+			normalization.Normalized++
+		case errors.As(err, &e):
+			normalization.Failed[userID] = err
+		default:
+			return normalization, err
+		}
+	}
+	return normalization, nil
+}
+
+func (s *service) userIDByPhoneNumber(ctx context.Context, phoneNumber string) (string, error) {
+	if normalized, err := s.Validator.NormalizePhoneNumber(phoneNumber); err == nil {
+		phoneNumber = normalized
+	}
+	userID, err := s.Repository.GetUserIDByPhoneNumber(ctx, phoneNumber)
+	return userID, repositoryError(err)
+}
+
+// setPassword validates password against the policy and the password history
+// of the user before storing it. Violations are reported for field.
+func (s *service) setPassword(ctx context.Context, userID, field, password string) error {
+	if err := s.Validator.IsValidPassword(password); err != nil {
+		return fieldError(field, err)
+	}
+
+	userProfile, err := s.Repository.GetUserProfile(ctx, userID)
+	if err != nil {
+		return repositoryError(err)
+	}
+
+	var phoneNumber, fullName string
+	if userProfile.PhoneNumber != nil {
+		phoneNumber = *userProfile.PhoneNumber
+	}
+	if userProfile.FullName != nil {
+		fullName = *userProfile.FullName
+	}
+	if err := s.Validator.IsSafePassword(password, phoneNumber, fullName); err != nil {
+		return fieldError(field, err)
+	}
+
+	if err := s.Validator.IsNewPassword(ctx, userID, password); err != nil {
+		return fieldError(field, err)
+	}
+
+	hashedPassword, err := s.Utils.HashingPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return repositoryError(s.Repository.UpdatePassword(ctx, userID, hashedPassword, s.PasswordHistoryDepth))
+}
+
+func (s *service) GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error) {
+	userProfile, err := s.Repository.GetUserProfile(ctx, userID)
+	return userProfile, repositoryError(err)
 }
 
 func (s *service) UpdateUserProfile(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
-	token string) (generated.UserProfile, error) {
-	jwtToken, err := s.Validator.ValidateJWTToken(token)
+	versions []int, userID string) (generated.UserProfile, error) {
+	return s.updateUserProfile(ctx, updateUserProfileRequest, nil, versions, userID)
+}
+
+// PatchUserProfile applies a JSON Merge Patch or a JSON Patch to the editable
+// fields of the profile. Only the fields the patch changes are validated and
+// stored, optional fields it removes or sets to null are cleared.
+func (s *service) PatchUserProfile(ctx context.Context, contentType string, patch []byte, versions []int,
+	userID string) (generated.UserProfile, error) {
+
+	current, err := s.Repository.GetUserProfile(ctx, userID)
+	if err != nil {
+		return generated.UserProfile{}, repositoryError(err)
+	}
+	if versions != nil && !hasVersion(versions, current.Version) {
+		return generated.UserProfile{}, repositoryError(repository.ErrVersionMismatch)
+	}
+	document, err := profileDocument(current)
 	if err != nil {
 		return generated.UserProfile{}, err
 	}
 
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	var patched interface{}
+	switch contentType {
+	case MergePatchContentType:
+		patched, err = utils.MergePatch(document, patch)
+	case JSONPatchContentType:
+		patched, err = utils.ApplyJSONPatch(document, patch)
+	default:
+		return generated.UserProfile{}, fmt.Errorf("unsupported patch content type %q", contentType)
+	}
+	switch {
+	case errors.Is(err, utils.ErrPatchTestFailed):
+		e := newError(CodePatchTestFailed)
+		e.Err = err
+		return generated.UserProfile{}, e
+	case err != nil:
+		return generated.UserProfile{}, newError(CodeInvalidPatch, err.Error())
+	}
+
+	result, ok := patched.(map[string]interface{})
 	if !ok {
+		return generated.UserProfile{}, newError(CodeInvalidPatch, "the profile must remain an object")
+	}
+	updateUserProfileRequest, clear, err := profileChanges(document, result)
+	if err != nil {
 		return generated.UserProfile{}, err
 	}
+	return s.updateUserProfile(ctx, updateUserProfileRequest, clear, versions, userID)
+}
 
-	userID := claims["user_id"].(string)
+// hasVersion reports whether version, of a profile read from the repository,
+// is one of versions.
+func hasVersion(versions []int, version *int) bool {
+	for _, v := range versions {
+		if version != nil && v == *version {
+			return true
+		}
+	}
+	return false
+}
 
-	updateUserProfileRequestMap := make(map[string]string)
+// updateUserProfile validates and stores the fields of the request, and
+// removes the optional fields listed in clear.
+func (s *service) updateUserProfile(ctx context.Context, updateUserProfileRequest generated.UpdateUserProfileRequest,
+	clear []string, versions []int, userID string) (generated.UserProfile, error) {
+
+	update := repository.UserProfileUpdate{Clear: clear, Versions: versions}
 	if updateUserProfileRequest.FullName != nil {
-		if err := s.Validator.IsValidFullName(*updateUserProfileRequest.FullName); err == nil {
-			updateUserProfileRequestMap["full_name"] = *updateUserProfileRequest.FullName
-		} else {
-			return generated.UserProfile{}, err
+		fullName, err := s.Validator.NormalizeFullName(*updateUserProfileRequest.FullName)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("full_name", err)
 		}
+		update.FullName = &fullName
 	}
 
 	if updateUserProfileRequest.PhoneNumber != nil {
-		if err := s.Validator.IsValidPhoneNumber(*updateUserProfileRequest.PhoneNumber); err == nil {
-			updateUserProfileRequestMap["phone_number"] = *updateUserProfileRequest.PhoneNumber
+		phoneNumber, err := s.Validator.NormalizePhoneNumber(*updateUserProfileRequest.PhoneNumber)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("phone_number", err)
+		}
+		if err := s.Validator.IsValidPhoneNumber(phoneNumber); err == nil {
+			update.PhoneNumber = &phoneNumber
 		} else {
-			return generated.UserProfile{}, err
+			return generated.UserProfile{}, fieldError("phone_number", err)
+		}
+	}
+
+	if updateUserProfileRequest.Email != nil {
+		email, err := s.Validator.NormalizeEmail(*updateUserProfileRequest.Email)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("email", err)
+		}
+
+		// An unchanged address keeps its verification, and is not taken by
+		// the user who verified it.
+		current, err := s.Repository.GetUserProfile(ctx, userID)
+		if err != nil {
+			return generated.UserProfile{}, repositoryError(err)
+		}
+		if current.Email == nil || *current.Email != email {
+			if err := s.Validator.IsValidEmail(email); err != nil {
+				return generated.UserProfile{}, fieldError("email", err)
+			}
+			update.Email = &email
 		}
 	}
 
-	return s.Repository.UpdateUserProfile(ctx, updateUserProfileRequestMap, userID)
+	if updateUserProfileRequest.Language != nil {
+		lang, err := s.Validator.NormalizeLanguage(*updateUserProfileRequest.Language)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("language", err)
+		}
+		update.Language = &lang
+	}
+
+	if updateUserProfileRequest.DateOfBirth != nil {
+		if err := s.Validator.IsValidDateOfBirth(*updateUserProfileRequest.DateOfBirth); err != nil {
+			return generated.UserProfile{}, fieldError("date_of_birth", err)
+		}
+		update.DateOfBirth = updateUserProfileRequest.DateOfBirth
+	}
+
+	if updateUserProfileRequest.Gender != nil {
+		gender := string(*updateUserProfileRequest.Gender)
+		if err := s.Validator.IsValidGender(gender); err != nil {
+			return generated.UserProfile{}, fieldError("gender", err)
+		}
+		update.Gender = &gender
+	}
+
+	if updateUserProfileRequest.Address != nil {
+		address, err := s.Validator.NormalizeAddress(*updateUserProfileRequest.Address)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("address", err)
+		}
+		update.Address = &address
+	}
+
+	if updateUserProfileRequest.AvatarUrl != nil {
+		avatarURL, err := s.Validator.NormalizeAvatarURL(*updateUserProfileRequest.AvatarUrl)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("avatar_url", err)
+		}
+		update.AvatarURL = &avatarURL
+	}
+
+	if updateUserProfileRequest.Timezone != nil {
+		timezone, err := s.Validator.NormalizeTimezone(*updateUserProfileRequest.Timezone)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("timezone", err)
+		}
+		update.Timezone = &timezone
+	}
+
+	if updateUserProfileRequest.CustomAttributes != nil {
+		current, err := s.Repository.GetUserProfile(ctx, userID)
+		if err != nil {
+			return generated.UserProfile{}, repositoryError(err)
+		}
+		attributes := mergeCustomAttributes(current.CustomAttributes, *updateUserProfileRequest.CustomAttributes)
+		if err := s.Validator.ValidateCustomAttributes(attributes); err != nil {
+			return generated.UserProfile{}, fieldError("custom_attributes", err)
+		}
+		update.CustomAttributes = attributes
+	}
+
+	if updateUserProfileRequest.Username != nil {
+		username, err := s.Validator.NormalizeUsername(*updateUserProfileRequest.Username)
+		if err != nil {
+			return generated.UserProfile{}, fieldError("username", err)
+		}
+
+		current, err := s.Repository.GetUserProfile(ctx, userID)
+		if err != nil {
+			return generated.UserProfile{}, repositoryError(err)
+		}
+		if current.Username == nil || *current.Username != username {
+			if err := s.Validator.IsValidUsername(username); err != nil {
+				return generated.UserProfile{}, fieldError("username", err)
+			}
+			update.Username = &username
+			update.UsernameChangeInterval = s.UsernameChangeInterval
+		}
+	}
+
+	userProfile, err := s.Repository.UpdateUserProfile(ctx, update, userID)
+	if err != nil {
+		return generated.UserProfile{}, repositoryError(err)
+	}
+
+	if update.Email != nil {
+		if err := s.sendEmailVerification(ctx, userID, *update.Email); err != nil {
+			log.Printf("failed to send email verification to user %s: %v", userID, err)
+		}
+	}
+	return userProfile, nil
+}
+
+// UploadAvatar stores the avatar and its thumbnails under keys of the user,
+// replacing the previous ones. The URLs have a version, so caches don't keep
+// serving the previous avatar.
+func (s *service) UploadAvatar(ctx context.Context, avatar io.Reader, userID string) (generated.UserProfile, error) {
+	if s.BlobStore == nil {
+		return generated.UserProfile{}, errors.New("avatar uploads are not configured")
+	}
+
+	images, err := utils.ProcessAvatar(avatar, s.AvatarMaxBytes)
+	switch {
+	case errors.Is(err, utils.ErrAvatarTooLarge):
+		maxMegabytes := (s.AvatarMaxBytes + 1<<20 - 1) >> 20
+		return generated.UserProfile{}, fieldError("avatar", newValidationError(CodeAvatarTooLarge, maxMegabytes))
+	case errors.Is(err, utils.ErrInvalidAvatar):
+		return generated.UserProfile{}, fieldError("avatar",
+			newValidationError(CodeInvalidAvatar, utils.MinAvatarSize, utils.MinAvatarSize))
+	case err != nil:
+		return generated.UserProfile{}, err
+	}
+
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var avatarURL string
+	thumbnails := map[string]string{}
+	for i, image := range images {
+		key := fmt.Sprintf("avatars/%s/%d.jpg", userID, image.Size)
+		url, err := s.BlobStore.Put(ctx, key, "image/jpeg", image.Data)
+		if err != nil {
+			return generated.UserProfile{}, fmt.Errorf("failed to store %s: %v", key, err)
+		}
+		url += "?v=" + version
+
+		if i == 0 {
+			avatarURL = url
+		} else {
+			thumbnails[strconv.Itoa(image.Size)] = url
+		}
+	}
+
+	userProfile, err := s.Repository.UpdateUserProfile(ctx, repository.UserProfileUpdate{
+		AvatarURL:        &avatarURL,
+		AvatarThumbnails: thumbnails,
+	}, userID)
+	return userProfile, repositoryError(err)
+}
+
+// mergeCustomAttributes returns the current attributes with the changed ones
+// set, or removed if they are changed to null.
+func mergeCustomAttributes(current *map[string]interface{}, changes map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	if current != nil {
+		for name, value := range *current {
+			merged[name] = value
+		}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// CheckUsernameAvailability tells whether username can be taken. It fails only
+// if username is not valid at all.
+func (s *service) CheckUsernameAvailability(ctx context.Context,
+	username string) (generated.UsernameAvailability, error) {
+	normalized, err := s.Validator.NormalizeUsername(username)
+	if err != nil {
+		return generated.UsernameAvailability{}, fieldError("name", err)
+	}
+
+	availability := generated.UsernameAvailability{Username: normalized, Available: true}
+	if err := s.Validator.IsValidUsername(normalized); err != nil {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			return generated.UsernameAvailability{}, err
+		}
+		reasonCode := string(validationErr.Code)
+		availability.Available = false
+		availability.Reason = &validationErr.Message
+		availability.ReasonCode = &reasonCode
+	}
+	return availability, nil
+}
+
+// SendEmailVerification mails a new verification link for the current email
+// address of the user.
+func (s *service) SendEmailVerification(ctx context.Context, userID string) error {
+	userProfile, err := s.Repository.GetUserProfile(ctx, userID)
+	if err != nil {
+		return repositoryError(err)
+	}
+
+	if userProfile.Email == nil {
+		return newError(CodeNoEmail)
+	}
+	if userProfile.EmailVerified != nil && *userProfile.EmailVerified {
+		return newError(CodeEmailAlreadyVerified)
+	}
+
+	return s.sendEmailVerification(ctx, userID, *userProfile.Email)
+}
+
+func (s *service) sendEmailVerification(ctx context.Context, userID, email string) error {
+	if s.EmailVerifier == nil {
+		return errors.New("email verification is not configured")
+	}
+	return s.EmailVerifier.SendVerification(ctx, userID, email)
+}
+
+// VerifyEmail marks the email address token was issued for as verified, as
+// long as it still is the address of the user.
+func (s *service) VerifyEmail(ctx context.Context, token string) error {
+	invalidToken := newError(CodeInvalidEmailToken)
+	invalidToken.Err = utils.ErrInvalidEmailVerificationToken
+	if s.EmailVerifier == nil {
+		return invalidToken
+	}
+
+	userID, email, err := s.EmailVerifier.Verify(token)
+	if err != nil {
+		return invalidToken
+	}
+
+	ok, err := s.Repository.VerifyEmail(ctx, userID, email)
+	if err != nil {
+		return repositoryError(err)
+	}
+	if !ok {
+		return invalidToken
+	}
+	return nil
+}
+
+// healthPingTimeout bounds the ping of Health, so that health checks fail
+// instead of hanging when the database doesn't answer.
+const healthPingTimeout = 2 * time.Second
+
+// Health pings the database and returns the statistics of the connection
+// pool, the status is unavailable when the ping fails.
+func (s *service) Health(ctx context.Context) generated.Health {
+	pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+
+	health := generated.Health{Status: generated.Ok}
+	if err := s.Repository.Ping(pingCtx); err != nil {
+		log.Printf("health check failed to ping the database: %v", err)
+		health.Status = generated.Unavailable
+	}
+	stats := s.Repository.PoolStats()
+	health.Database = generated.DatabasePool{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+	return health
 }
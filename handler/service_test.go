@@ -1,10 +1,22 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	ginkgo "github.com/onsi/ginkgo/v2"
@@ -13,11 +25,33 @@ import (
 
 type mockRepository struct {
 	isPhoneNumberExistsFunc func(context.Context, string) (bool, error)
-	registerFunc            func(ctx context.Context, regRequest generated.RegistrationRequest, salt string) (string, error)
-	loginFunc               func(ctx context.Context, loginRequest generated.LoginRequest) (string, error)
+	isEmailExistsFunc       func(context.Context, string) (bool, error)
+	isUsernameExistsFunc    func(context.Context, string) (bool, error)
+	registerFunc            func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error)
+	registerAdminFunc       func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error)
+	loginFunc               func(ctx context.Context, credentials repository.LoginCredentials) (string, error)
+	checkPasswordFunc       func(ctx context.Context, userID, password string) (bool, error)
+	isPasswordReusedFunc    func(ctx context.Context, userID, password string, historyDepth int) (bool, error)
+	updatePasswordFunc      func(ctx context.Context, userID, hashedPassword string, historyDepth int) error
+	passwordChangedAtFunc   func(ctx context.Context, userID string) (time.Time, error)
+	verifyEmailFunc         func(ctx context.Context, userID, email string) (bool, error)
 	getProfileFunc          func(ctx context.Context, userID string) (generated.UserProfile, error)
-	updateProfileFunc       func(ctx context.Context, updateUserProfileRequest map[string]string,
+	updateProfileFunc       func(ctx context.Context, update repository.UserProfileUpdate,
 		userID string) (generated.UserProfile, error)
+	reserveIdempotencyKeyFunc func(ctx context.Context,
+		request repository.IdempotentRequest) (repository.IdempotentRequest, bool, error)
+	completeIdempotencyKeyFunc func(ctx context.Context, scope, key string,
+		response repository.IdempotentResponse, expiresAt time.Time) error
+	releaseIdempotencyKeyFunc  func(ctx context.Context, scope, key string) error
+	getUserIDByPhoneNumberFunc func(ctx context.Context, phoneNumber string) (string, error)
+	isAdminFunc                func(ctx context.Context, userID string) (bool, error)
+	getUserAccessFunc          func(ctx context.Context, userID string) (repository.UserAccess, error)
+	setAdminFunc               func(ctx context.Context, userID string, admin bool) error
+	setLockedFunc              func(ctx context.Context, userID string, locked bool) error
+	setPhoneNumberFunc         func(ctx context.Context, userID, phoneNumber string) error
+	listPhoneNumbersFunc       func(ctx context.Context, fn func(userID, phoneNumber string) error) error
+	pingFunc                   func(ctx context.Context) error
+	poolStats                  sql.DBStats
 }
 
 func NewMockRepository() mockRepository {
@@ -25,15 +59,74 @@ func NewMockRepository() mockRepository {
 		isPhoneNumberExistsFunc: func(ctx context.Context, s string) (bool, error) {
 			return false, nil
 		},
-		registerFunc: func(ctx context.Context, regRequest generated.RegistrationRequest, salt string) (string, error) {
+		isEmailExistsFunc: func(ctx context.Context, s string) (bool, error) {
+			return false, nil
+		},
+		isUsernameExistsFunc: func(ctx context.Context, s string) (bool, error) {
+			return false, nil
+		},
+		registerFunc: func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
 			return "mockedUserID", nil
 		},
+		registerAdminFunc: func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+			return "mockedAdminID", nil
+		},
+		checkPasswordFunc: func(ctx context.Context, userID, password string) (bool, error) {
+			return true, nil
+		},
+		isPasswordReusedFunc: func(ctx context.Context, userID, password string, historyDepth int) (bool, error) {
+			return false, nil
+		},
+		updatePasswordFunc: func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+			return nil
+		},
+		verifyEmailFunc: func(ctx context.Context, userID, email string) (bool, error) {
+			return true, nil
+		},
 		getProfileFunc: func(ctx context.Context, userID string) (generated.UserProfile, error) {
 			return generated.UserProfile{}, nil
 		},
-		updateProfileFunc: func(ctx context.Context, updateUserProfileRequest map[string]string, userID string) (generated.UserProfile, error) {
+		updateProfileFunc: func(ctx context.Context, update repository.UserProfileUpdate, userID string) (generated.UserProfile, error) {
 			return generated.UserProfile{}, nil
 		},
+		reserveIdempotencyKeyFunc: func(ctx context.Context,
+			request repository.IdempotentRequest) (repository.IdempotentRequest, bool, error) {
+			return request, true, nil
+		},
+		completeIdempotencyKeyFunc: func(ctx context.Context, scope, key string,
+			response repository.IdempotentResponse, expiresAt time.Time) error {
+			return nil
+		},
+		releaseIdempotencyKeyFunc: func(ctx context.Context, scope, key string) error {
+			return nil
+		},
+		getUserIDByPhoneNumberFunc: func(ctx context.Context, phoneNumber string) (string, error) {
+			return "mockedUserID", nil
+		},
+		isAdminFunc: func(ctx context.Context, userID string) (bool, error) {
+			return false, nil
+		},
+		getUserAccessFunc: func(ctx context.Context, userID string) (repository.UserAccess, error) {
+			return repository.UserAccess{}, nil
+		},
+		setAdminFunc: func(ctx context.Context, userID string, admin bool) error {
+			return nil
+		},
+		setLockedFunc: func(ctx context.Context, userID string, locked bool) error {
+			return nil
+		},
+		setPhoneNumberFunc: func(ctx context.Context, userID, phoneNumber string) error {
+			return nil
+		},
+		listPhoneNumbersFunc: func(ctx context.Context, fn func(userID, phoneNumber string) error) error {
+			return nil
+		},
+		pingFunc: func(ctx context.Context) error {
+			return nil
+		},
+		passwordChangedAtFunc: func(ctx context.Context, userID string) (time.Time, error) {
+			return time.Now(), nil
+		},
 	}
 }
 
@@ -41,12 +134,40 @@ func (m *mockRepository) IsPhoneNumberExists(ctx context.Context, phoneNumber st
 	return m.isPhoneNumberExistsFunc(ctx, phoneNumber)
 }
 
-func (m *mockRepository) Register(ctx context.Context, regRequest generated.RegistrationRequest, salt string) (string, error) {
-	return m.registerFunc(ctx, regRequest, salt)
+func (m *mockRepository) IsEmailExists(ctx context.Context, email string) (bool, error) {
+	return m.isEmailExistsFunc(ctx, email)
+}
+
+func (m *mockRepository) IsUsernameExists(ctx context.Context, username string) (bool, error) {
+	return m.isUsernameExistsFunc(ctx, username)
+}
+
+func (m *mockRepository) Register(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+	return m.registerFunc(ctx, regRequest)
 }
 
-func (m *mockRepository) Login(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
-	return m.loginFunc(ctx, loginRequest)
+func (m *mockRepository) Login(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+	return m.loginFunc(ctx, credentials)
+}
+
+func (m *mockRepository) CheckPassword(ctx context.Context, userID, password string) (bool, error) {
+	return m.checkPasswordFunc(ctx, userID, password)
+}
+
+func (m *mockRepository) IsPasswordReused(ctx context.Context, userID, password string, historyDepth int) (bool, error) {
+	return m.isPasswordReusedFunc(ctx, userID, password, historyDepth)
+}
+
+func (m *mockRepository) UpdatePassword(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+	return m.updatePasswordFunc(ctx, userID, hashedPassword, historyDepth)
+}
+
+func (m *mockRepository) GetPasswordChangedAt(ctx context.Context, userID string) (time.Time, error) {
+	return m.passwordChangedAtFunc(ctx, userID)
+}
+
+func (m *mockRepository) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	return m.verifyEmailFunc(ctx, userID, email)
 }
 
 func (m *mockRepository) GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error) {
@@ -54,23 +175,81 @@ func (m *mockRepository) GetUserProfile(ctx context.Context, userID string) (gen
 }
 
 func (m *mockRepository) UpdateUserProfile(ctx context.Context,
-	updateUserProfileRequest map[string]string, userID string) (generated.UserProfile, error) {
-	return m.updateProfileFunc(ctx, updateUserProfileRequest, userID)
+	update repository.UserProfileUpdate, userID string) (generated.UserProfile, error) {
+	return m.updateProfileFunc(ctx, update, userID)
 }
 
-type mockUtils struct {
-	hashingPasswordFunc    func(password, salt string) (string, error)
-	generateRandomSaltFunc func() string
-	generateJWTTokenFunc   func(claims jwt.MapClaims) (string, error)
-	extractJWTTokenFunc    func(ctx echo.Context) (string, error)
+func (m *mockRepository) ReserveIdempotencyKey(ctx context.Context,
+	request repository.IdempotentRequest) (repository.IdempotentRequest, bool, error) {
+	return m.reserveIdempotencyKeyFunc(ctx, request)
+}
+
+func (m *mockRepository) CompleteIdempotencyKey(ctx context.Context, scope, key string,
+	response repository.IdempotentResponse, expiresAt time.Time) error {
+	return m.completeIdempotencyKeyFunc(ctx, scope, key, response, expiresAt)
+}
+
+func (m *mockRepository) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	return m.releaseIdempotencyKeyFunc(ctx, scope, key)
+}
+
+func (m *mockRepository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockRepository) GetUserIDByPhoneNumber(ctx context.Context, phoneNumber string) (string, error) {
+	return m.getUserIDByPhoneNumberFunc(ctx, phoneNumber)
+}
+
+func (m *mockRepository) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	return m.isAdminFunc(ctx, userID)
+}
+
+func (m *mockRepository) RegisterAdmin(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+	return m.registerAdminFunc(ctx, regRequest)
+}
+
+func (m *mockRepository) GetUserAccess(ctx context.Context, userID string) (repository.UserAccess, error) {
+	return m.getUserAccessFunc(ctx, userID)
+}
+
+func (m *mockRepository) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	return m.setAdminFunc(ctx, userID, admin)
 }
 
-func (m *mockUtils) HashingPassword(password, salt string) (string, error) {
-	return m.hashingPasswordFunc(password, salt)
+func (m *mockRepository) SetLocked(ctx context.Context, userID string, locked bool) error {
+	return m.setLockedFunc(ctx, userID, locked)
 }
 
-func (m *mockUtils) GenerateRandomSalt() string {
-	return m.generateRandomSaltFunc()
+func (m *mockRepository) SetPhoneNumber(ctx context.Context, userID, phoneNumber string) error {
+	return m.setPhoneNumberFunc(ctx, userID, phoneNumber)
+}
+
+func (m *mockRepository) ListPhoneNumbers(ctx context.Context, fn func(userID, phoneNumber string) error) error {
+	return m.listPhoneNumbersFunc(ctx, fn)
+}
+
+func (m *mockRepository) ExportUsers(ctx context.Context, fn func(user repository.ExportedUser) error) error {
+	return nil
+}
+
+func (m *mockRepository) Ping(ctx context.Context) error {
+	return m.pingFunc(ctx)
+}
+
+func (m *mockRepository) PoolStats() sql.DBStats {
+	return m.poolStats
+}
+
+type mockUtils struct {
+	hashingPasswordFunc  func(password string) (string, error)
+	generateJWTTokenFunc func(claims jwt.MapClaims) (string, error)
+	extractJWTTokenFunc  func(ctx echo.Context) (string, error)
+	tokenTTL             time.Duration
+}
+
+func (m *mockUtils) HashingPassword(password string) (string, error) {
+	return m.hashingPasswordFunc(password)
 }
 
 func (m *mockUtils) GenerateJWTToken(claims jwt.MapClaims) (string, error) {
@@ -81,28 +260,54 @@ func (m *mockUtils) ExtractJWTToken(ctx echo.Context) (string, error) {
 	return m.extractJWTTokenFunc(ctx)
 }
 
+func (m *mockUtils) TokenTTL() time.Duration {
+	return m.tokenTTL
+}
+
 func NewMockUtils() mockUtils {
 	return mockUtils{
-		hashingPasswordFunc: func(password, salt string) (string, error) {
+		hashingPasswordFunc: func(password string) (string, error) {
 			return "", nil
 		},
-		generateRandomSaltFunc: func() string {
-			return ""
-		},
 		generateJWTTokenFunc: func(claims jwt.MapClaims) (string, error) {
 			return "", nil
 		},
 		extractJWTTokenFunc: func(ctx echo.Context) (string, error) {
 			return "", nil
 		},
+		tokenTTL: 24 * time.Hour,
 	}
 }
 
 type MockValidator struct {
-	MockIsValidPhoneNumber func(phoneNumber string) error
-	MockIsValidFullName    func(fullName string) error
-	MockIsValidPassword    func(password string) error
-	MockValidateJWTToken   func(tokenString string) (*jwt.Token, error)
+	MockNormalizePhoneNumber     func(phoneNumber string) (string, error)
+	MockIsValidPhoneNumber       func(phoneNumber string) error
+	MockNormalizeEmail           func(email string) (string, error)
+	MockIsValidEmail             func(email string) error
+	MockNormalizeUsername        func(username string) (string, error)
+	MockIsValidUsername          func(username string) error
+	MockNormalizeLanguage        func(lang string) (string, error)
+	MockNormalizeFullName        func(fullName string) (string, error)
+	MockIsValidFullName          func(fullName string) error
+	MockIsValidDateOfBirth       func(date string) error
+	MockIsValidGender            func(gender string) error
+	MockNormalizeAddress         func(address string) (string, error)
+	MockNormalizeAvatarURL       func(rawURL string) (string, error)
+	MockNormalizeTimezone        func(timezone string) (string, error)
+	MockValidateCustomAttributes func(attributes map[string]interface{}) error
+	MockIsValidPassword          func(password string) error
+	MockIsSafePassword           func(password, phoneNumber, fullName string) error
+	MockIsNewPassword            func(ctx context.Context, userID, password string) error
+	MockIsPasswordExpired        func(ctx context.Context, userID string) (bool, error)
+	MockValidateJWTToken         func(tokenString string) (*jwt.Token, error)
+}
+
+func (m *MockValidator) NormalizePhoneNumber(phoneNumber string) (string, error) {
+	if m.MockNormalizePhoneNumber != nil {
+		return m.MockNormalizePhoneNumber(phoneNumber)
+	}
+	// Replace this with your desired mock behavior
+	return phoneNumber, nil
 }
 
 func (m *MockValidator) IsValidPhoneNumber(phoneNumber string) error {
@@ -113,6 +318,54 @@ func (m *MockValidator) IsValidPhoneNumber(phoneNumber string) error {
 	return nil
 }
 
+func (m *MockValidator) NormalizeEmail(email string) (string, error) {
+	if m.MockNormalizeEmail != nil {
+		return m.MockNormalizeEmail(email)
+	}
+	// Replace this with your desired mock behavior
+	return email, nil
+}
+
+func (m *MockValidator) IsValidEmail(email string) error {
+	if m.MockIsValidEmail != nil {
+		return m.MockIsValidEmail(email)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) NormalizeUsername(username string) (string, error) {
+	if m.MockNormalizeUsername != nil {
+		return m.MockNormalizeUsername(username)
+	}
+	// Replace this with your desired mock behavior
+	return username, nil
+}
+
+func (m *MockValidator) IsValidUsername(username string) error {
+	if m.MockIsValidUsername != nil {
+		return m.MockIsValidUsername(username)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) NormalizeLanguage(lang string) (string, error) {
+	if m.MockNormalizeLanguage != nil {
+		return m.MockNormalizeLanguage(lang)
+	}
+	// Replace this with your desired mock behavior
+	return lang, nil
+}
+
+func (m *MockValidator) NormalizeFullName(fullName string) (string, error) {
+	if m.MockNormalizeFullName != nil {
+		return m.MockNormalizeFullName(fullName)
+	}
+	// Replace this with your desired mock behavior
+	return fullName, nil
+}
+
 func (m *MockValidator) IsValidFullName(fullName string) error {
 	if m.MockIsValidFullName != nil {
 		return m.MockIsValidFullName(fullName)
@@ -121,6 +374,54 @@ func (m *MockValidator) IsValidFullName(fullName string) error {
 	return nil
 }
 
+func (m *MockValidator) IsValidDateOfBirth(date string) error {
+	if m.MockIsValidDateOfBirth != nil {
+		return m.MockIsValidDateOfBirth(date)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) IsValidGender(gender string) error {
+	if m.MockIsValidGender != nil {
+		return m.MockIsValidGender(gender)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) NormalizeAddress(address string) (string, error) {
+	if m.MockNormalizeAddress != nil {
+		return m.MockNormalizeAddress(address)
+	}
+	// Replace this with your desired mock behavior
+	return address, nil
+}
+
+func (m *MockValidator) NormalizeAvatarURL(rawURL string) (string, error) {
+	if m.MockNormalizeAvatarURL != nil {
+		return m.MockNormalizeAvatarURL(rawURL)
+	}
+	// Replace this with your desired mock behavior
+	return rawURL, nil
+}
+
+func (m *MockValidator) NormalizeTimezone(timezone string) (string, error) {
+	if m.MockNormalizeTimezone != nil {
+		return m.MockNormalizeTimezone(timezone)
+	}
+	// Replace this with your desired mock behavior
+	return timezone, nil
+}
+
+func (m *MockValidator) ValidateCustomAttributes(attributes map[string]interface{}) error {
+	if m.MockValidateCustomAttributes != nil {
+		return m.MockValidateCustomAttributes(attributes)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
 func (m *MockValidator) IsValidPassword(password string) error {
 	if m.MockIsValidPassword != nil {
 		return m.MockIsValidPassword(password)
@@ -129,6 +430,29 @@ func (m *MockValidator) IsValidPassword(password string) error {
 	return nil
 }
 
+func (m *MockValidator) IsSafePassword(password, phoneNumber, fullName string) error {
+	if m.MockIsSafePassword != nil {
+		return m.MockIsSafePassword(password, phoneNumber, fullName)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) IsNewPassword(ctx context.Context, userID, password string) error {
+	if m.MockIsNewPassword != nil {
+		return m.MockIsNewPassword(ctx, userID, password)
+	}
+	// Replace this with your desired mock behavior
+	return nil
+}
+
+func (m *MockValidator) IsPasswordExpired(ctx context.Context, userID string) (bool, error) {
+	if m.MockIsPasswordExpired != nil {
+		return m.MockIsPasswordExpired(ctx, userID)
+	}
+	return false, nil
+}
+
 func (m *MockValidator) ValidateJWTToken(tokenString string) (*jwt.Token, error) {
 	if m.MockValidateJWTToken != nil {
 		return m.MockValidateJWTToken(tokenString)
@@ -141,6 +465,23 @@ func NewMockValidator() MockValidator {
 	return MockValidator{}
 }
 
+// errorCode returns the code of err, or "" if it is not an *Error.
+func errorCode(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func fieldErrorsOf(err error) []FieldError {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Fields
+	}
+	return nil
+}
+
 var _ = ginkgo.Describe("Service", func() {
 	var (
 		service   *service
@@ -168,7 +509,7 @@ var _ = ginkgo.Describe("Service", func() {
 
 		ctx = context.Background()
 		regReq = &generated.RegistrationRequest{
-			PhoneNumber: "+621234567890",
+			PhoneNumber: "+6281234567890",
 			FullName:    "John Doe",
 			Password:    "P@ssw0rd",
 		}
@@ -176,42 +517,142 @@ var _ = ginkgo.Describe("Service", func() {
 
 	ginkgo.Context("Register", func() {
 		ginkgo.It("should register a user", func() {
-			userID, errs := service.Register(ctx, regReq)
+			userID, err := service.Register(ctx, regReq)
 			gomega.Expect(userID).To(gomega.Equal("mockedUserID"))
-			gomega.Expect(errs).To(gomega.BeEmpty())
+			gomega.Expect(err).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should let one of concurrent registrations of a phone number succeed", func() {
+			// The pre-checks of every registration pass, as when they race, so
+			// only the unique constraint rejects the others.
+			var mu sync.Mutex
+			registered := map[string]bool{}
+			repo.registerFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if registered[regRequest.PhoneNumber] {
+					return "", &repository.DuplicateError{Column: "phone_number", Constraint: "user_phone_number_key"}
+				}
+				registered[regRequest.PhoneNumber] = true
+				return "mockedUserID", nil
+			}
+
+			const registrations = 10
+			errs := make(chan error, registrations)
+			var wg sync.WaitGroup
+			for i := 0; i < registrations; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req := *regReq
+					_, err := service.Register(ctx, &req)
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			succeeded := 0
+			for err := range errs {
+				if err == nil {
+					succeeded++
+					continue
+				}
+				gomega.Expect(errorCode(err)).To(gomega.Equal(CodeConflict))
+				gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{
+					{"phone_number", CodePhoneNumberTaken, "Phone numbers already exists.", nil},
+				}))
+			}
+			gomega.Expect(succeeded).To(gomega.Equal(1))
+		})
+
+		ginkgo.It("should report an email taken concurrently as a conflict", func() {
+			repo.registerFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				return "", &repository.DuplicateError{Column: "email", Constraint: "user_email_key"}
+			}
+			email := "john@example.com"
+			regReq.Email = &email
+			_, err := service.Register(ctx, regReq)
+
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeConflict))
+			gomega.Expect(fieldErrorsOf(err)[0].Field).To(gomega.Equal("email"))
+			gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodeEmailTaken))
+		})
+
+		ginkgo.It("should store the phone number in E.164 format", func() {
+			var phoneNumber string
+			repo.registerFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				phoneNumber = regRequest.PhoneNumber
+				return "mockedUserID", nil
+			}
+			regReq.PhoneNumber = "+62 812-3456-7890"
+			_, err := service.Register(ctx, regReq)
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(phoneNumber).To(gomega.Equal("+6281234567890"))
+		})
+
+		ginkgo.It("should store the normalized full name", func() {
+			var fullName string
+			repo.registerFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				fullName = regRequest.FullName
+				return "mockedUserID", nil
+			}
+			regReq.FullName = "  Nguye\u0302\u0303n   Van  A "
+			_, err := service.Register(ctx, regReq)
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(fullName).To(gomega.Equal("Nguy\u1ec5n Van A"))
 		})
 
 		ginkgo.It("should return validation errors for invalid phone number", func() {
 			regReq.PhoneNumber = ""
-			userID, errs := service.Register(ctx, regReq)
+			userID, err := service.Register(ctx, regReq)
 
 			gomega.Expect(userID).To(gomega.BeEmpty())
-			gomega.Expect(errs).To(gomega.HaveLen(1))
-			gomega.Expect(errs[0]).To(gomega.ContainSubstring("Phone number"))
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeValidationFailed))
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.HaveLen(1))
+			gomega.Expect(fieldErrorsOf(err)[0].Field).To(gomega.Equal("phone_number"))
+			gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodeInvalidPhoneNumber))
 		})
 
 		ginkgo.It("should return validation errors for invalid full name", func() {
 			regReq.FullName = ""
-			userID, errs := service.Register(ctx, regReq)
+			userID, err := service.Register(ctx, regReq)
 
 			gomega.Expect(userID).To(gomega.BeEmpty())
-			gomega.Expect(errs).To(gomega.HaveLen(1))
-			gomega.Expect(errs[0]).To(gomega.ContainSubstring("Full name"))
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.HaveLen(1))
+			gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodeInvalidFullName))
+			gomega.Expect(fieldErrorsOf(err)[0].Message).To(gomega.ContainSubstring("Full name"))
 		})
 
 		ginkgo.It("should return validation errors for invalid password", func() {
 			regReq.Password = "short"
-			userID, errs := service.Register(ctx, regReq)
+			userID, err := service.Register(ctx, regReq)
 
 			gomega.Expect(userID).To(gomega.BeEmpty())
-			gomega.Expect(errs).To(gomega.HaveLen(1))
-			gomega.Expect(errs[0]).To(gomega.ContainSubstring("Password"))
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{
+				{"password", CodePasswordTooShort, "Passwords must have at least 6 characters.", []interface{}{6}},
+				{"password", CodePasswordNoUpper, "Passwords must contain at least 1 capital letter.", nil},
+				{"password", CodePasswordNoDigit, "Passwords must contain at least 1 number.", nil},
+				{"password", CodePasswordNoSpecial, "Passwords must contain at least 1 special character.", nil},
+			}))
+		})
+
+		ginkgo.It("should report a taken phone number as conflict", func() {
+			repo.isPhoneNumberExistsFunc = func(ctx context.Context, phoneNumber string) (bool, error) {
+				return true, nil
+			}
+			_, err := service.Register(ctx, regReq)
+
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeConflict))
+			gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodePhoneNumberTaken))
 		})
 	})
 
 	ginkgo.Context("Login", func() {
 		ginkgo.It("error when repository return error", func() {
-			repo.loginFunc = func(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
 				return "", errors.New("error")
 			}
 			token, err := service.Login(context.Background(), &generated.LoginRequest{})
@@ -219,8 +660,117 @@ var _ = ginkgo.Describe("Service", func() {
 			gomega.Expect(token).To(gomega.Equal(""))
 		})
 
+		ginkgo.It("should put the preferred language of the user in the token", func() {
+			lang := "id-ID"
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+				return "some_user_id", nil
+			}
+			repo.getProfileFunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+				return generated.UserProfile{Language: &lang}, nil
+			}
+			var locale interface{}
+			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
+				locale = claims["locale"]
+				return "token", nil
+			}
+			_, err := service.Login(context.Background(), &generated.LoginRequest{})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(locale).To(gomega.Equal("id-ID"))
+		})
+
+		ginkgo.It("should report unknown users and wrong passwords alike", func() {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+				return "", repository.ErrUserNotFound
+			}
+			_, err := service.Login(context.Background(), &generated.LoginRequest{})
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeInvalidCredentials))
+		})
+
+		ginkgo.It("should tell a locked user with the right password that they are locked", func() {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+				return "", repository.ErrUserLocked
+			}
+			_, err := service.Login(context.Background(), &generated.LoginRequest{})
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeUserLocked))
+		})
+
+		ginkgo.Context("when the password policy has a max age", func() {
+			ginkgo.BeforeEach(func() {
+				policy := DefaultPasswordPolicy
+				policy.MaxAge = 90 * 24 * time.Hour
+				service.Validator = NewValidator(NewValidatorOptions{Repository: &repo, Policy: &policy})
+				repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+					return "some_user_id", nil
+				}
+				repo.passwordChangedAtFunc = func(ctx context.Context, userID string) (time.Time, error) {
+					return time.Now().Add(-91 * 24 * time.Hour), nil
+				}
+				utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
+					return "token", nil
+				}
+			})
+
+			ginkgo.It("should refuse passwords older than the max age", func() {
+				_, err := service.Login(ctx, &generated.LoginRequest{Password: "P@ssw0rd"})
+				gomega.Expect(errorCode(err)).To(gomega.Equal(CodePasswordExpired))
+			})
+
+			ginkgo.It("should accept younger passwords", func() {
+				repo.passwordChangedAtFunc = func(ctx context.Context, userID string) (time.Time, error) {
+					return time.Now().Add(-89 * 24 * time.Hour), nil
+				}
+				token, err := service.Login(ctx, &generated.LoginRequest{Password: "P@ssw0rd"})
+				gomega.Expect(err).To(gomega.BeNil())
+				gomega.Expect(token).To(gomega.Equal("token"))
+			})
+
+			ginkgo.It("should change an expired password to new_password", func() {
+				var hashedPasswords []string
+				repo.updatePasswordFunc = func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+					hashedPasswords = append(hashedPasswords, hashedPassword)
+					return nil
+				}
+				newPassword := "N3w-P@ssw0rd"
+				token, err := service.Login(ctx, &generated.LoginRequest{Password: "P@ssw0rd", NewPassword: &newPassword})
+				gomega.Expect(err).To(gomega.BeNil())
+				gomega.Expect(token).To(gomega.Equal("token"))
+				gomega.Expect(hashedPasswords).To(gomega.HaveLen(1))
+			})
+
+			ginkgo.It("should hold new_password to the policy", func() {
+				newPassword := "short"
+				_, err := service.Login(ctx, &generated.LoginRequest{Password: "P@ssw0rd", NewPassword: &newPassword})
+				gomega.Expect(fieldErrorsOf(err)).ToNot(gomega.BeEmpty())
+				gomega.Expect(fieldErrorsOf(err)[0].Field).To(gomega.Equal("new_password"))
+			})
+		})
+
+		ginkgo.It("should grant the admin scope to administrators only", func() {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+				return "some_user_id", nil
+			}
+			admin := true
+			repo.isAdminFunc = func(ctx context.Context, userID string) (bool, error) {
+				return admin, nil
+			}
+			var scope interface{}
+			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
+				scope = claims["scope"]
+				return "token", nil
+			}
+
+			_, err := service.Login(context.Background(), &generated.LoginRequest{})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(scope).To(gomega.Equal(AdminScope))
+
+			admin, scope = false, nil
+			_, err = service.Login(context.Background(), &generated.LoginRequest{})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(scope).To(gomega.BeNil())
+		})
+
 		ginkgo.It("success login", func() {
-			repo.loginFunc = func(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
 				return "some_user_id", nil
 			}
 			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
@@ -231,8 +781,39 @@ var _ = ginkgo.Describe("Service", func() {
 			gomega.Expect(token).NotTo(gomega.Equal(""))
 		})
 
+		ginkgo.It("should look up the phone number in E.164 format", func() {
+			identifier := "0812 3456 7890"
+			var phoneNumber string
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
+				phoneNumber = credentials.PhoneNumber
+				return "some_user_id", nil
+			}
+			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
+				return "token", nil
+			}
+			_, err := service.Login(context.Background(), &generated.LoginRequest{Identifier: &identifier})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(phoneNumber).To(gomega.Equal("+6281234567890"))
+		})
+
+		ginkgo.It("should look up an email identifier in lower case", func() {
+			identifier := "John.Doe@Example.com"
+			var credentials repository.LoginCredentials
+			repo.loginFunc = func(ctx context.Context, c repository.LoginCredentials) (string, error) {
+				credentials = c
+				return "some_user_id", nil
+			}
+			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
+				return "token", nil
+			}
+			_, err := service.Login(context.Background(), &generated.LoginRequest{Identifier: &identifier})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(credentials.Email).To(gomega.Equal("john.doe@example.com"))
+			gomega.Expect(credentials.PhoneNumber).To(gomega.BeEmpty())
+		})
+
 		ginkgo.It("raise error when generate token failed", func() {
-			repo.loginFunc = func(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
+			repo.loginFunc = func(ctx context.Context, credentials repository.LoginCredentials) (string, error) {
 				return "some_user_id", nil
 			}
 			utils.generateJWTTokenFunc = func(claims jwt.MapClaims) (string, error) {
@@ -244,6 +825,241 @@ var _ = ginkgo.Describe("Service", func() {
 		})
 	})
 
+	ginkgo.Context("ChangePassword", func() {
+		var changePasswordRequest generated.ChangePasswordRequest
+
+		ginkgo.BeforeEach(func() {
+			changePasswordRequest = generated.ChangePasswordRequest{
+				CurrentPassword: "P@ssw0rd",
+				NewPassword:     "N3w-P@ssword",
+			}
+		})
+
+		ginkgo.It("should store the hash of the new password", func() {
+			var storedHash string
+			utils.hashingPasswordFunc = func(password string) (string, error) {
+				return "hashed:" + password, nil
+			}
+			repo.updatePasswordFunc = func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+				storedHash = hashedPassword
+				return nil
+			}
+
+			err := service.ChangePassword(ctx, changePasswordRequest, "some_user_id")
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(storedHash).To(gomega.Equal("hashed:N3w-P@ssword"))
+		})
+
+		ginkgo.It("should keep as much history as the password policy reads", func() {
+			service.PasswordHistoryDepth = 5
+			var depth int
+			repo.updatePasswordFunc = func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+				depth = historyDepth
+				return nil
+			}
+
+			gomega.Expect(service.ChangePassword(ctx, changePasswordRequest, "some_user_id")).To(gomega.Succeed())
+			gomega.Expect(depth).To(gomega.Equal(5))
+		})
+
+		ginkgo.It("should reject a wrong current password", func() {
+			repo.checkPasswordFunc = func(ctx context.Context, userID, password string) (bool, error) {
+				return false, nil
+			}
+
+			err := service.ChangePassword(ctx, changePasswordRequest, "some_user_id")
+
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{
+				{"current_password", CodeWrongPassword, "Wrong password", nil},
+			}))
+		})
+
+		ginkgo.It("should reject a password from the history", func() {
+			repo.isPasswordReusedFunc = func(ctx context.Context, userID, password string, historyDepth int) (bool, error) {
+				return true, nil
+			}
+			repo.updatePasswordFunc = func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+				ginkgo.Fail("password should not be updated")
+				return nil
+			}
+
+			err := service.ChangePassword(ctx, changePasswordRequest, "some_user_id")
+
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.HaveLen(1))
+			gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodePasswordReused))
+			gomega.Expect(fieldErrorsOf(err)[0].Message).To(gomega.ContainSubstring("must not be one of your last"))
+		})
+
+		ginkgo.It("should reject a password violating the policy", func() {
+			changePasswordRequest.NewPassword = "weak"
+
+			err := service.ChangePassword(ctx, changePasswordRequest, "some_user_id")
+
+			gomega.Expect(fieldErrorsOf(err)).To(gomega.ContainElement(
+				FieldError{"new_password", CodePasswordTooShort, "Passwords must have at least 6 characters.",
+					[]interface{}{6}}))
+		})
+	})
+
+	ginkgo.Context("CreateAdmin", func() {
+		ginkgo.It("should register the user with the administrator role", func() {
+			var registered generated.RegistrationRequest
+			repo.registerAdminFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				registered = regRequest
+				return "mockedAdminID", nil
+			}
+			repo.registerFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				ginkgo.Fail("registered without the administrator role")
+				return "", nil
+			}
+
+			userID, err := service.CreateAdmin(ctx, regReq)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(userID).To(gomega.Equal("mockedAdminID"))
+			gomega.Expect(registered.PhoneNumber).To(gomega.Equal("+6281234567890"))
+		})
+
+		ginkgo.It("should validate the registration like Register does", func() {
+			regReq.Password = "weak"
+			called := false
+			repo.registerAdminFunc = func(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+				called = true
+				return "", nil
+			}
+
+			_, err := service.CreateAdmin(ctx, regReq)
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeValidationFailed))
+			gomega.Expect(called).To(gomega.BeFalse())
+		})
+	})
+
+	ginkgo.Context("ResetPassword", func() {
+		ginkgo.It("should set the password of the user with the phone number", func() {
+			var phoneNumber, updatedUserID string
+			repo.getUserIDByPhoneNumberFunc = func(ctx context.Context, p string) (string, error) {
+				phoneNumber = p
+				return "some_user_id", nil
+			}
+			repo.updatePasswordFunc = func(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+				updatedUserID = userID
+				return nil
+			}
+
+			gomega.Expect(service.ResetPassword(ctx, "0812 3456 7890", "N3w-P@ssw0rd")).To(gomega.Succeed())
+			gomega.Expect(phoneNumber).To(gomega.Equal("+6281234567890"))
+			gomega.Expect(updatedUserID).To(gomega.Equal("some_user_id"))
+		})
+
+		ginkgo.It("should reject passwords breaking the policy", func() {
+			err := service.ResetPassword(ctx, "+6281234567890", "weak")
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeValidationFailed))
+		})
+
+		ginkgo.It("should report unknown phone numbers", func() {
+			repo.getUserIDByPhoneNumberFunc = func(ctx context.Context, phoneNumber string) (string, error) {
+				return "", repository.ErrUserNotFound
+			}
+			err := service.ResetPassword(ctx, "+6281234567890", "N3w-P@ssw0rd")
+			gomega.Expect(errorCode(err)).To(gomega.Equal(CodeUserNotFound))
+		})
+	})
+
+	ginkgo.Context("LockUser", func() {
+		ginkgo.It("should lock and unlock the user with the phone number", func() {
+			var states []bool
+			repo.setLockedFunc = func(ctx context.Context, userID string, locked bool) error {
+				gomega.Expect(userID).To(gomega.Equal("mockedUserID"))
+				states = append(states, locked)
+				return nil
+			}
+
+			gomega.Expect(service.LockUser(ctx, "+6281234567890", true)).To(gomega.Succeed())
+			gomega.Expect(service.LockUser(ctx, "+6281234567890", false)).To(gomega.Succeed())
+			gomega.Expect(states).To(gomega.Equal([]bool{true, false}))
+		})
+	})
+
+	ginkgo.Context("NormalizePhoneNumbers", func() {
+		ginkgo.BeforeEach(func() {
+			repo.listPhoneNumbersFunc = func(ctx context.Context, fn func(userID, phoneNumber string) error) error {
+				for _, user := range [][2]string{
+					{"1", "+6281234567890"},
+					{"2", "0812 3456 7891"},
+					{"3", "+62 812-3456-7892"},
+					{"4", "12345"},
+				} {
+					if err := fn(user[0], user[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		})
+
+		ginkgo.It("should rewrite the numbers that are not in E.164 format", func() {
+			updated := map[string]string{}
+			repo.setPhoneNumberFunc = func(ctx context.Context, userID, phoneNumber string) error {
+				updated[userID] = phoneNumber
+				return nil
+			}
+
+			normalization, err := service.NormalizePhoneNumbers(ctx)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(normalization.Normalized).To(gomega.Equal(2))
+			gomega.Expect(updated).To(gomega.Equal(map[string]string{"2": "+6281234567891", "3": "+6281234567892"}))
+			gomega.Expect(normalization.Failed).To(gomega.HaveLen(1))
+			var validationErr *ValidationError
+			gomega.Expect(errors.As(normalization.Failed["4"], &validationErr)).To(gomega.BeTrue())
+			gomega.Expect(validationErr.Code).To(gomega.Equal(CodeInvalidPhoneNumber))
+		})
+
+		ginkgo.It("should leave numbers another user has in E.164 format as they are", func() {
+			repo.setPhoneNumberFunc = func(ctx context.Context, userID, phoneNumber string) error {
+				if userID == "2" {
+					return &repository.DuplicateError{Column: "phone_number", Constraint: "user_phone_number_key"}
+				}
+				return nil
+			}
+
+			normalization, err := service.NormalizePhoneNumbers(ctx)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(normalization.Normalized).To(gomega.Equal(1))
+			gomega.Expect(errorCode(normalization.Failed["2"])).To(gomega.Equal(CodeConflict))
+		})
+
+		ginkgo.It("should stop at other errors", func() {
+			repo.setPhoneNumberFunc = func(ctx context.Context, userID, phoneNumber string) error {
+				return errors.New("connection reset")
+			}
+
+			_, err := service.NormalizePhoneNumbers(ctx)
+			gomega.Expect(err).To(gomega.MatchError("connection reset"))
+		})
+	})
+
+	ginkgo.Context("Health", func() {
+		ginkgo.It("should report the statistics of the pool", func() {
+			repo.poolStats = sql.DBStats{MaxOpenConnections: 25, OpenConnections: 3, InUse: 1, Idle: 2,
+				WaitCount: 4, WaitDuration: 1500 * time.Millisecond}
+
+			health := service.Health(ctx)
+			gomega.Expect(health.Status).To(gomega.Equal(generated.Ok))
+			gomega.Expect(health.Database).To(gomega.Equal(generated.DatabasePool{MaxOpenConnections: 25,
+				OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4, WaitDurationMs: 1500}))
+		})
+
+		ginkgo.It("should be unavailable when the database doesn't answer", func() {
+			repo.pingFunc = func(ctx context.Context) error {
+				_, hasDeadline := ctx.Deadline()
+				gomega.Expect(hasDeadline).To(gomega.BeTrue())
+				return errors.New("connection refused")
+			}
+
+			gomega.Expect(service.Health(ctx).Status).To(gomega.Equal(generated.Unavailable))
+		})
+	})
+
 	ginkgo.Context("GetProfile", func() {
 		var (
 			fullName    = "test user"
@@ -257,9 +1073,7 @@ var _ = ginkgo.Describe("Service", func() {
 			}
 
 			mockValidator := NewMockValidator()
-			mockValidator.MockValidateJWTToken = func(tokenString string) (*jwt.Token, error) {
-				return &jwt.Token{}, nil
-			}
+
 			serviceOpts := NewServiceOptions{
 				Repository: &mockRepo,
 				Validator:  &mockValidator,
@@ -269,9 +1083,8 @@ var _ = ginkgo.Describe("Service", func() {
 
 		})
 
-		ginkgo.It("should return the user profile when the JWT token is valid", func() {
-			token := "test"
-			userProfile, err := service.GetUserProfile(ctx, token)
+		ginkgo.It("should return the user profile of the given user", func() {
+			userProfile, err := service.GetUserProfile(ctx, "some_user_id")
 
 			// Assertions
 			gomega.Expect(err).To(gomega.BeNil())
@@ -281,3 +1094,380 @@ var _ = ginkgo.Describe("Service", func() {
 		})
 	})
 })
+
+var _ = ginkgo.Describe("Service email verification", func() {
+	var (
+		service  *service
+		repo     mockRepository
+		mailbox  *bytes.Buffer
+		verified [2]string
+	)
+
+	ginkgo.BeforeEach(func() {
+		repo = NewMockRepository()
+		verified = [2]string{}
+		repo.verifyEmailFunc = func(ctx context.Context, userID, email string) (bool, error) {
+			verified = [2]string{userID, email}
+			return true, nil
+		}
+		mockUtils := NewMockUtils()
+		mailbox = &bytes.Buffer{}
+		emailVerifier, err := utils.NewEmailVerifier(utils.NewEmailVerifierOptions{
+			Mailer: utils.NewWriterMailer(mailbox),
+			URL:    "https://example.com/email/verify",
+		})
+		gomega.Expect(err).To(gomega.BeNil())
+
+		service = NewService(NewServiceOptions{
+			Repository:    &repo,
+			Validator:     NewValidator(NewValidatorOptions{Repository: &repo}),
+			Utils:         &mockUtils,
+			EmailVerifier: emailVerifier,
+		})
+	})
+
+	mailedToken := func() string {
+		link := regexp.MustCompile(`https://example\.com/email/verify\?token=\S+`).FindString(mailbox.String())
+		gomega.Expect(link).NotTo(gomega.BeEmpty())
+		parsed, err := url.Parse(link)
+		gomega.Expect(err).To(gomega.BeNil())
+		return parsed.Query().Get("token")
+	}
+
+	ginkgo.It("should mail a link verifying the normalized address on registration", func() {
+		email := "John.Doe@Example.com"
+		_, err := service.Register(context.Background(), &generated.RegistrationRequest{
+			PhoneNumber: "+6281234567890",
+			FullName:    "John Doe",
+			Email:       &email,
+			Password:    "P@ssw0rd",
+		})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(mailbox.String()).To(gomega.ContainSubstring("To: john.doe@example.com"))
+
+		gomega.Expect(service.VerifyEmail(context.Background(), mailedToken())).To(gomega.Succeed())
+		gomega.Expect(verified).To(gomega.Equal([2]string{"mockedUserID", "john.doe@example.com"}))
+	})
+
+	ginkgo.It("should reject tampered tokens", func() {
+		email := "john@example.com"
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{Email: &email}, nil, "1")
+		gomega.Expect(err).To(gomega.BeNil())
+
+		token := mailedToken()
+		err = service.VerifyEmail(context.Background(), "x"+token)
+		gomega.Expect(err).To(gomega.MatchError(utils.ErrInvalidEmailVerificationToken))
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeInvalidEmailToken))
+		gomega.Expect(verified).To(gomega.Equal([2]string{}))
+	})
+
+	ginkgo.It("should reject tokens for an address the user no longer has", func() {
+		email := "john@example.com"
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{Email: &email}, nil, "1")
+		gomega.Expect(err).To(gomega.BeNil())
+
+		repo.verifyEmailFunc = func(ctx context.Context, userID, email string) (bool, error) {
+			return false, nil
+		}
+		err = service.VerifyEmail(context.Background(), mailedToken())
+		gomega.Expect(err).To(gomega.MatchError(utils.ErrInvalidEmailVerificationToken))
+	})
+
+	ginkgo.It("should reject tokens for an address another user verified first", func() {
+		email := "john@example.com"
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{Email: &email}, nil, "1")
+		gomega.Expect(err).To(gomega.BeNil())
+
+		repo.verifyEmailFunc = func(ctx context.Context, userID, email string) (bool, error) {
+			return false, &repository.DuplicateError{Column: "email", Constraint: "user_verified_email_key"}
+		}
+		err = service.VerifyEmail(context.Background(), mailedToken())
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeConflict))
+		gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodeEmailTaken))
+	})
+
+	ginkgo.It("should keep the verification of the address the user already has", func() {
+		email, verifiedEmail := "john@example.com", true
+		repo.getProfileFunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+			return generated.UserProfile{Email: &email, EmailVerified: &verifiedEmail}, nil
+		}
+		// The verified address of the user counts as taken.
+		repo.isEmailExistsFunc = func(ctx context.Context, email string) (bool, error) {
+			return true, nil
+		}
+		var update repository.UserProfileUpdate
+		repo.updateProfileFunc = func(ctx context.Context, u repository.UserProfileUpdate,
+			userID string) (generated.UserProfile, error) {
+			update = u
+			return generated.UserProfile{}, nil
+		}
+
+		resent := "John@Example.com"
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{Email: &resent}, nil, "1")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(update.Email).To(gomega.BeNil())
+		gomega.Expect(mailbox.Len()).To(gomega.BeZero())
+	})
+
+	ginkgo.It("should not resend a link without an address to verify", func() {
+		err := service.SendEmailVerification(context.Background(), "1")
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeNoEmail))
+		gomega.Expect(mailbox.Len()).To(gomega.BeZero())
+	})
+})
+
+var _ = ginkgo.Describe("Profile custom attributes", func() {
+	var (
+		repo    mockRepository
+		service Service
+		update  repository.UserProfileUpdate
+	)
+
+	ginkgo.BeforeEach(func() {
+		repo = NewMockRepository()
+		repo.getProfileFunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+			return generated.UserProfile{CustomAttributes: &map[string]interface{}{
+				"employee_id": "E123456",
+				"newsletter":  true,
+			}}, nil
+		}
+		repo.updateProfileFunc = func(ctx context.Context, u repository.UserProfileUpdate, userID string) (generated.UserProfile, error) {
+			update = u
+			return generated.UserProfile{}, nil
+		}
+
+		schema := &AttributeSchema{Attributes: []AttributeDefinition{
+			{Name: "employee_id", Type: AttributeString, Required: true},
+			{Name: "newsletter", Type: AttributeBoolean},
+			{Name: "shoe_size", Type: AttributeInteger},
+		}}
+		gomega.Expect(schema.Compile()).To(gomega.Succeed())
+		service = NewService(NewServiceOptions{
+			Repository: &repo,
+			Validator:  NewValidator(NewValidatorOptions{Repository: &repo, Attributes: schema}),
+		})
+	})
+
+	ginkgo.It("should merge the changes into the current attributes", func() {
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{
+			CustomAttributes: &map[string]interface{}{"shoe_size": float64(42), "newsletter": nil},
+		}, nil, "1")
+
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(update.CustomAttributes).To(gomega.Equal(map[string]interface{}{
+			"employee_id": "E123456",
+			"shoe_size":   float64(42),
+		}))
+	})
+
+	ginkgo.It("should name the invalid attributes in the field errors", func() {
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{
+			CustomAttributes: &map[string]interface{}{"employee_id": nil, "shoe_size": "large"},
+		}, nil, "1")
+
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeValidationFailed))
+		gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{
+			{"custom_attributes.employee_id", CodeRequired, "This field is required.", nil},
+			{"custom_attributes.shoe_size", CodeInvalidAttribute, "This value is not a valid integer for this attribute.",
+				[]interface{}{"integer"}},
+		}))
+	})
+
+	ginkgo.It("should store the normalized standard fields", func() {
+		timezone, avatarURL := "Asia/Jakarta", " https://example.com/a.png "
+		gender := generated.Female
+		_, err := service.UpdateUserProfile(context.Background(), generated.UpdateUserProfileRequest{
+			Gender:    &gender,
+			Timezone:  &timezone,
+			AvatarUrl: &avatarURL,
+		}, nil, "1")
+
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(*update.Gender).To(gomega.Equal("female"))
+		gomega.Expect(*update.Timezone).To(gomega.Equal("Asia/Jakarta"))
+		gomega.Expect(*update.AvatarURL).To(gomega.Equal("https://example.com/a.png"))
+		gomega.Expect(update.CustomAttributes).To(gomega.BeNil())
+	})
+})
+
+// memoryBlobStore keeps the blobs in a map, serving them at "mem://".
+type memoryBlobStore map[string][]byte
+
+func (m memoryBlobStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	m[key] = data
+	return "mem://" + key, nil
+}
+
+var _ = ginkgo.Describe("UploadAvatar", func() {
+	var (
+		repo    mockRepository
+		blobs   memoryBlobStore
+		service Service
+		update  repository.UserProfileUpdate
+	)
+
+	ginkgo.BeforeEach(func() {
+		repo = NewMockRepository()
+		repo.updateProfileFunc = func(ctx context.Context, u repository.UserProfileUpdate, userID string) (generated.UserProfile, error) {
+			update = u
+			return generated.UserProfile{AvatarUrl: u.AvatarURL}, nil
+		}
+		blobs = memoryBlobStore{}
+		service = NewService(NewServiceOptions{
+			Repository:     &repo,
+			Validator:      NewValidator(NewValidatorOptions{Repository: &repo}),
+			BlobStore:      blobs,
+			AvatarMaxBytes: 1 << 20,
+		})
+	})
+
+	avatar := func(size int) io.Reader {
+		var buf bytes.Buffer
+		gomega.Expect(png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size)))).To(gomega.Succeed())
+		return &buf
+	}
+
+	ginkgo.It("should store the avatar and its thumbnails under versioned URLs", func() {
+		userProfile, err := service.UploadAvatar(context.Background(), avatar(300), "1")
+
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(blobs).To(gomega.HaveLen(3))
+		gomega.Expect(blobs).To(gomega.HaveKey("avatars/1/256.jpg"))
+		gomega.Expect(*userProfile.AvatarUrl).To(gomega.MatchRegexp(`^mem://avatars/1/256\.jpg\?v=\w+$`))
+		gomega.Expect(update.AvatarThumbnails).To(gomega.HaveLen(2))
+		gomega.Expect(update.AvatarThumbnails["64"]).To(gomega.HavePrefix("mem://avatars/1/64.jpg?v="))
+	})
+
+	ginkgo.It("should report invalid images as field errors", func() {
+		_, err := service.UploadAvatar(context.Background(), strings.NewReader("not an image"), "1")
+
+		gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{{"avatar", CodeInvalidAvatar,
+			"Avatars must be JPEG, PNG or GIF images of at least 64×64 pixels.", []interface{}{64, 64}}}))
+		gomega.Expect(blobs).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should report files over the limit as too large", func() {
+		_, err := service.UploadAvatar(context.Background(), bytes.NewReader(make([]byte, 1<<20+1)), "1")
+
+		gomega.Expect(fieldErrorsOf(err)[0].Code).To(gomega.Equal(CodeAvatarTooLarge))
+		gomega.Expect(fieldErrorsOf(err)[0].Message).To(gomega.Equal("Avatars must be at most 1 MB."))
+	})
+})
+
+var _ = ginkgo.Describe("PatchUserProfile", func() {
+	var (
+		repo    mockRepository
+		service Service
+		updated bool
+		update  repository.UserProfileUpdate
+	)
+
+	ginkgo.BeforeEach(func() {
+		fullName, phoneNumber := "John Doe", "+6281234567890"
+		email, address, username := "john@example.com", "Jl. Sudirman 1", "john"
+		version := 5
+		repo = NewMockRepository()
+		repo.getProfileFunc = func(ctx context.Context, userID string) (generated.UserProfile, error) {
+			return generated.UserProfile{
+				FullName:         &fullName,
+				PhoneNumber:      &phoneNumber,
+				Email:            &email,
+				Username:         &username,
+				Address:          &address,
+				CustomAttributes: &map[string]interface{}{"employee_id": "E123456", "newsletter": true},
+				Version:          &version,
+			}, nil
+		}
+		updated = false
+		repo.updateProfileFunc = func(ctx context.Context, u repository.UserProfileUpdate, userID string) (generated.UserProfile, error) {
+			updated, update = true, u
+			return generated.UserProfile{}, nil
+		}
+
+		schema := &AttributeSchema{Attributes: []AttributeDefinition{
+			{Name: "employee_id", Type: AttributeString, Required: true},
+			{Name: "newsletter", Type: AttributeBoolean},
+		}}
+		gomega.Expect(schema.Compile()).To(gomega.Succeed())
+		service = NewService(NewServiceOptions{
+			Repository: &repo,
+			Validator:  NewValidator(NewValidatorOptions{Repository: &repo, Attributes: schema}),
+		})
+	})
+
+	ginkgo.It("should clear fields set to null by a merge patch and only store changes", func() {
+		_, err := service.PatchUserProfile(context.Background(), MergePatchContentType,
+			[]byte(`{"address": null, "timezone": "Asia/Jakarta", "full_name": "John Doe", "gender": null,
+				"custom_attributes": {"newsletter": null}}`), nil, "1")
+
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(update.Clear).To(gomega.Equal([]string{"address"}))
+		gomega.Expect(*update.Timezone).To(gomega.Equal("Asia/Jakarta"))
+		gomega.Expect(update.FullName).To(gomega.BeNil())
+		gomega.Expect(update.CustomAttributes).To(gomega.Equal(map[string]interface{}{"employee_id": "E123456"}))
+	})
+
+	ginkgo.It("should clear fields removed by a JSON patch", func() {
+		_, err := service.PatchUserProfile(context.Background(), JSONPatchContentType, []byte(`[
+			{"op": "test", "path": "/email", "value": "john@example.com"},
+			{"op": "remove", "path": "/email"},
+			{"op": "add", "path": "/language", "value": "en-US"}
+		]`), nil, "1")
+
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(update.Clear).To(gomega.Equal([]string{"email"}))
+		gomega.Expect(update.Email).To(gomega.BeNil())
+		gomega.Expect(*update.Language).To(gomega.Equal("en-US"))
+	})
+
+	ginkgo.It("should validate the patched document before updating", func() {
+		_, err := service.PatchUserProfile(context.Background(), MergePatchContentType,
+			[]byte(`{"full_name": null, "username": null, "email_verified": true, "address": 42}`), nil, "1")
+
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeValidationFailed))
+		gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{
+			{"email_verified", CodeUnknownField, "This field does not exist or can't be changed.", nil},
+			{"full_name", CodeRequired, "This field is required.", nil},
+			{"username", CodeRequired, "This field is required.", nil},
+			{"address", CodeInvalidValue, "This value does not match the API specification: value must be a string.",
+				[]interface{}{"value must be a string"}},
+		}))
+		gomega.Expect(updated).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should validate the values like application/json", func() {
+		_, err := service.PatchUserProfile(context.Background(), JSONPatchContentType,
+			[]byte(`[{"op": "replace", "path": "/custom_attributes/newsletter", "value": "yes"}]`), nil, "1")
+
+		gomega.Expect(fieldErrorsOf(err)).To(gomega.Equal([]FieldError{{"custom_attributes.newsletter",
+			CodeInvalidAttribute, "This value is not a valid boolean for this attribute.", []interface{}{"boolean"}}}))
+		gomega.Expect(updated).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should return a conflict when a test fails", func() {
+		_, err := service.PatchUserProfile(context.Background(), JSONPatchContentType,
+			[]byte(`[{"op": "test", "path": "/email", "value": "jane@example.com"}, {"op": "remove", "path": "/email"}]`), nil,
+			"1")
+
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodePatchTestFailed))
+		gomega.Expect(updated).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should not apply patches to other versions", func() {
+		_, err := service.PatchUserProfile(context.Background(), MergePatchContentType, []byte(`{"address": null}`),
+			[]int{4}, "1")
+
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodePreconditionFailed))
+		gomega.Expect(updated).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should reject patches that can't be applied", func() {
+		_, err := service.PatchUserProfile(context.Background(), JSONPatchContentType,
+			[]byte(`[{"op": "remove", "path": "/gender"}]`), nil, "1")
+
+		gomega.Expect(errorCode(err)).To(gomega.Equal(CodeInvalidPatch))
+		gomega.Expect(err.Error()).To(gomega.Equal(
+			"The patch can't be applied: operation 0: invalid patch: /gender does not exist."))
+		gomega.Expect(updated).To(gomega.BeFalse())
+	})
+})
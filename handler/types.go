@@ -1,6 +1,26 @@
 package handler
 
-type Data struct {
-	UserID      string `json:"user_id"`
-	PhoneNumber string `json:"phone_number"`
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID      string
+	PhoneNumber string
+	// Language preferred by the user for messages, empty if none.
+	Language string
+	Scopes   []string
+}
+
+func (p Principal) hasScopes(scopes []string) bool {
+	for _, required := range scopes {
+		found := false
+		for _, scope := range p.Scopes {
+			if scope == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
@@ -2,110 +2,375 @@ package handler
 
 import (
 	"context"
+	"crypto/rsa"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"regexp"
+	"net/url"
 	"strings"
+	"time"
+	// Time zones are validated the same way without zoneinfo on the host.
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/utils"
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	dateLayout         = "2006-01-02"
+	maxAddressLength   = 500
+	maxAvatarURLLength = 2048
 )
 
 type Validator interface {
+	NormalizePhoneNumber(phoneNumber string) (string, error)
 	IsValidPhoneNumber(phoneNumber string) error
+	NormalizeEmail(email string) (string, error)
+	IsValidEmail(email string) error
+	NormalizeUsername(username string) (string, error)
+	NormalizeLanguage(lang string) (string, error)
+	IsValidUsername(username string) error
+	NormalizeFullName(fullName string) (string, error)
 	IsValidFullName(fullName string) error
+	IsValidDateOfBirth(date string) error
+	IsValidGender(gender string) error
+	NormalizeAddress(address string) (string, error)
+	NormalizeAvatarURL(rawURL string) (string, error)
+	NormalizeTimezone(timezone string) (string, error)
+	ValidateCustomAttributes(attributes map[string]interface{}) error
 	IsValidPassword(password string) error
+	IsSafePassword(password, phoneNumber, fullName string) error
+	IsNewPassword(ctx context.Context, userID, password string) error
+	IsPasswordExpired(ctx context.Context, userID string) (bool, error)
 	ValidateJWTToken(tokenString string) (*jwt.Token, error)
 }
 
 type validator struct {
-	Repository repository.RepositoryInterface
+	Repository   repository.RepositoryInterface
+	Blocklist    utils.PasswordBlocklist
+	Policy       PasswordPolicy
+	PhoneNumbers *utils.PhoneNumberParser
+	Reserved     *utils.ReservedUsernames
+	Attributes   *AttributeSchema
+	PublicKey    *rsa.PublicKey
 }
 
 type NewValidatorOptions struct {
 	Repository repository.RepositoryInterface
+	// Blocklist is optional, breached and common passwords are only rejected
+	// when it is set.
+	Blocklist utils.PasswordBlocklist
+	// Policy defaults to DefaultPasswordPolicy.
+	Policy *PasswordPolicy
+	// PhoneNumbers defaults to Indonesian numbers only.
+	PhoneNumbers *utils.PhoneNumberParser
+	// ReservedUsernames defaults to utils.DefaultReservedUsernames.
+	ReservedUsernames *utils.ReservedUsernames
+	// Attributes defaults to a schema without custom attributes.
+	Attributes *AttributeSchema
+	// PublicKey verifies tokens, they are all rejected without it.
+	PublicKey *rsa.PublicKey
 }
 
 func NewValidator(opts NewValidatorOptions) *validator {
-	return &validator{opts.Repository}
+	policy := DefaultPasswordPolicy
+	if opts.Policy != nil {
+		policy = *opts.Policy
+	}
+	phoneNumbers := opts.PhoneNumbers
+	if phoneNumbers == nil {
+		phoneNumbers = utils.NewPhoneNumberParser(utils.NewPhoneNumberParserOptions{})
+	}
+	reserved := opts.ReservedUsernames
+	if reserved == nil {
+		reserved = utils.NewReservedUsernames(utils.DefaultReservedUsernames)
+	}
+	attributes := opts.Attributes
+	if attributes == nil {
+		attributes = &AttributeSchema{}
+	}
+	return &validator{opts.Repository, opts.Blocklist, policy, phoneNumbers, reserved, attributes, opts.PublicKey}
+}
+
+// NormalizePhoneNumber returns phoneNumber in the E.164 format it is stored and
+// looked up in.
+func (v *validator) NormalizePhoneNumber(phoneNumber string) (string, error) {
+	normalized, err := v.PhoneNumbers.Normalize(phoneNumber)
+	if errors.Is(err, utils.ErrPhoneNumberRegionNotAllowed) {
+		return "", newValidationError(CodePhoneNumberRegionNotAllowed, strings.Join(v.PhoneNumbers.AllowedRegions, ", "))
+	}
+	if err != nil {
+		return "", newValidationError(CodeInvalidPhoneNumber)
+	}
+	return normalized, nil
 }
 
+// IsValidPhoneNumber rejects invalid phone numbers and the ones that are taken.
+// The check is advisory, a number can still be taken before it is stored, and
+// failing lookups are ignored: the unique constraint of the repository has the
+// last word.
 func (v *validator) IsValidPhoneNumber(phoneNumber string) error {
-	message := `Phone numbers must start with "+62" and have 10 to 13 digits.`
-	isValid := true
-	if len(phoneNumber)-3 < 10 || len(phoneNumber)-3 > 13 {
-		isValid = false
+	normalized, err := v.NormalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	isExists, _ := v.Repository.IsPhoneNumberExists(context.Background(), normalized)
+	if isExists {
+		return newValidationError(CodePhoneNumberTaken)
+	}
+	return nil
+}
+
+// NormalizeEmail returns email in the lower case form it is stored and looked
+// up in.
+func (v *validator) NormalizeEmail(email string) (string, error) {
+	normalized, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return "", newValidationError(CodeInvalidEmail)
 	}
+	return normalized, nil
+}
 
-	if !strings.HasPrefix(phoneNumber, "+62") {
-		isValid = false
+// IsValidEmail rejects invalid email addresses and, advisorily like
+// IsValidPhoneNumber, the ones that are taken.
+func (v *validator) IsValidEmail(email string) error {
+	normalized, err := v.NormalizeEmail(email)
+	if err != nil {
+		return err
 	}
 
-	isExists, _ := v.Repository.IsPhoneNumberExists(context.Background(), phoneNumber)
+	isExists, _ := v.Repository.IsEmailExists(context.Background(), normalized)
 	if isExists {
-		message = "Phone numbers already exists."
-		isValid = false
+		return newValidationError(CodeEmailTaken)
 	}
+	return nil
+}
+
+// NormalizeUsername returns username in the case folded form it is stored and
+// looked up in.
+func (v *validator) NormalizeUsername(username string) (string, error) {
+	normalized, err := utils.NormalizeUsername(username)
+	if errors.Is(err, utils.ErrMixedScriptUsername) {
+		return "", newValidationError(CodeMixedScriptUsername)
+	}
+	if err != nil {
+		return "", newValidationError(CodeInvalidUsername)
+	}
+	return normalized, nil
+}
 
-	if !isValid {
-		return errors.New(message)
+// IsValidUsername rejects reserved usernames and, advisorily like
+// IsValidPhoneNumber, the ones that are taken or confusable with a taken one.
+func (v *validator) IsValidUsername(username string) error {
+	normalized, err := v.NormalizeUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if v.Reserved.Contains(normalized) {
+		return newValidationError(CodeUsernameReserved)
+	}
+
+	isExists, _ := v.Repository.IsUsernameExists(context.Background(), normalized)
+	if isExists {
+		return newValidationError(CodeUsernameTaken)
 	}
 	return nil
 }
 
+// NormalizeLanguage returns the supported language closest to lang, e.g.
+// "id-ID" for "id", as BCP 47 tag.
+func (v *validator) NormalizeLanguage(lang string) (string, error) {
+	tag, err := language.Parse(lang)
+	if err == nil {
+		if supported, ok := matchLanguage(tag); ok {
+			return supported.String(), nil
+		}
+	}
+
+	names := make([]string, 0, len(supportedLanguages))
+	for _, supported := range supportedLanguages {
+		names = append(names, supported.String())
+	}
+	return "", newValidationError(CodeUnsupportedLanguage, strings.Join(names, ", "))
+}
+
+// NormalizeFullName returns fullName in the NFC normalized form it is stored
+// in, with its whitespace collapsed.
+func (v *validator) NormalizeFullName(fullName string) (string, error) {
+	normalized, err := utils.NormalizeFullName(fullName)
+	if err != nil {
+		return "", newValidationError(CodeInvalidFullName)
+	}
+	return normalized, nil
+}
+
 func (v *validator) IsValidFullName(fullName string) error {
-	message := "Full name must be at minimum 3 characters and maximum 60 characters."
-	if len(fullName) < 3 || len(fullName) > 60 {
-		return errors.New(message)
+	_, err := v.NormalizeFullName(fullName)
+	return err
+}
+
+// IsValidDateOfBirth accepts past dates in the "YYYY-MM-DD" format, from 1900
+// on.
+func (v *validator) IsValidDateOfBirth(date string) error {
+	parsed, err := time.Parse(dateLayout, date)
+	if err != nil || parsed.Year() < 1900 || parsed.After(time.Now()) {
+		return newValidationError(CodeInvalidDateOfBirth)
 	}
 	return nil
 }
 
+func (v *validator) IsValidGender(gender string) error {
+	switch generated.Gender(gender) {
+	case generated.Female, generated.Male, generated.Other:
+		return nil
+	}
+	return newValidationError(CodeInvalidGender)
+}
+
+// NormalizeAddress returns address NFC normalized, with line breaks as "\n"
+// and the whitespace around every line trimmed.
+func (v *validator) NormalizeAddress(address string) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(address, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	normalized := norm.NFC.String(strings.TrimSpace(strings.Join(lines, "\n")))
+
+	invalid := utf8.RuneCountInString(normalized) > maxAddressLength
+	for _, r := range normalized {
+		if (r != '\n' && unicode.In(r, unicode.Cc, unicode.Cf)) || r == unicode.ReplacementChar {
+			invalid = true
+		}
+	}
+	if invalid {
+		return "", newValidationError(CodeInvalidAddress, maxAddressLength)
+	}
+	return normalized, nil
+}
+
+// NormalizeAvatarURL accepts absolute http and https URLs without credentials.
+func (v *validator) NormalizeAvatarURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		len(rawURL) > maxAvatarURLLength {
+		return "", newValidationError(CodeInvalidAvatarURL)
+	}
+	return u.String(), nil
+}
+
+// NormalizeTimezone returns the IANA name of timezone, e.g. "Asia/Jakarta".
+func (v *validator) NormalizeTimezone(timezone string) (string, error) {
+	if timezone == "" || timezone == "Local" {
+		return "", newValidationError(CodeInvalidTimezone)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return "", newValidationError(CodeInvalidTimezone)
+	}
+	return location.String(), nil
+}
+
+// ValidateCustomAttributes checks attributes against the attribute schema,
+// the error is a *CustomAttributesError listing every invalid attribute.
+func (v *validator) ValidateCustomAttributes(attributes map[string]interface{}) error {
+	return v.Attributes.Check(attributes)
+}
+
+// IsValidPassword checks password against the configured password policy. The
+// returned error is a *PasswordPolicyError listing every violated rule.
 func (v *validator) IsValidPassword(password string) error {
-	upperCaseRegex := `[A-Z]`
-	digitRegex := `\d`
-	specialCharRegex := `[^A-Za-z0-9]`
-	lengthRegex := `.{6,}`
+	return v.Policy.Check(password)
+}
 
-	message := "Passwords must have at least 6 characters, including 1 capital letter, 1 number, and 1 special character."
+// IsSafePassword rejects passwords that are easy to guess for an attacker who
+// knows the user or has access to breached password lists.
+func (v *validator) IsSafePassword(password, phoneNumber, fullName string) error {
+	if containsPersonalInfo(password, v.PhoneNumbers.NationalNumber(phoneNumber), fullName) {
+		return newValidationError(CodePasswordPersonalInfo)
+	}
 
-	if len(password) < 6 {
-		return errors.New(message)
+	if v.Blocklist == nil {
+		return nil
 	}
 
-	for _, pattern := range []string{upperCaseRegex, digitRegex, specialCharRegex, lengthRegex} {
-		regex, err := regexp.Compile(pattern)
-		if err != nil {
-			return err
-		}
+	if v.Blocklist.IsCommon(password) {
+		return newValidationError(CodePasswordCommon)
+	}
 
-		if !regex.MatchString(password) {
-			return errors.New(message)
-		}
+	if v.Blocklist.IsBreached(password) {
+		return newValidationError(CodePasswordBreached)
 	}
 
 	return nil
 }
 
-func (v *validator) ValidateJWTToken(tokenString string) (*jwt.Token, error) {
-	var publicKeyPath = os.Getenv("PUBLIC_KEY")
-	publicKeyBytes, err := ioutil.ReadFile(publicKeyPath)
+// IsNewPassword rejects password if it is one of the last passwords of the
+// user, as many as the history depth of the password policy.
+func (v *validator) IsNewPassword(ctx context.Context, userID, password string) error {
+	isReused, err := v.Repository.IsPasswordReused(ctx, userID, password, v.Policy.HistoryDepth)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read public key file: %v", err)
+		return err
+	}
+
+	if isReused {
+		return newValidationError(CodePasswordReused, v.Policy.HistoryDepth)
+	}
+	return nil
+}
+
+// IsPasswordExpired reports whether the password of the user is older than
+// the max age of the password policy, passwords never expire without one.
+func (v *validator) IsPasswordExpired(ctx context.Context, userID string) (bool, error) {
+	if v.Policy.MaxAge == 0 {
+		return false, nil
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyBytes)
+	changedAt, err := v.Repository.GetPasswordChangedAt(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %v", err)
+		return false, err
+	}
+	return time.Since(changedAt) > v.Policy.MaxAge, nil
+}
+
+// containsPersonalInfo matches the national number regardless of how the
+// prefix was written, e.g. +6281234567890, 6281234567890 and 081234567890.
+func containsPersonalInfo(password, nationalNumber, fullName string) bool {
+	password = strings.ToLower(password)
+
+	if len(nationalNumber) >= 6 && strings.Contains(password, nationalNumber) {
+		return true
+	}
+
+	names := strings.Fields(strings.ToLower(fullName))
+	if len(names) > 1 {
+		names = append(names, strings.Join(names, ""))
+	}
+	for _, name := range names {
+		if utf8.RuneCountInString(name) >= 3 && strings.Contains(password, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *validator) ValidateJWTToken(tokenString string) (*jwt.Token, error) {
+	if v.PublicKey == nil {
+		return nil, errors.New("no public key configured")
 	}
 
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return publicKey, nil
+		return v.PublicKey, nil
 	})
 
 	if err != nil {
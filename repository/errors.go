@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error codes, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	pqUniqueViolation      = "23505"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// uniqueConstraintColumns are the columns of the unique constraints and
+// indexes created by the migrations, by name.
+var uniqueConstraintColumns = map[string]string{
+	"user_phone_number_key":      "phone_number",
+	"user_email_key":             "email",
+	"user_verified_email_key":    "email",
+	"user_username_key":          "username",
+	"user_username_skeleton_key": "username",
+}
+
+// translateError translates the errors of Postgres callers can act upon into
+// errors of this package, other errors are returned as they are.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	if pqErr.Code == pqUniqueViolation {
+		return &DuplicateError{Column: uniqueConstraintColumns[pqErr.Constraint], Constraint: pqErr.Constraint}
+	}
+	return err
+}
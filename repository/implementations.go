@@ -2,12 +2,14 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/SawitProRecruitment/UserService/utils"
 )
 
 func (r *Repository) IsPhoneNumberExists(ctx context.Context, phoneNumber string) (bool, error) {
@@ -24,108 +26,701 @@ func (r *Repository) IsPhoneNumberExists(ctx context.Context, phoneNumber string
 	return false, nil
 }
 
-func (r *Repository) Register(ctx context.Context, regRequest generated.RegistrationRequest,
-	salt string) (string, error) {
-	var userID string
+// IsEmailExists reports whether email is the verified address of a user.
+// Unverified addresses don't count, anyone could have entered them.
+func (r *Repository) IsEmailExists(ctx context.Context, email string) (bool, error) {
+	var nData int
+	sqlStmt := "SELECT count(email) FROM public.user WHERE email = $1 AND email_verified_at IS NOT NULL"
 
-	tx, err := r.Db.Begin()
-	if err != nil {
-		return "", err
+	if err := r.Db.QueryRowContext(ctx, sqlStmt, email).Scan(&nData); err != nil {
+		return false, err
 	}
 
-	if err := tx.QueryRowContext(ctx, `INSERT INTO public.user (full_name, phone_number) VALUES ($1, $2) RETURNING id`,
-		regRequest.FullName, regRequest.PhoneNumber).Scan(&userID); err != nil {
-		return "", err
+	return nData > 0, nil
+}
+
+// IsUsernameExists reports whether username, or a username confusable with it,
+// is taken.
+func (r *Repository) IsUsernameExists(ctx context.Context, username string) (bool, error) {
+	var nData int
+	sqlStmt := "SELECT count(username) FROM public.user WHERE username_skeleton = $1"
+
+	if err := r.Db.QueryRowContext(ctx, sqlStmt, utils.UsernameSkeleton(username)).Scan(&nData); err != nil {
+		return false, err
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO public.login (user_id, success_login) VALUES ($1, $2)", userID, 0)
+	return nData > 0, nil
+}
+
+// Register stores a new user. The unique constraints of the user table are
+// what keeps phone numbers unique, a value taken concurrently is reported as a
+// *DuplicateError. Emails are only unique once verified.
+func (r *Repository) Register(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+	return r.register(ctx, regRequest, false)
+}
+
+// RegisterAdmin stores a new user with the administrator role, like Register
+// does, in the same transaction.
+func (r *Repository) RegisterAdmin(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+	return r.register(ctx, regRequest, true)
+}
+
+func (r *Repository) register(ctx context.Context, regRequest generated.RegistrationRequest, admin bool) (string, error) {
+	var userID string
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO public.user (full_name, phone_number, email) VALUES ($1, $2, $3) RETURNING id`,
+			regRequest.FullName, regRequest.PhoneNumber, regRequest.Email).Scan(&userID); err != nil {
+			return err
+		}
+
+		if admin {
+			if _, err := tx.ExecContext(ctx, "UPDATE public.user SET is_admin = true WHERE id = $1", userID); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO public.login (user_id, success_login) VALUES ($1, $2)", userID, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO public.password (user_id, password) VALUES ($1, $2)",
+			userID, regRequest.Password)
+		return err
+	})
 	if err != nil {
-		return "", err
+		return "", translateError(err)
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO public.password (user_id, password, salt) VALUES ($1, $2, $3)",
-		userID, regRequest.Password, salt)
-	if err != nil {
-		return "", err
+	return userID, nil
+}
+
+// Login checks the password of the user identified by the phone number, or
+// by the email address once it is verified.
+func (r *Repository) Login(ctx context.Context, credentials LoginCredentials) (string, error) {
+	sqlStmt, identifier := "SELECT id, locked_at IS NOT NULL FROM public.user WHERE phone_number = $1",
+		credentials.PhoneNumber
+	if credentials.Email != "" {
+		sqlStmt = "SELECT id, locked_at IS NOT NULL FROM public.user WHERE email = $1 AND email_verified_at IS NOT NULL"
+		identifier = credentials.Email
 	}
 
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
+	var userID string
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx, sqlStmt, identifier).Scan(&userID, &locked); errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if userID == "" {
+			return ErrUserNotFound
+		}
+
+		var hashedPassword, salt string
+		if err := tx.QueryRowContext(ctx, "SELECT password, COALESCE(salt, '') FROM public.password WHERE user_id = $1",
+			userID).Scan(&hashedPassword, &salt); err != nil {
+			return err
+		}
+
+		if ok, err := r.Hasher.Verify(credentials.Password, salt, hashedPassword); err != nil || !ok {
+			return ErrWrongPassword
+		}
+		// Only users proving who they are learn that they are locked.
+		if locked {
+			return ErrUserLocked
+		}
+
+		// Upgrade hashes made with a legacy algorithm or outdated parameters
+		// now that the plain password is known.
+		if r.Hasher.NeedsRehash(hashedPassword) {
+			newHashedPassword, err := r.Hasher.Hash(credentials.Password)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, "UPDATE public.password SET password = $1, salt = NULL WHERE user_id = $2",
+				newHashedPassword, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx,
+			"UPDATE public.login SET success_login = success_login + 1 WHERE user_id = $1", userID)
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
 
 	return userID, nil
 }
 
-func (r *Repository) Login(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
-	tx, err := r.Db.Begin()
+func (r *Repository) CheckPassword(ctx context.Context, userID, password string) (bool, error) {
+	var hashedPassword, salt string
+	if err := r.Db.QueryRowContext(ctx, "SELECT password, COALESCE(salt, '') FROM public.password WHERE user_id = $1",
+		userID).Scan(&hashedPassword, &salt); err != nil {
+		return false, err
+	}
+
+	ok, err := r.Hasher.Verify(password, salt, hashedPassword)
 	if err != nil {
-		return "", err
+		return false, err
 	}
+	return ok, nil
+}
 
-	var userID string
-	if err := tx.QueryRowContext(ctx, "SELECT id FROM public.user WHERE phone_number = $1",
-		loginRequest.PhoneNumber).Scan(&userID); err != nil {
-		return "", err
+// IsPasswordReused reports whether password matches one of the last
+// historyDepth passwords of the user, the current one included. Every row is
+// verified with the algorithm and pepper it was hashed with.
+func (r *Repository) IsPasswordReused(ctx context.Context, userID, password string,
+	historyDepth int) (bool, error) {
+	if historyDepth < 1 {
+		return false, nil
 	}
 
-	if userID == "" {
-		return "", errors.New("User not found.")
+	rows, err := r.Db.QueryContext(ctx, `SELECT password, COALESCE(salt, '') FROM public.password WHERE user_id = $1
+UNION ALL
+(SELECT password, COALESCE(salt, '') FROM public.password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2)`,
+		userID, historyDepth-1)
+	if err != nil {
+		return false, err
 	}
+	defer rows.Close()
 
-	var hashedPassword, salt string
-	if err := tx.QueryRowContext(ctx, "SELECT password, salt FROM public.password WHERE user_id = $1",
-		userID).Scan(&hashedPassword, &salt); err != nil {
-		return "", err
+	for rows.Next() {
+		var hashedPassword, salt string
+		if err := rows.Scan(&hashedPassword, &salt); err != nil {
+			return false, err
+		}
+
+		if ok, err := r.Hasher.Verify(password, salt, hashedPassword); err == nil && ok {
+			return true, nil
+		}
 	}
 
-	providedPasswordWithSalt := []byte(loginRequest.Password + salt)
+	return false, rows.Err()
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), providedPasswordWithSalt)
-	if err == nil {
-		_, err := tx.ExecContext(ctx,
-			"UPDATE public.login SET success_login = success_login + 1 WHERE user_id = $1", userID)
+// UpdatePassword moves the current password of the user to the password
+// history and replaces it with hashedPassword. Only the history that
+// IsPasswordReused reads with historyDepth is kept, older passwords are
+// deleted.
+func (r *Repository) UpdatePassword(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+	kept := historyDepth - 1
+	if kept < 0 {
+		kept = 0
+	}
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO public.password_history (user_id, password, salt)
+SELECT user_id, password, salt FROM public.password WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE public.password SET password = $1, salt = NULL, changed_at = now() WHERE user_id = $2",
+			hashedPassword, userID)
 		if err != nil {
-			return "", err
+			return err
 		}
-	} else {
-		return "", errors.New("Wrong password")
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM public.password_history WHERE user_id = $1 AND id NOT IN
+(SELECT id FROM public.password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2)`, userID, kept)
+		return err
+	})
+}
+
+// GetPasswordChangedAt returns when the password of the user was set.
+func (r *Repository) GetPasswordChangedAt(ctx context.Context, userID string) (time.Time, error) {
+	var changedAt time.Time
+	err := r.Db.QueryRowContext(ctx, "SELECT changed_at FROM public.password WHERE user_id = $1", userID).
+		Scan(&changedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrUserNotFound
 	}
+	return changedAt, err
+}
 
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		return "", err
+// VerifyEmail marks email as verified if it still is the address of the
+// user. An address another user verified first is reported as a
+// *DuplicateError.
+func (r *Repository) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	result, err := r.Db.ExecContext(ctx,
+		"UPDATE public.user SET email_verified_at = COALESCE(email_verified_at, now()), version = version + 1 "+
+			"WHERE id = $1 AND email = $2",
+		userID, email)
+	if err != nil {
+		return false, translateError(err)
 	}
 
-	return userID, nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// GetUserIDByPhoneNumber returns the ID of the user with the normalized
+// phoneNumber.
+func (r *Repository) GetUserIDByPhoneNumber(ctx context.Context, phoneNumber string) (string, error) {
+	var userID string
+	err := r.Db.QueryRowContext(ctx, "SELECT id FROM public.user WHERE phone_number = $1", phoneNumber).
+		Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	return userID, err
 }
 
 func (r *Repository) GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error) {
-	var userProfile generated.UserProfile
-	sqlStmt := "SELECT full_name, phone_number FROM public.user WHERE id = $1"
-	if err := r.Db.QueryRowContext(ctx, sqlStmt, userID).Scan(&userProfile.FullName, &userProfile.PhoneNumber); err != nil {
+	return getUserProfile(ctx, r.Db, userID)
+}
+
+func getUserProfile(ctx context.Context, q querier, userID string) (generated.UserProfile, error) {
+	var row profileRow
+	sqlStmt := "SELECT " + userProfileColumns + " FROM public.user WHERE id = $1"
+	if err := q.QueryRowContext(ctx, sqlStmt, userID).Scan(row.dest()...); errors.Is(err, sql.ErrNoRows) {
+		return generated.UserProfile{}, ErrUserNotFound
+	} else if err != nil {
 		return generated.UserProfile{}, err
 	}
+	return row.decode(userID)
+}
+
+// userProfileColumns are the columns of a user profile, scanned into a
+// profileRow.
+const userProfileColumns = "full_name, phone_number, email, email_verified_at IS NOT NULL, username, language, " +
+	"to_char(date_of_birth, 'YYYY-MM-DD'), gender, address, avatar_url, avatar_thumbnails, timezone, " +
+	"custom_attributes, version"
+
+// profileRow is a user profile as it is stored, its JSON columns are decoded
+// by decode.
+type profileRow struct {
+	profile                            generated.UserProfile
+	avatarThumbnails, customAttributes []byte
+}
+
+func (p *profileRow) dest() []interface{} {
+	return []interface{}{&p.profile.FullName, &p.profile.PhoneNumber, &p.profile.Email, &p.profile.EmailVerified,
+		&p.profile.Username, &p.profile.Language, &p.profile.DateOfBirth, &p.profile.Gender, &p.profile.Address,
+		&p.profile.AvatarUrl, &p.avatarThumbnails, &p.profile.Timezone, &p.customAttributes, &p.profile.Version}
+}
+
+func (p *profileRow) decode(userID string) (generated.UserProfile, error) {
+	userProfile := p.profile
+	if len(p.avatarThumbnails) > 0 {
+		thumbnails := map[string]string{}
+		if err := json.Unmarshal(p.avatarThumbnails, &thumbnails); err != nil {
+			return generated.UserProfile{}, fmt.Errorf("invalid avatar thumbnails of user %s: %v", userID, err)
+		}
+		userProfile.AvatarThumbnails = &thumbnails
+	}
+
+	attributes := map[string]interface{}{}
+	if len(p.customAttributes) > 0 {
+		if err := json.Unmarshal(p.customAttributes, &attributes); err != nil {
+			return generated.UserProfile{}, fmt.Errorf("invalid custom attributes of user %s: %v", userID, err)
+		}
+	}
+	userProfile.CustomAttributes = &attributes
 	return userProfile, nil
 }
 
+// clearableProfileColumns are the columns of optional fields of profiles.
+var clearableProfileColumns = map[string]bool{
+	"email":         true,
+	"language":      true,
+	"date_of_birth": true,
+	"gender":        true,
+	"address":       true,
+	"avatar_url":    true,
+	"timezone":      true,
+}
+
 func (r *Repository) UpdateUserProfile(ctx context.Context,
-	updateUserProfileRequest map[string]string, userID string) (generated.UserProfile, error) {
-	if len(updateUserProfileRequest) == 0 {
-		return r.GetUserProfile(ctx, userID)
+	update UserProfileUpdate, userID string) (generated.UserProfile, error) {
+	builder := newUpdateBuilder("public.user", "full_name", "phone_number", "email", "email_verified_at",
+		"username", "username_skeleton", "username_changed_at", "language", "date_of_birth", "gender", "address",
+		"avatar_url", "avatar_thumbnails", "timezone", "custom_attributes")
+	if update.FullName != nil {
+		if err := builder.Set("full_name", *update.FullName); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.PhoneNumber != nil {
+		if err := builder.Set("phone_number", *update.PhoneNumber); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.Email != nil {
+		if err := builder.Set("email", *update.Email); err != nil {
+			return generated.UserProfile{}, err
+		}
+		if err := builder.Set("email_verified_at", nil); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.Username != nil {
+		if err := builder.Set("username", *update.Username); err != nil {
+			return generated.UserProfile{}, err
+		}
+		if err := builder.Set("username_skeleton", utils.UsernameSkeleton(*update.Username)); err != nil {
+			return generated.UserProfile{}, err
+		}
+		if err := builder.Set("username_changed_at", time.Now()); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+
+	if update.Language != nil {
+		if err := builder.Set("language", *update.Language); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+
+	if update.DateOfBirth != nil {
+		if err := builder.Set("date_of_birth", *update.DateOfBirth); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.Gender != nil {
+		if err := builder.Set("gender", *update.Gender); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.Address != nil {
+		if err := builder.Set("address", *update.Address); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.AvatarURL != nil {
+		if err := builder.Set("avatar_url", *update.AvatarURL); err != nil {
+			return generated.UserProfile{}, err
+		}
+		var thumbnails interface{}
+		if update.AvatarThumbnails != nil {
+			encoded, err := json.Marshal(update.AvatarThumbnails)
+			if err != nil {
+				return generated.UserProfile{}, err
+			}
+			thumbnails = string(encoded)
+		}
+		if err := builder.Set("avatar_thumbnails", thumbnails); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.Timezone != nil {
+		if err := builder.Set("timezone", *update.Timezone); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	if update.CustomAttributes != nil {
+		customAttributes, err := json.Marshal(update.CustomAttributes)
+		if err != nil {
+			return generated.UserProfile{}, err
+		}
+		if err := builder.Set("custom_attributes", string(customAttributes)); err != nil {
+			return generated.UserProfile{}, err
+		}
+	}
+	for _, column := range update.Clear {
+		if !clearableProfileColumns[column] {
+			return generated.UserProfile{}, fmt.Errorf("column %s can't be cleared", column)
+		}
+		if err := builder.Set(column, nil); err != nil {
+			return generated.UserProfile{}, err
+		}
+		var dependent string
+		switch column {
+		case "email":
+			dependent = "email_verified_at"
+		case "avatar_url":
+			dependent = "avatar_thumbnails"
+		}
+		if dependent != "" {
+			if err := builder.Set(dependent, nil); err != nil {
+				return generated.UserProfile{}, err
+			}
+		}
 	}
 
-	sqlStmt := "UPDATE public.user SET "
-	for key, value := range updateUserProfileRequest {
-		sqlStmt += fmt.Sprintf("%s = '%s', ", key, value)
+	if builder.IsEmpty() {
+		userProfile, err := r.GetUserProfile(ctx, userID)
+		if err == nil && update.Versions != nil && !hasVersion(update.Versions, *userProfile.Version) {
+			return generated.UserProfile{}, ErrVersionMismatch
+		}
+		return userProfile, err
 	}
-	sqlStmt = strings.TrimSuffix(sqlStmt, ", ")
-	sqlStmt += " WHERE id = $1"
 
-	_, err := r.Db.ExecContext(ctx, sqlStmt, userID)
+	builder.Increment("version")
+	if update.Versions != nil {
+		versions := make([]interface{}, len(update.Versions))
+		for i, version := range update.Versions {
+			versions[i] = version
+		}
+		builder.WhereIn("version", versions...)
+	}
+	sqlStmt, args := builder.Build("id", userID)
+
+	var userProfile generated.UserProfile
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		if update.Username != nil {
+			if err := checkUsernameChange(ctx, tx, userID, update.UsernameChangeInterval); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, sqlStmt, args...)
+		if err != nil {
+			return translateError(err)
+		}
+
+		userProfile, err = getUserProfile(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if update.Versions != nil {
+			// The profile exists, so it had another version when nothing
+			// was updated.
+			if updated, err := result.RowsAffected(); err != nil {
+				return err
+			} else if updated == 0 {
+				return ErrVersionMismatch
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return generated.UserProfile{}, err
 	}
+	return userProfile, nil
+}
+
+func hasVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUsernameChange returns a *UsernameChangeTooSoonError if the username of
+// the user changed less than interval ago. Setting the first username is not
+// limited. The row of the user is locked until tx ends, so concurrent changes
+// are checked one after the other.
+func checkUsernameChange(ctx context.Context, tx *sql.Tx, userID string, interval time.Duration) error {
+	var changedAt sql.NullTime
+	if err := tx.QueryRowContext(ctx, "SELECT username_changed_at FROM public.user WHERE id = $1 FOR UPDATE",
+		userID).Scan(&changedAt); errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if changedAt.Valid && time.Since(changedAt.Time) < interval {
+		return &UsernameChangeTooSoonError{NextChangeAt: changedAt.Time.Add(interval)}
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey records request as in progress. If its key is taken
+// and has not expired, the request that took it is returned instead along
+// with false.
+func (r *Repository) ReserveIdempotencyKey(ctx context.Context,
+	request IdempotentRequest) (IdempotentRequest, bool, error) {
+	sqlStmt := "INSERT INTO public.idempotency_key (scope, key, fingerprint, expires_at) VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT (scope, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, " +
+		"expires_at = EXCLUDED.expires_at, status = NULL, header = NULL, body = NULL " +
+		"WHERE idempotency_key.expires_at <= now() RETURNING key"
+
+	// The key can be released between the insert and the select, it is
+	// reserved again then.
+	for attempt := 0; attempt < 3; attempt++ {
+		var key string
+		err := r.Db.QueryRowContext(ctx, sqlStmt, request.Scope, request.Key, request.Fingerprint,
+			request.ExpiresAt).Scan(&key)
+		if err == nil {
+			return request, true, nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return IdempotentRequest{}, false, err
+		}
+
+		existing, err := r.getIdempotentRequest(ctx, request.Scope, request.Key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return IdempotentRequest{}, false, err
+		}
+		return existing, false, nil
+	}
+	return IdempotentRequest{}, false, fmt.Errorf("idempotency key %q is released repeatedly", request.Key)
+}
+
+func (r *Repository) getIdempotentRequest(ctx context.Context, scope, key string) (IdempotentRequest, error) {
+	request := IdempotentRequest{Scope: scope, Key: key}
+	var status sql.NullInt64
+	var header, body []byte
+	sqlStmt := "SELECT fingerprint, expires_at, status, header, body FROM public.idempotency_key " +
+		"WHERE scope = $1 AND key = $2"
+	if err := r.Db.QueryRowContext(ctx, sqlStmt, scope, key).Scan(&request.Fingerprint, &request.ExpiresAt,
+		&status, &header, &body); err != nil {
+		return IdempotentRequest{}, err
+	}
+
+	if status.Valid {
+		response := &IdempotentResponse{Status: int(status.Int64), Body: body}
+		if len(header) > 0 {
+			if err := json.Unmarshal(header, &response.Header); err != nil {
+				return IdempotentRequest{}, fmt.Errorf("invalid header of idempotency key %q: %v", key, err)
+			}
+		}
+		request.Response = response
+	}
+	return request, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request of a reserved key,
+// it is replayed until expiresAt.
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, scope, key string,
+	response IdempotentResponse, expiresAt time.Time) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	sqlStmt := "UPDATE public.idempotency_key SET status = $1, header = $2, body = $3, expires_at = $4 " +
+		"WHERE scope = $5 AND key = $6"
+	_, err = r.Db.ExecContext(ctx, sqlStmt, response.Status, header, response.Body, expiresAt, scope, key)
+	return err
+}
+
+// ReleaseIdempotencyKey removes a key still in progress, so the request can be
+// retried, e.g. after it failed unexpectedly.
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	sqlStmt := "DELETE FROM public.idempotency_key WHERE scope = $1 AND key = $2 AND status IS NULL"
+	_, err := r.Db.ExecContext(ctx, sqlStmt, scope, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes the expired keys and returns how many
+// there were.
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := r.Db.ExecContext(ctx, "DELETE FROM public.idempotency_key WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *Repository) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	var admin bool
+	err := r.Db.QueryRowContext(ctx, "SELECT is_admin FROM public.user WHERE id = $1", userID).Scan(&admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	return admin, err
+}
+
+// GetUserAccess returns whether the user is an administrator and whether
+// they are locked out, or ErrUserNotFound.
+func (r *Repository) GetUserAccess(ctx context.Context, userID string) (UserAccess, error) {
+	var access UserAccess
+	err := r.Db.QueryRowContext(ctx, "SELECT is_admin, locked_at IS NOT NULL FROM public.user WHERE id = $1", userID).
+		Scan(&access.IsAdmin, &access.Locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserAccess{}, ErrUserNotFound
+	}
+	return access, err
+}
+
+// SetAdmin grants or revokes the administrator role of the user.
+func (r *Repository) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	return r.updateUser(ctx, "UPDATE public.user SET is_admin = $1 WHERE id = $2", admin, userID)
+}
 
-	return r.GetUserProfile(ctx, userID)
+// SetLocked locks the user out, or lets them in again. Locking a locked user
+// keeps the time they were locked at.
+func (r *Repository) SetLocked(ctx context.Context, userID string, locked bool) error {
+	sqlStmt := "UPDATE public.user SET locked_at = NULL WHERE id = $1"
+	if locked {
+		sqlStmt = "UPDATE public.user SET locked_at = COALESCE(locked_at, now()) WHERE id = $1"
+	}
+	return r.updateUser(ctx, sqlStmt, userID)
+}
+
+// SetPhoneNumber replaces the phone number of the user, a number taken by
+// another user is reported as a *DuplicateError.
+func (r *Repository) SetPhoneNumber(ctx context.Context, userID, phoneNumber string) error {
+	return translateError(r.updateUser(ctx,
+		"UPDATE public.user SET phone_number = $1, version = version + 1 WHERE id = $2", phoneNumber, userID))
+}
+
+// updateUser runs an UPDATE of a single user, ErrUserNotFound is returned
+// when it doesn't update any row.
+func (r *Repository) updateUser(ctx context.Context, sqlStmt string, args ...interface{}) error {
+	result, err := r.Db.ExecContext(ctx, sqlStmt, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ListPhoneNumbers calls fn with the ID and the phone number of every user by
+// ascending ID, until fn returns an error.
+func (r *Repository) ListPhoneNumbers(ctx context.Context, fn func(userID, phoneNumber string) error) error {
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, phone_number FROM public.user ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID, phoneNumber string
+		if err := rows.Scan(&userID, &phoneNumber); err != nil {
+			return err
+		}
+		if err := fn(userID, phoneNumber); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportUsers calls fn with every user by ascending ID, until fn returns an
+// error. Users are read in a single query, there is no snapshot to page
+// through.
+func (r *Repository) ExportUsers(ctx context.Context, fn func(user ExportedUser) error) error {
+	rows, err := r.Db.QueryContext(ctx,
+		"SELECT id, is_admin, locked_at, "+userProfileColumns+" FROM public.user ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user ExportedUser
+		var row profileRow
+		if err := rows.Scan(append([]interface{}{&user.ID, &user.IsAdmin, &user.LockedAt}, row.dest()...)...); err != nil {
+			return err
+		}
+		if user.Profile, err = row.decode(user.ID); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
@@ -3,16 +3,40 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/utils"
+	"github.com/lib/pq"
 	ginkgo "github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// argon2idHashArg matches any PHC formatted Argon2id hash.
+type argon2idHashArg struct{}
+
+func (argon2idHashArg) Match(v driver.Value) bool {
+	hash, ok := v.(string)
+	return ok && strings.HasPrefix(hash, "$argon2id$")
+}
+
+// profileQuery matches the query of GetUserProfile, which returns the
+// profileColumns.
+const profileQuery = "SELECT full_name, phone_number, email, email_verified_at IS NOT NULL, username, language, " +
+	"to_char\\(date_of_birth, 'YYYY-MM-DD'\\), gender, address, avatar_url, avatar_thumbnails, timezone, " +
+	"custom_attributes, version " +
+	"FROM public.user WHERE id = \\$1"
+
+var profileColumns = []string{"full_name", "phone_number", "email", "email_verified", "username", "language",
+	"date_of_birth", "gender", "address", "avatar_url", "avatar_thumbnails", "timezone", "custom_attributes",
+	"version"}
+
 func TestRepository(t *testing.T) {
 	gomega.RegisterFailHandler(ginkgo.Fail)
 	ginkgo.RunSpecs(t, "Repository Suite")
@@ -24,6 +48,11 @@ var _ = ginkgo.Describe("Repository", func() {
 		repo *Repository
 		mock sqlmock.Sqlmock
 		ctx  context.Context
+
+		// Cheap parameters keep the suite fast, production uses DefaultArgon2idParams.
+		hasher = utils.NewArgon2idHasher(utils.Argon2idParams{
+			Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+		})
 	)
 
 	ginkgo.BeforeEach(func() {
@@ -31,7 +60,7 @@ var _ = ginkgo.Describe("Repository", func() {
 		db, mock, err = sqlmock.New()
 		gomega.Expect(err).ToNot(gomega.HaveOccurred())
 
-		repo = &Repository{Db: db}
+		repo = &Repository{Db: db, Hasher: hasher}
 
 		ctx = context.Background()
 	})
@@ -85,15 +114,14 @@ var _ = ginkgo.Describe("Repository", func() {
 			PhoneNumber: "1234567890",
 			Password:    "securePassword",
 		}
-		salt := "randomSalt"
 		userID := "generatedUserID"
 
 		ginkgo.It("should register a new user", func() {
 			// Expect the first query for inserting user data and returning the user ID
 			rows := sqlmock.NewRows([]string{"id"}).AddRow(userID)
 			mock.ExpectBegin()
-			mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number\\) VALUES \\(\\$1, \\$2\\) RETURNING id$").
-				WithArgs(regRequest.FullName, regRequest.PhoneNumber).
+			mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+				WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
 				WillReturnRows(rows)
 
 			mock.ExpectExec("^INSERT INTO public.login \\(user_id, success_login\\) VALUES \\(\\$1, \\$2\\)").
@@ -101,34 +129,86 @@ var _ = ginkgo.Describe("Repository", func() {
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
 			// Expect the second query for inserting password data
-			mock.ExpectExec("^INSERT INTO public.password \\(user_id, password, salt\\) VALUES \\(\\$1, \\$2, \\$3\\)$").
-				WithArgs(userID, regRequest.Password, salt).
+			mock.ExpectExec("^INSERT INTO public.password \\(user_id, password\\) VALUES \\(\\$1, \\$2\\)$").
+				WithArgs(userID, regRequest.Password).
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
 			mock.ExpectCommit()
 
-			createdUserID, err := repo.Register(ctx, regRequest, salt)
+			createdUserID, err := repo.Register(ctx, regRequest)
 			gomega.Expect(err).ToNot(gomega.HaveOccurred())
 			gomega.Expect(createdUserID).To(gomega.Equal(userID))
 		})
 
+		ginkgo.It("should register an administrator in the same transaction", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("^INSERT INTO public.user").
+				WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+			mock.ExpectExec("^UPDATE public.user SET is_admin = true WHERE id = \\$1$").
+				WithArgs(userID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("^INSERT INTO public.login").
+				WithArgs(userID, 0).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("^INSERT INTO public.password").
+				WithArgs(userID, regRequest.Password).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			createdUserID, err := repo.RegisterAdmin(ctx, regRequest)
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(createdUserID).To(gomega.Equal(userID))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should not leave a user behind when granting the administrator role fails", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("^INSERT INTO public.user").
+				WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+			mock.ExpectExec("^UPDATE public.user SET is_admin").
+				WithArgs(userID).
+				WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
+
+			_, err := repo.RegisterAdmin(ctx, regRequest)
+			gomega.Expect(err).To(gomega.MatchError(sql.ErrConnDone))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
 		ginkgo.It("should handle transaction rollback on error", func() {
 			mock.ExpectBegin()
-			mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number\\) VALUES \\(\\$1, \\$2\\) RETURNING id$").
-				WithArgs(regRequest.FullName, regRequest.PhoneNumber).
+			mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+				WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
 				WillReturnError(sql.ErrConnDone)
 
 			mock.ExpectRollback()
 
-			_, err := repo.Register(ctx, regRequest, salt)
+			_, err := repo.Register(ctx, regRequest)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 		})
 
+		ginkgo.It("should report a phone number taken concurrently as a duplicate", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+				WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
+				WillReturnError(&pq.Error{Code: "23505", Constraint: "user_phone_number_key"})
+			mock.ExpectRollback()
+
+			_, err := repo.Register(ctx, regRequest)
+			var duplicate *DuplicateError
+			gomega.Expect(errors.As(err, &duplicate)).To(gomega.BeTrue())
+			gomega.Expect(duplicate.Column).To(gomega.Equal("phone_number"))
+			gomega.Expect(errors.Is(err, ErrDuplicate)).To(gomega.BeTrue())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
 		ginkgo.Context("when Begin returns an error", func() {
 			ginkgo.It("should return the error", func() {
 				mock.ExpectBegin().WillReturnError(errors.New("begin error"))
 
-				_, err := repo.Register(ctx, regRequest, salt)
+				_, err := repo.Register(ctx, regRequest)
 				gomega.Expect(err).To(gomega.HaveOccurred())
 				gomega.Expect(err.Error()).To(gomega.ContainSubstring("begin error"))
 			})
@@ -138,21 +218,21 @@ var _ = ginkgo.Describe("Repository", func() {
 			ginkgo.It("should return the error and rollback the transaction", func() {
 				mock.ExpectBegin()
 
-				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number\\) VALUES \\(\\$1, \\$2\\) RETURNING id$").
-					WithArgs(regRequest.FullName, regRequest.PhoneNumber).
+				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+					WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("generatedUserID"))
 
 				mock.ExpectExec("^INSERT INTO public.login \\(user_id, success_login\\) VALUES \\(\\$1, \\$2\\)").
 					WithArgs(userID, 0).
 					WillReturnError(errors.New("exec error"))
 
-				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password, salt\\) VALUES \\(\\$1, \\$2, \\$3\\)$").
-					WithArgs("generatedUserID", regRequest.Password, salt).
+				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password\\) VALUES \\(\\$1, \\$2\\)$").
+					WithArgs("generatedUserID", regRequest.Password).
 					WillReturnError(errors.New("exec error"))
 
 				mock.ExpectRollback()
 
-				_, err := repo.Register(ctx, regRequest, salt)
+				_, err := repo.Register(ctx, regRequest)
 				gomega.Expect(err).To(gomega.HaveOccurred())
 				gomega.Expect(err.Error()).To(gomega.ContainSubstring("exec error"))
 			})
@@ -160,21 +240,21 @@ var _ = ginkgo.Describe("Repository", func() {
 			ginkgo.It("should return the error and rollback the transaction", func() {
 				mock.ExpectBegin()
 
-				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number\\) VALUES \\(\\$1, \\$2\\) RETURNING id$").
-					WithArgs(regRequest.FullName, regRequest.PhoneNumber).
+				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+					WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("generatedUserID"))
 
 				mock.ExpectExec("^INSERT INTO public.login \\(user_id, success_login\\) VALUES \\(\\$1, \\$2\\)").
 					WithArgs(userID, 0).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
-				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password, salt\\) VALUES \\(\\$1, \\$2, \\$3\\)$").
-					WithArgs("generatedUserID", regRequest.Password, salt).
+				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password\\) VALUES \\(\\$1, \\$2\\)$").
+					WithArgs("generatedUserID", regRequest.Password).
 					WillReturnError(errors.New("exec error"))
 
 				mock.ExpectRollback()
 
-				_, err := repo.Register(ctx, regRequest, salt)
+				_, err := repo.Register(ctx, regRequest)
 				gomega.Expect(err).To(gomega.HaveOccurred())
 				gomega.Expect(err.Error()).To(gomega.ContainSubstring("exec error"))
 			})
@@ -184,23 +264,23 @@ var _ = ginkgo.Describe("Repository", func() {
 			ginkgo.It("should return the error and rollback the transaction", func() {
 				mock.ExpectBegin()
 
-				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number\\) VALUES \\(\\$1, \\$2\\) RETURNING id$").
-					WithArgs(regRequest.FullName, regRequest.PhoneNumber).
+				mock.ExpectQuery("^INSERT INTO public.user \\(full_name, phone_number, email\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id$").
+					WithArgs(regRequest.FullName, regRequest.PhoneNumber, nil).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("generatedUserID"))
 
 				mock.ExpectExec("^INSERT INTO public.login \\(user_id, success_login\\) VALUES \\(\\$1, \\$2\\)").
 					WithArgs(userID, 0).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
-				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password, salt\\) VALUES \\(\\$1, \\$2, \\$3\\)$").
-					WithArgs("generatedUserID", regRequest.Password, salt).
+				mock.ExpectExec("^INSERT INTO public.password \\(user_id, password\\) VALUES \\(\\$1, \\$2\\)$").
+					WithArgs("generatedUserID", regRequest.Password).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
 				mock.ExpectCommit().WillReturnError(errors.New("commit error"))
 
 				mock.ExpectRollback()
 
-				_, err := repo.Register(ctx, regRequest, salt)
+				_, err := repo.Register(ctx, regRequest)
 				gomega.Expect(err).To(gomega.HaveOccurred())
 				gomega.Expect(err.Error()).To(gomega.ContainSubstring("commit error"))
 			})
@@ -208,19 +288,18 @@ var _ = ginkgo.Describe("Repository", func() {
 	})
 
 	ginkgo.Describe("Loging", func() {
-		utils := utils.NewUtils()
 		ginkgo.It("should return the user ID on successful login", func() {
 			password := "password"
-			salt := utils.GenerateRandomSalt()
-			hashedPassword, _ := utils.HashingPassword(password, salt)
+			salt := ""
+			hashedPassword, _ := hasher.Hash(password)
 			mock.ExpectBegin()
 			// Expect query for finding the user.
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("expected_user_id"))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
 
 			// Expect query for finding the password and salt.
-			mock.ExpectQuery("SELECT password, salt FROM public.password").
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
 				WithArgs("expected_user_id").
 				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, salt))
 
@@ -232,7 +311,7 @@ var _ = ginkgo.Describe("Repository", func() {
 			mock.ExpectCommit()
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    password,
 			})
@@ -245,18 +324,76 @@ var _ = ginkgo.Describe("Repository", func() {
 			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
 		})
 
+		ginkgo.It("should only find users by a verified email", func() {
+			hashedPassword, _ := hasher.Hash("password")
+			mock.ExpectBegin()
+			mock.ExpectQuery("^SELECT id, locked_at IS NOT NULL FROM public.user WHERE email = \\$1 AND email_verified_at IS NOT NULL$").
+				WithArgs("john@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
+				WithArgs("expected_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, ""))
+			mock.ExpectExec("UPDATE public.login").
+				WithArgs("expected_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			userID, err := repo.Login(context.Background(), LoginCredentials{
+				Email:    "john@example.com",
+				Password: "password",
+			})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(userID).To(gomega.Equal("expected_user_id"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should rehash a legacy bcrypt password on successful login", func() {
+			password := "password"
+			salt := "legacySalt"
+			legacyHash, _ := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.MinCost)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
+				WithArgs("some_phone_number").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
+
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
+				WithArgs("expected_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(string(legacyHash), salt))
+
+			// Expect the legacy hash to be replaced by an Argon2id one.
+			mock.ExpectExec("UPDATE public.password SET password = \\$1, salt = NULL WHERE user_id = \\$2").
+				WithArgs(argon2idHashArg{}, "expected_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectExec("UPDATE public.login").
+				WithArgs("expected_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			mock.ExpectCommit()
+
+			userID, err := repo.Login(context.Background(), LoginCredentials{
+				PhoneNumber: "some_phone_number",
+				Password:    password,
+			})
+
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(userID).To(gomega.Equal("expected_user_id"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
 		ginkgo.It("should return error on failed to commit", func() {
 			password := "password"
-			salt := utils.GenerateRandomSalt()
-			hashedPassword, _ := utils.HashingPassword(password, salt)
+			salt := ""
+			hashedPassword, _ := hasher.Hash(password)
 			mock.ExpectBegin()
 			// Expect query for finding the user.
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("expected_user_id"))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
 
 			// Expect query for finding the password and salt.
-			mock.ExpectQuery("SELECT password, salt FROM public.password").
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
 				WithArgs("expected_user_id").
 				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, salt))
 
@@ -268,7 +405,7 @@ var _ = ginkgo.Describe("Repository", func() {
 			mock.ExpectCommit().WillReturnError(errors.New("failed to commit"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    password,
 			})
@@ -285,12 +422,12 @@ var _ = ginkgo.Describe("Repository", func() {
 			// Expect query for finding the user, but no rows returned (user not found).
 			mock.ExpectBegin()
 
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("non_existent_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(""))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("", false))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "non_existent_phone_number",
 				Password:    "some_password",
 			})
@@ -308,7 +445,7 @@ var _ = ginkgo.Describe("Repository", func() {
 			mock.ExpectBegin().WillReturnError(errors.New("Begin failed"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    "some_password",
 			})
@@ -325,12 +462,12 @@ var _ = ginkgo.Describe("Repository", func() {
 		ginkgo.It("should return an error on finding user ID failure", func() {
 			mock.ExpectBegin()
 
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
 				WillReturnError(errors.New("Finding user ID failed"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    "some_password",
 			})
@@ -347,16 +484,16 @@ var _ = ginkgo.Describe("Repository", func() {
 		ginkgo.It("should return an error on finding password and salt failure", func() {
 			mock.ExpectBegin()
 
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("expected_user_id"))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
 
-			mock.ExpectQuery("SELECT password, salt FROM public.password").
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
 				WithArgs("expected_user_id").
 				WillReturnError(errors.New("Finding password and salt failed"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    "some_password",
 			})
@@ -373,16 +510,16 @@ var _ = ginkgo.Describe("Repository", func() {
 		ginkgo.It("should return an error on wrong passoword", func() {
 			mock.ExpectBegin()
 
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("expected_user_id"))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
 
-			mock.ExpectQuery("SELECT password, salt FROM public.password").
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
 				WithArgs("expected_user_id").
 				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow("hashed_password", "some_salt"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    "some_password",
 			})
@@ -398,15 +535,15 @@ var _ = ginkgo.Describe("Repository", func() {
 
 		ginkgo.It("should return an error on update query failure", func() {
 			password := "password"
-			salt := utils.GenerateRandomSalt()
-			hashedPassword, _ := utils.HashingPassword(password, salt)
+			salt := ""
+			hashedPassword, _ := hasher.Hash(password)
 			mock.ExpectBegin()
 
-			mock.ExpectQuery("SELECT id FROM public.user").
+			mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
 				WithArgs("some_phone_number").
-				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("expected_user_id"))
+				WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", false))
 
-			mock.ExpectQuery("SELECT password, salt FROM public.password").
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
 				WithArgs("expected_user_id").
 				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, salt))
 
@@ -415,7 +552,7 @@ var _ = ginkgo.Describe("Repository", func() {
 				WillReturnError(errors.New("Update query failed"))
 
 			// Perform the Login function.
-			userID, err := repo.Login(context.Background(), generated.LoginRequest{
+			userID, err := repo.Login(context.Background(), LoginCredentials{
 				PhoneNumber: "some_phone_number",
 				Password:    password,
 			})
@@ -430,94 +567,817 @@ var _ = ginkgo.Describe("Repository", func() {
 		})
 	})
 
+	ginkgo.Context("Login of a locked user", func() {
+		ginkgo.It("should only tell a user with the right password that they are locked", func() {
+			hashedPassword, _ := hasher.Hash("password")
+			for i := 0; i < 2; i++ {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, locked_at IS NOT NULL FROM public.user").
+					WithArgs("some_phone_number").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "locked"}).AddRow("expected_user_id", true))
+				mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password").
+					WithArgs("expected_user_id").
+					WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, ""))
+				mock.ExpectRollback()
+			}
+
+			_, err := repo.Login(ctx, LoginCredentials{PhoneNumber: "some_phone_number", Password: "password"})
+			gomega.Expect(err).To(gomega.Equal(ErrUserLocked))
+			_, err = repo.Login(ctx, LoginCredentials{PhoneNumber: "some_phone_number", Password: "wrong"})
+			gomega.Expect(err).To(gomega.Equal(ErrWrongPassword))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("CheckPassword", func() {
+		ginkgo.It("should verify the password against the stored hash", func() {
+			hashedPassword, _ := hasher.Hash("password")
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password WHERE user_id = \\$1").
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(hashedPassword, ""))
+
+			ok, err := repo.CheckPassword(ctx, "some_user_id", "password")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(ok).To(gomega.BeTrue())
+		})
+	})
+
+	ginkgo.Context("IsPasswordReused", func() {
+		ginkgo.It("should compare against the current and previous passwords with their own algorithm", func() {
+			current, _ := hasher.Hash("current")
+			legacy, _ := bcrypt.GenerateFromPassword([]byte("legacy"+"salt"), bcrypt.MinCost)
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password WHERE user_id = \\$1\nUNION ALL").
+				WithArgs("some_user_id", 2).
+				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).
+					AddRow(current, "").
+					AddRow(string(legacy), "salt"))
+
+			reused, err := repo.IsPasswordReused(ctx, "some_user_id", "legacy", 3)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reused).To(gomega.BeTrue())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should accept a password that is not in the history", func() {
+			current, _ := hasher.Hash("current")
+			mock.ExpectQuery("SELECT password, COALESCE\\(salt, ''\\) FROM public.password WHERE user_id = \\$1\nUNION ALL").
+				WithArgs("some_user_id", 0).
+				WillReturnRows(sqlmock.NewRows([]string{"password", "salt"}).AddRow(current, ""))
+
+			reused, err := repo.IsPasswordReused(ctx, "some_user_id", "new", 1)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reused).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should not query when the history is disabled", func() {
+			reused, err := repo.IsPasswordReused(ctx, "some_user_id", "current", 0)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reused).To(gomega.BeFalse())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("UpdatePassword", func() {
+		ginkgo.It("should move the current password to the history and restart its age", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO public.password_history \\(user_id, password, salt\\)\nSELECT user_id, password, salt FROM public.password WHERE user_id = \\$1").
+				WithArgs("some_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE public.password SET password = \\$1, salt = NULL, changed_at = now\\(\\) WHERE user_id = \\$2").
+				WithArgs("new_hash", "some_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("DELETE FROM public.password_history WHERE user_id = \\$1 AND id NOT IN\n"+
+				"\\(SELECT id FROM public.password_history WHERE user_id = \\$1 ORDER BY id DESC LIMIT \\$2\\)").
+				WithArgs("some_user_id", 4).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			err := repo.UpdatePassword(ctx, "some_user_id", "new_hash", 5)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should roll back when the update fails", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO public.password_history").
+				WithArgs("some_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE public.password").
+				WithArgs("new_hash", "some_user_id").
+				WillReturnError(errors.New("update failed"))
+			mock.ExpectRollback()
+
+			err := repo.UpdatePassword(ctx, "some_user_id", "new_hash", 5)
+			gomega.Expect(err).To(gomega.MatchError("update failed"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should delete the whole history when it is disabled", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO public.password_history").
+				WithArgs("some_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("UPDATE public.password").
+				WithArgs("new_hash", "some_user_id").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("DELETE FROM public.password_history").
+				WithArgs("some_user_id", 0).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			gomega.Expect(repo.UpdatePassword(ctx, "some_user_id", "new_hash", 0)).To(gomega.Succeed())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("GetPasswordChangedAt", func() {
+		ginkgo.It("should return when the password was set", func() {
+			changedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			mock.ExpectQuery("SELECT changed_at FROM public.password WHERE user_id = \\$1").
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"changed_at"}).AddRow(changedAt))
+
+			got, err := repo.GetPasswordChangedAt(ctx, "some_user_id")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(got).To(gomega.Equal(changedAt))
+		})
+
+		ginkgo.It("should fail for unknown users", func() {
+			mock.ExpectQuery("SELECT changed_at FROM public.password").
+				WithArgs("unknown").
+				WillReturnRows(sqlmock.NewRows([]string{"changed_at"}))
+
+			_, err := repo.GetPasswordChangedAt(ctx, "unknown")
+			gomega.Expect(err).To(gomega.MatchError(ErrUserNotFound))
+		})
+	})
+
 	ginkgo.Context("GetUserProfile", func() {
 		userID := "some_user_id"
 		fullName := "some_full_name"
 		phoneNumber := "123456789"
+		emailVerified := false
+		version := 1
 
 		ginkgo.It("get user profile success", func() {
-			mock.ExpectQuery("SELECT full_name, phone_number FROM public.user WHERE id = \\$1").
+			mock.ExpectQuery(profileQuery).
 				WithArgs(userID).
-				WillReturnRows(sqlmock.NewRows([]string{"full_name", "phone_number"}).
-					AddRow(fullName, phoneNumber))
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(fullName, phoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1))
 
 			profile, err := repo.GetUserProfile(context.Background(), userID)
 			gomega.Expect(err).To(gomega.BeNil())
 			gomega.Expect(profile).To(gomega.Equal(
-				generated.UserProfile{FullName: &fullName, PhoneNumber: &phoneNumber}))
+				generated.UserProfile{FullName: &fullName, PhoneNumber: &phoneNumber, EmailVerified: &emailVerified,
+					CustomAttributes: &map[string]interface{}{}, Version: &version}))
 		})
 
 		ginkgo.It("get user profile error query", func() {
-			mock.ExpectQuery("SELECT full_name, phone_number FROM public.user WHERE id = \\$1").
+			mock.ExpectQuery(profileQuery).
 				WithArgs(userID).WillReturnError(errors.New("error"))
 
 			profile, err := repo.GetUserProfile(context.Background(), userID)
 			gomega.Expect(err).NotTo(gomega.BeNil())
 			gomega.Expect(profile).To(gomega.Equal(generated.UserProfile{}))
 		})
+
+		ginkgo.It("should return ErrUserNotFound for unknown users", func() {
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).WillReturnError(sql.ErrNoRows)
+
+			_, err := repo.GetUserProfile(context.Background(), userID)
+			gomega.Expect(err).To(gomega.Equal(ErrUserNotFound))
+		})
 	})
 
 	ginkgo.Context("UpdateUserProfile", func() {
 		userID := "some_user_id"
 		fullName := "some user"
 		phoneNumber := "123456789"
+		emailVerified := false
+		version := 1
 		userProfile := generated.UserProfile{
-			FullName:    &fullName,
-			PhoneNumber: &phoneNumber,
+			FullName:         &fullName,
+			PhoneNumber:      &phoneNumber,
+			EmailVerified:    &emailVerified,
+			CustomAttributes: &map[string]interface{}{},
+			Version:          &version,
 		}
 		ginkgo.It("should return the user profile when the update request is empty", func() {
 			// Set up mock database query expectations for GetUserProfile
-			rows := sqlmock.NewRows([]string{"full_name", "phone_number"}).
-				AddRow(userProfile.FullName, userProfile.PhoneNumber)
-			mock.ExpectQuery("SELECT full_name, phone_number FROM public.user WHERE id = \\$1").
+			rows := sqlmock.NewRows(profileColumns).
+				AddRow(userProfile.FullName, userProfile.PhoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1)
+			mock.ExpectQuery(profileQuery).
 				WithArgs(userID).
 				WillReturnRows(rows)
 
 			// Call the function with an empty update request
-			result, err := repo.UpdateUserProfile(ctx, map[string]string{}, userID)
+			result, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{}, userID)
 			gomega.Expect(err).To(gomega.BeNil())
 			gomega.Expect(result).To(gomega.Equal(userProfile))
 		})
 
 		ginkgo.It("should update the user profile and return the updated profile", func() {
+			newFullName := "New Name"
+			newPhoneNumber := "9876543210"
+
 			// Set up mock database query expectations for UPDATE statement
-			mock.ExpectExec("UPDATE public.user SET full_name = 'New Name', phone_number = '9876543210' WHERE id = \\$1").
-				WithArgs(userID).
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET full_name = \\$1, phone_number = \\$2, version = version \\+ 1 WHERE id = \\$3$").
+				WithArgs(newFullName, newPhoneNumber, userID).
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
 			// Set up mock database query expectations for GetUserProfile
-			rows := sqlmock.NewRows([]string{"full_name", "phone_number"}).
-				AddRow("New Name", "9876543210")
-			mock.ExpectQuery("SELECT full_name, phone_number FROM public.user WHERE id = \\$1").
+			rows := sqlmock.NewRows(profileColumns).
+				AddRow(newFullName, newPhoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1)
+			mock.ExpectQuery(profileQuery).
 				WithArgs(userID).
 				WillReturnRows(rows)
+			mock.ExpectCommit()
 
 			// Call the function with an update request
-			updateReq := map[string]string{
-				"full_name":    "New Name",
-				"phone_number": "9876543210",
-			}
+			updateReq := UserProfileUpdate{FullName: &newFullName, PhoneNumber: &newPhoneNumber}
 
 			result, err := repo.UpdateUserProfile(ctx, updateReq, userID)
 			gomega.Expect(err).To(gomega.BeNil())
-			gomega.Expect(*result.FullName).To(gomega.Equal(updateReq["full_name"]))
-			gomega.Expect(*result.PhoneNumber).To(gomega.Equal(updateReq["phone_number"]))
+			gomega.Expect(*result.FullName).To(gomega.Equal(newFullName))
+			gomega.Expect(*result.PhoneNumber).To(gomega.Equal(newPhoneNumber))
+		})
+
+		ginkgo.It("should bind hostile values as parameters", func() {
+			hostileName := "x', phone_number = '+620000000000' --"
+
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET full_name = \\$1, version = version \\+ 1 WHERE id = \\$2$").
+				WithArgs(hostileName, userID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(hostileName, phoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1))
+			mock.ExpectCommit()
+
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{FullName: &hostileName}, userID)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should store the standard fields and the custom attributes as JSON", func() {
+			dateOfBirth, timezone := "1990-12-31", "Asia/Jakarta"
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET date_of_birth = \\$1, timezone = \\$2, custom_attributes = \\$3, version = version \\+ 1 WHERE id = \\$4$").
+				WithArgs(dateOfBirth, timezone, `{"employee_id":"E123456"}`, userID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(fullName, phoneNumber, nil, false, nil, nil, dateOfBirth, nil, nil, nil, nil, timezone,
+						`{"employee_id":"E123456"}`, 1))
+			mock.ExpectCommit()
+
+			profile, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{
+				DateOfBirth:      &dateOfBirth,
+				Timezone:         &timezone,
+				CustomAttributes: map[string]interface{}{"employee_id": "E123456"},
+			}, userID)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(*profile.DateOfBirth).To(gomega.Equal(dateOfBirth))
+			gomega.Expect(*profile.CustomAttributes).To(gomega.Equal(map[string]interface{}{"employee_id": "E123456"}))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should set cleared fields to NULL along with the fields depending on them", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET email = \\$1, email_verified_at = \\$2, avatar_url = \\$3, avatar_thumbnails = \\$4, version = version \\+ 1 WHERE id = \\$5$").
+				WithArgs(nil, nil, nil, nil, userID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(fullName, phoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1))
+			mock.ExpectCommit()
+
+			profile, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{Clear: []string{"email", "avatar_url"}}, userID)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(profile.Email).To(gomega.BeNil())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should only update profiles with one of the versions", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET full_name = \\$1, version = version \\+ 1 WHERE id = \\$2 AND version IN \\(\\$3, \\$4\\)$").
+				WithArgs(fullName, userID, 3, 4).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(fullName, phoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 5))
+			mock.ExpectRollback()
+
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{FullName: &fullName, Versions: []int{3, 4}}, userID)
+			gomega.Expect(err).To(gomega.Equal(ErrVersionMismatch))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should check the version of empty updates", func() {
+			mock.ExpectQuery(profileQuery).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow(fullName, phoneNumber, nil, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 5))
+
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{Versions: []int{4}}, userID)
+			gomega.Expect(err).To(gomega.Equal(ErrVersionMismatch))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should not clear required fields", func() {
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{Clear: []string{"phone_number"}}, userID)
+			gomega.Expect(err).To(gomega.MatchError("column phone_number can't be cleared"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
 		})
 
 		ginkgo.It("should return an error when the UPDATE statement fails", func() {
+			newFullName := "New Name"
+			newPhoneNumber := "9876543210"
+
 			// Set up mock database query expectations for UPDATE statement error
-			mock.ExpectExec("UPDATE public.user SET full_name = 'New Name', phone_number = '9876543210' WHERE id = \\$1").
-				WithArgs(userID).
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET full_name = \\$1, phone_number = \\$2, version = version \\+ 1 WHERE id = \\$3$").
+				WithArgs(newFullName, newPhoneNumber, userID).
 				WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
 
 			// Call the function with an update request
-			updateReq := map[string]string{
-				"full_name":    "New Name",
-				"phone_number": "9876543210",
-			}
+			updateReq := UserProfileUpdate{FullName: &newFullName, PhoneNumber: &newPhoneNumber}
 			result, err := repo.UpdateUserProfile(ctx, updateReq, userID)
 			gomega.Expect(err).To(gomega.Not(gomega.BeNil()))
 			gomega.Expect(result).To(gomega.Equal(generated.UserProfile{}))
 		})
 	})
+
+	ginkgo.Context("IsEmailExists", func() {
+		ginkgo.It("should only count verified addresses", func() {
+			mock.ExpectQuery("^SELECT count\\(email\\) FROM public.user WHERE email = \\$1 AND email_verified_at IS NOT NULL$").
+				WithArgs("john@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+			exists, err := repo.IsEmailExists(ctx, "john@example.com")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(exists).To(gomega.BeFalse())
+		})
+	})
+
+	ginkgo.Context("VerifyEmail", func() {
+		ginkgo.It("should report an address another user verified first", func() {
+			mock.ExpectExec("UPDATE public.user SET email_verified_at").
+				WithArgs("some_user_id", "john@example.com").
+				WillReturnError(&pq.Error{Code: "23505", Constraint: "user_verified_email_key"})
+
+			ok, err := repo.VerifyEmail(ctx, "some_user_id", "john@example.com")
+			gomega.Expect(ok).To(gomega.BeFalse())
+			var duplicate *DuplicateError
+			gomega.Expect(errors.As(err, &duplicate)).To(gomega.BeTrue())
+			gomega.Expect(duplicate.Column).To(gomega.Equal("email"))
+		})
+
+		ginkgo.It("should only verify the current email of the user", func() {
+			mock.ExpectExec("^UPDATE public.user SET email_verified_at = COALESCE\\(email_verified_at, now\\(\\)\\), version = version \\+ 1 WHERE id = \\$1 AND email = \\$2$").
+				WithArgs("some_user_id", "old@example.com").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			ok, err := repo.VerifyEmail(ctx, "some_user_id", "old@example.com")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(ok).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should reset the verification when the email changes", func() {
+			email := "new@example.com"
+			mock.ExpectBegin()
+			mock.ExpectExec("^UPDATE public.user SET email = \\$1, email_verified_at = \\$2, version = version \\+ 1 WHERE id = \\$3$").
+				WithArgs(email, nil, "some_user_id").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(profileQuery).
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow("John Doe", "+6281234567890", email, false, nil, nil, nil, nil, nil, nil, nil, nil, "{}", 1))
+			mock.ExpectCommit()
+
+			profile, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{Email: &email}, "some_user_id")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(*profile.EmailVerified).To(gomega.BeFalse())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("Username", func() {
+		ginkgo.It("should look up usernames by their skeleton", func() {
+			mock.ExpectQuery("^SELECT count\\(username\\) FROM public.user WHERE username_skeleton = \\$1$").
+				WithArgs("jolm").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+			isExists, err := repo.IsUsernameExists(ctx, "j0irn")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(isExists).To(gomega.BeTrue())
+		})
+
+		ginkgo.It("should not change the username again before the interval passed", func() {
+			username := "john"
+			changedAt := time.Now().Add(-time.Hour)
+			mock.ExpectBegin()
+			mock.ExpectQuery("^SELECT username_changed_at FROM public.user WHERE id = \\$1 FOR UPDATE$").
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"username_changed_at"}).AddRow(changedAt))
+			mock.ExpectRollback()
+
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{
+				Username:               &username,
+				UsernameChangeInterval: 24 * time.Hour,
+			}, "some_user_id")
+
+			var tooSoon *UsernameChangeTooSoonError
+			gomega.Expect(errors.As(err, &tooSoon)).To(gomega.BeTrue())
+			gomega.Expect(tooSoon.NextChangeAt).To(gomega.BeTemporally("~", changedAt.Add(24*time.Hour)))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should report a username confusable with one taken concurrently as a duplicate", func() {
+			username := "john"
+			mock.ExpectBegin()
+			mock.ExpectQuery("^SELECT username_changed_at FROM public.user WHERE id = \\$1 FOR UPDATE$").
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"username_changed_at"}).AddRow(nil))
+			mock.ExpectExec("^UPDATE public.user SET username = ").
+				WillReturnError(&pq.Error{Code: "23505", Constraint: "user_username_skeleton_key"})
+			mock.ExpectRollback()
+
+			_, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{Username: &username}, "some_user_id")
+			var duplicate *DuplicateError
+			gomega.Expect(errors.As(err, &duplicate)).To(gomega.BeTrue())
+			gomega.Expect(duplicate.Column).To(gomega.Equal("username"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should store the username with its skeleton once the interval passed", func() {
+			username := "john"
+			mock.ExpectBegin()
+			mock.ExpectQuery("^SELECT username_changed_at FROM public.user WHERE id = \\$1 FOR UPDATE$").
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows([]string{"username_changed_at"}).AddRow(time.Now().Add(-25 * time.Hour)))
+			mock.ExpectExec("^UPDATE public.user SET username = \\$1, username_skeleton = \\$2, username_changed_at = \\$3, version = version \\+ 1 WHERE id = \\$4$").
+				WithArgs(username, "john", sqlmock.AnyArg(), "some_user_id").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(profileQuery).
+				WithArgs("some_user_id").
+				WillReturnRows(sqlmock.NewRows(profileColumns).
+					AddRow("John Doe", "+6281234567890", nil, false, username, nil, nil, nil, nil, nil, nil, nil, "{}", 1))
+			mock.ExpectCommit()
+
+			profile, err := repo.UpdateUserProfile(ctx, UserProfileUpdate{
+				Username:               &username,
+				UsernameChangeInterval: 24 * time.Hour,
+			}, "some_user_id")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(*profile.Username).To(gomega.Equal(username))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("GetUserIDByPhoneNumber", func() {
+		ginkgo.It("should return the ID of the user", func() {
+			mock.ExpectQuery("^SELECT id FROM public.user WHERE phone_number = \\$1$").
+				WithArgs("+6281234567890").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+
+			userID, err := repo.GetUserIDByPhoneNumber(ctx, "+6281234567890")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(userID).To(gomega.Equal("1"))
+		})
+
+		ginkgo.It("should return ErrUserNotFound for an unknown phone number", func() {
+			mock.ExpectQuery("SELECT id FROM public.user").WillReturnError(sql.ErrNoRows)
+
+			_, err := repo.GetUserIDByPhoneNumber(ctx, "+6281234567890")
+			gomega.Expect(err).To(gomega.Equal(ErrUserNotFound))
+		})
+	})
+
+	ginkgo.Describe("GetUserAccess", func() {
+		ginkgo.It("should return the role and the lock of the user", func() {
+			mock.ExpectQuery("^SELECT is_admin, locked_at IS NOT NULL FROM public.user WHERE id = \\$1$").
+				WithArgs("1").
+				WillReturnRows(sqlmock.NewRows([]string{"is_admin", "locked"}).AddRow(true, true))
+
+			access, err := repo.GetUserAccess(ctx, "1")
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(access).To(gomega.Equal(UserAccess{IsAdmin: true, Locked: true}))
+		})
+
+		ginkgo.It("should return ErrUserNotFound for an unknown user", func() {
+			mock.ExpectQuery("SELECT is_admin").WithArgs("1").WillReturnError(sql.ErrNoRows)
+
+			_, err := repo.GetUserAccess(ctx, "1")
+			gomega.Expect(err).To(gomega.Equal(ErrUserNotFound))
+		})
+	})
+
+	ginkgo.Describe("SetLocked", func() {
+		ginkgo.It("should keep the time a locked user was locked at", func() {
+			mock.ExpectExec("^UPDATE public.user SET locked_at = COALESCE\\(locked_at, now\\(\\)\\) WHERE id = \\$1$").
+				WithArgs("1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			gomega.Expect(repo.SetLocked(ctx, "1", true)).To(gomega.Succeed())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should return ErrUserNotFound for an unknown user", func() {
+			mock.ExpectExec("^UPDATE public.user SET locked_at = NULL WHERE id = \\$1$").
+				WithArgs("1").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			gomega.Expect(repo.SetLocked(ctx, "1", false)).To(gomega.Equal(ErrUserNotFound))
+		})
+	})
+
+	ginkgo.Describe("SetAdmin", func() {
+		ginkgo.It("should grant the administrator role", func() {
+			mock.ExpectExec("^UPDATE public.user SET is_admin = \\$1 WHERE id = \\$2$").
+				WithArgs(true, "1").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			gomega.Expect(repo.SetAdmin(ctx, "1", true)).To(gomega.Succeed())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("SetPhoneNumber", func() {
+		ginkgo.It("should report a number taken by another user", func() {
+			mock.ExpectExec("^UPDATE public.user SET phone_number = \\$1, version = version \\+ 1 WHERE id = \\$2$").
+				WithArgs("+6281234567890", "1").
+				WillReturnError(&pq.Error{Code: "23505", Constraint: "user_phone_number_key"})
+
+			err := repo.SetPhoneNumber(ctx, "1", "+6281234567890")
+			var duplicate *DuplicateError
+			gomega.Expect(errors.As(err, &duplicate)).To(gomega.BeTrue())
+			gomega.Expect(duplicate.Column).To(gomega.Equal("phone_number"))
+		})
+	})
+
+	ginkgo.Describe("ListPhoneNumbers", func() {
+		ginkgo.It("should call the function with every phone number", func() {
+			mock.ExpectQuery("^SELECT id, phone_number FROM public.user ORDER BY id$").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number"}).
+					AddRow("1", "+6281234567890").
+					AddRow("2", "0812 3456 7891"))
+
+			var listed [][2]string
+			err := repo.ListPhoneNumbers(ctx, func(userID, phoneNumber string) error {
+				listed = append(listed, [2]string{userID, phoneNumber})
+				return nil
+			})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(listed).To(gomega.Equal([][2]string{{"1", "+6281234567890"}, {"2", "0812 3456 7891"}}))
+		})
+	})
+
+	ginkgo.Describe("ExportUsers", func() {
+		ginkgo.It("should call the function with every user", func() {
+			lockedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			columns := append([]string{"id", "is_admin", "locked_at"}, profileColumns...)
+			mock.ExpectQuery("^SELECT id, is_admin, locked_at, full_name, .* FROM public.user ORDER BY id$").
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow("1", true, nil, "John Doe", "+6281234567890", nil, false, nil, nil, nil, nil, nil, nil,
+						nil, nil, `{"team":"a"}`, 1).
+					AddRow("2", false, lockedAt, "Jane Doe", "+6281234567891", nil, false, nil, nil, nil, nil, nil,
+						nil, nil, nil, "{}", 3))
+
+			var users []ExportedUser
+			err := repo.ExportUsers(ctx, func(user ExportedUser) error {
+				users = append(users, user)
+				return nil
+			})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(users).To(gomega.HaveLen(2))
+			gomega.Expect(users[0].ID).To(gomega.Equal("1"))
+			gomega.Expect(users[0].IsAdmin).To(gomega.BeTrue())
+			gomega.Expect(users[0].LockedAt).To(gomega.BeNil())
+			gomega.Expect(*users[0].Profile.CustomAttributes).To(gomega.Equal(map[string]interface{}{"team": "a"}))
+			gomega.Expect(*users[1].Profile.FullName).To(gomega.Equal("Jane Doe"))
+			gomega.Expect(*users[1].LockedAt).To(gomega.Equal(lockedAt))
+		})
+
+		ginkgo.It("should stop at the first error of the function", func() {
+			columns := append([]string{"id", "is_admin", "locked_at"}, profileColumns...)
+			mock.ExpectQuery("FROM public.user ORDER BY id").
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow("1", false, nil, "John Doe", nil, nil, false, nil, nil, nil, nil, nil, nil, nil, nil,
+						"{}", 1).
+					AddRow("2", false, nil, "Jane Doe", nil, nil, false, nil, nil, nil, nil, nil, nil, nil, nil,
+						"{}", 1))
+
+			calls := 0
+			err := repo.ExportUsers(ctx, func(user ExportedUser) error {
+				calls++
+				return errors.New("disk full")
+			})
+			gomega.Expect(err).To(gomega.MatchError("disk full"))
+			gomega.Expect(calls).To(gomega.Equal(1))
+		})
+	})
+
+	ginkgo.Describe("ReserveIdempotencyKey", func() {
+		const reserveQuery = "^INSERT INTO public.idempotency_key \\(scope, key, fingerprint, expires_at\\) " +
+			"VALUES \\(\\$1, \\$2, \\$3, \\$4\\) ON CONFLICT \\(scope, key\\) DO UPDATE .* " +
+			"WHERE idempotency_key.expires_at <= now\\(\\) RETURNING key$"
+		const selectQuery = "^SELECT fingerprint, expires_at, status, header, body FROM public.idempotency_key " +
+			"WHERE scope = \\$1 AND key = \\$2$"
+		selectColumns := []string{"fingerprint", "expires_at", "status", "header", "body"}
+		expiresAt := time.Now().Add(time.Minute)
+		request := IdempotentRequest{Scope: "some_user_id", Key: "key", Fingerprint: "abc", ExpiresAt: expiresAt}
+
+		ginkgo.It("should reserve a free key", func() {
+			mock.ExpectQuery(reserveQuery).
+				WithArgs("some_user_id", "key", "abc", expiresAt).
+				WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key"))
+
+			_, reserved, err := repo.ReserveIdempotencyKey(ctx, request)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reserved).To(gomega.BeTrue())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should return the completed request holding the key", func() {
+			mock.ExpectQuery(reserveQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(selectQuery).
+				WithArgs("some_user_id", "key").
+				WillReturnRows(sqlmock.NewRows(selectColumns).
+					AddRow("abc", expiresAt, 201, `{"Content-Type":["application/json"]}`, []byte(`{"user_id":"1"}`)))
+
+			existing, reserved, err := repo.ReserveIdempotencyKey(ctx, request)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reserved).To(gomega.BeFalse())
+			gomega.Expect(existing.Fingerprint).To(gomega.Equal("abc"))
+			gomega.Expect(existing.Response).To(gomega.Equal(&IdempotentResponse{
+				Status: 201,
+				Header: map[string][]string{"Content-Type": {"application/json"}},
+				Body:   []byte(`{"user_id":"1"}`),
+			}))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should return a request in progress without a response", func() {
+			mock.ExpectQuery(reserveQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(selectQuery).
+				WillReturnRows(sqlmock.NewRows(selectColumns).AddRow("abc", expiresAt, nil, nil, nil))
+
+			existing, reserved, err := repo.ReserveIdempotencyKey(ctx, request)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reserved).To(gomega.BeFalse())
+			gomega.Expect(existing.Response).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should reserve a key released meanwhile", func() {
+			mock.ExpectQuery(reserveQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(selectQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(reserveQuery).WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key"))
+
+			_, reserved, err := repo.ReserveIdempotencyKey(ctx, request)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(reserved).To(gomega.BeTrue())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("CompleteIdempotencyKey", func() {
+		ginkgo.It("should store the response until it expires", func() {
+			expiresAt := time.Now().Add(24 * time.Hour)
+			mock.ExpectExec("^UPDATE public.idempotency_key SET status = \\$1, header = \\$2, body = \\$3, "+
+				"expires_at = \\$4 WHERE scope = \\$5 AND key = \\$6$").
+				WithArgs(201, []byte(`{"Content-Type":["application/json"]}`), []byte("{}"), expiresAt, "", "key").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			err := repo.CompleteIdempotencyKey(ctx, "", "key", IdempotentResponse{
+				Status: 201,
+				Header: map[string][]string{"Content-Type": {"application/json"}},
+				Body:   []byte("{}"),
+			}, expiresAt)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("ReleaseIdempotencyKey", func() {
+		ginkgo.It("should only remove a key in progress", func() {
+			mock.ExpectExec("^DELETE FROM public.idempotency_key WHERE scope = \\$1 AND key = \\$2 AND status IS NULL$").
+				WithArgs("", "key").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			gomega.Expect(repo.ReleaseIdempotencyKey(ctx, "", "key")).To(gomega.Succeed())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("WithTx", func() {
+		serializationFailure := &pq.Error{Code: "40001"}
+
+		ginkgo.It("should commit when the function succeeds", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^DELETE FROM public.login$").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := repo.WithTx(ctx, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM public.login")
+				return err
+			})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should roll back and return the error of the function", func() {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			err := repo.WithTx(ctx, func(tx *sql.Tx) error { return ErrUserNotFound })
+			gomega.Expect(err).To(gomega.Equal(ErrUserNotFound))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should roll back when the function panics", func() {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			gomega.Expect(func() {
+				repo.WithTx(ctx, func(tx *sql.Tx) error { panic("boom") })
+			}).To(gomega.PanicWith("boom"))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should run the function again after a serialization failure", func() {
+			repo.TxRetries = 2
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+
+			attempts := 0
+			err := repo.WithTx(ctx, func(tx *sql.Tx) error {
+				attempts++
+				if attempts == 1 {
+					return serializationFailure
+				}
+				return nil
+			})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(attempts).To(gomega.Equal(2))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should give up after the retries", func() {
+			repo.TxRetries = 1
+			for i := 0; i < 2; i++ {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			}
+
+			attempts := 0
+			err := repo.WithTx(ctx, func(tx *sql.Tx) error {
+				attempts++
+				return serializationFailure
+			})
+			gomega.Expect(err).To(gomega.Equal(serializationFailure))
+			gomega.Expect(attempts).To(gomega.Equal(2))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should not retry other errors", func() {
+			repo.TxRetries = 3
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+
+			attempts := 0
+			err := repo.WithTx(ctx, func(tx *sql.Tx) error {
+				attempts++
+				return &pq.Error{Code: "23505"}
+			})
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(attempts).To(gomega.Equal(1))
+		})
+
+		ginkgo.It("should parse isolation levels", func() {
+			gomega.Expect(ParseIsolationLevel("")).To(gomega.Equal(sql.LevelDefault))
+			gomega.Expect(ParseIsolationLevel("Repeatable  Read")).To(gomega.Equal(sql.LevelRepeatableRead))
+			gomega.Expect(ParseIsolationLevel("serializable")).To(gomega.Equal(sql.LevelSerializable))
+			_, err := ParseIsolationLevel("snapshot")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+	})
+
+	ginkgo.Context("updateBuilder", func() {
+		ginkgo.It("should reject columns that are not allow-listed", func() {
+			builder := newUpdateBuilder("public.user", "full_name")
+			gomega.Expect(builder.Set("id = 1; DROP TABLE public.user; --", "x")).To(gomega.HaveOccurred())
+			gomega.Expect(builder.Set("full_name", "x")).To(gomega.Succeed())
+			gomega.Expect(builder.Set("full_name", "y")).To(gomega.HaveOccurred())
+		})
+	})
 })
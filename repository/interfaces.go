@@ -6,15 +6,40 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 )
 
 type RepositoryInterface interface {
 	IsPhoneNumberExists(ctx context.Context, phoneNumber string) (bool, error)
-	Register(ctx context.Context, regRequest generated.RegistrationRequest, salt string) (string, error)
-	Login(ctx context.Context, loginRequest generated.LoginRequest) (string, error)
+	Register(ctx context.Context, regRequest generated.RegistrationRequest) (string, error)
+	RegisterAdmin(ctx context.Context, regRequest generated.RegistrationRequest) (string, error)
+	IsEmailExists(ctx context.Context, email string) (bool, error)
+	IsUsernameExists(ctx context.Context, username string) (bool, error)
+	Login(ctx context.Context, credentials LoginCredentials) (string, error)
+	CheckPassword(ctx context.Context, userID, password string) (bool, error)
+	IsPasswordReused(ctx context.Context, userID, password string, historyDepth int) (bool, error)
+	UpdatePassword(ctx context.Context, userID, hashedPassword string, historyDepth int) error
+	GetPasswordChangedAt(ctx context.Context, userID string) (time.Time, error)
+	VerifyEmail(ctx context.Context, userID, email string) (bool, error)
+	GetUserIDByPhoneNumber(ctx context.Context, phoneNumber string) (string, error)
 	GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error)
 	UpdateUserProfile(ctx context.Context,
-		updateUserProfileRequest map[string]string, userID string) (generated.UserProfile, error)
+		update UserProfileUpdate, userID string) (generated.UserProfile, error)
+	ReserveIdempotencyKey(ctx context.Context, request IdempotentRequest) (IdempotentRequest, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, scope, key string,
+		response IdempotentResponse, expiresAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	IsAdmin(ctx context.Context, userID string) (bool, error)
+	GetUserAccess(ctx context.Context, userID string) (UserAccess, error)
+	SetAdmin(ctx context.Context, userID string, admin bool) error
+	SetLocked(ctx context.Context, userID string, locked bool) error
+	SetPhoneNumber(ctx context.Context, userID, phoneNumber string) error
+	ListPhoneNumbers(ctx context.Context, fn func(userID, phoneNumber string) error) error
+	ExportUsers(ctx context.Context, fn func(user ExportedUser) error) error
+	Ping(ctx context.Context) error
+	PoolStats() sql.DBStats
 }
@@ -6,7 +6,9 @@ package repository
 
 import (
 	context "context"
+	sql "database/sql"
 	reflect "reflect"
+	time "time"
 
 	generated "github.com/SawitProRecruitment/UserService/generated"
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +37,109 @@ func (m *MockRepositoryInterface) EXPECT() *MockRepositoryInterfaceMockRecorder
 	return m.recorder
 }
 
+// CheckPassword mocks base method.
+func (m *MockRepositoryInterface) CheckPassword(ctx context.Context, userID, password string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckPassword", ctx, userID, password)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckPassword indicates an expected call of CheckPassword.
+func (mr *MockRepositoryInterfaceMockRecorder) CheckPassword(ctx, userID, password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckPassword", reflect.TypeOf((*MockRepositoryInterface)(nil).CheckPassword), ctx, userID, password)
+}
+
+// CompleteIdempotencyKey mocks base method.
+func (m *MockRepositoryInterface) CompleteIdempotencyKey(ctx context.Context, scope, key string, response IdempotentResponse, expiresAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteIdempotencyKey", ctx, scope, key, response, expiresAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteIdempotencyKey indicates an expected call of CompleteIdempotencyKey.
+func (mr *MockRepositoryInterfaceMockRecorder) CompleteIdempotencyKey(ctx, scope, key, response, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteIdempotencyKey", reflect.TypeOf((*MockRepositoryInterface)(nil).CompleteIdempotencyKey), ctx, scope, key, response, expiresAt)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockRepositoryInterface) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockRepositoryInterfaceMockRecorder) DeleteExpiredIdempotencyKeys(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteExpiredIdempotencyKeys), ctx)
+}
+
+// ExportUsers mocks base method.
+func (m *MockRepositoryInterface) ExportUsers(ctx context.Context, fn func(ExportedUser) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportUsers", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportUsers indicates an expected call of ExportUsers.
+func (mr *MockRepositoryInterfaceMockRecorder) ExportUsers(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportUsers", reflect.TypeOf((*MockRepositoryInterface)(nil).ExportUsers), ctx, fn)
+}
+
+// GetPasswordChangedAt mocks base method.
+func (m *MockRepositoryInterface) GetPasswordChangedAt(ctx context.Context, userID string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasswordChangedAt", ctx, userID)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPasswordChangedAt indicates an expected call of GetPasswordChangedAt.
+func (mr *MockRepositoryInterfaceMockRecorder) GetPasswordChangedAt(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasswordChangedAt", reflect.TypeOf((*MockRepositoryInterface)(nil).GetPasswordChangedAt), ctx, userID)
+}
+
+// GetUserAccess mocks base method.
+func (m *MockRepositoryInterface) GetUserAccess(ctx context.Context, userID string) (UserAccess, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserAccess", ctx, userID)
+	ret0, _ := ret[0].(UserAccess)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserAccess indicates an expected call of GetUserAccess.
+func (mr *MockRepositoryInterfaceMockRecorder) GetUserAccess(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserAccess", reflect.TypeOf((*MockRepositoryInterface)(nil).GetUserAccess), ctx, userID)
+}
+
+// GetUserIDByPhoneNumber mocks base method.
+func (m *MockRepositoryInterface) GetUserIDByPhoneNumber(ctx context.Context, phoneNumber string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserIDByPhoneNumber", ctx, phoneNumber)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserIDByPhoneNumber indicates an expected call of GetUserIDByPhoneNumber.
+func (mr *MockRepositoryInterfaceMockRecorder) GetUserIDByPhoneNumber(ctx, phoneNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserIDByPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).GetUserIDByPhoneNumber), ctx, phoneNumber)
+}
+
 // GetUserProfile mocks base method.
 func (m *MockRepositoryInterface) GetUserProfile(ctx context.Context, userID string) (generated.UserProfile, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +155,51 @@ func (mr *MockRepositoryInterfaceMockRecorder) GetUserProfile(ctx, userID interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserProfile", reflect.TypeOf((*MockRepositoryInterface)(nil).GetUserProfile), ctx, userID)
 }
 
+// IsAdmin mocks base method.
+func (m *MockRepositoryInterface) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsAdmin", ctx, userID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsAdmin indicates an expected call of IsAdmin.
+func (mr *MockRepositoryInterfaceMockRecorder) IsAdmin(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsAdmin", reflect.TypeOf((*MockRepositoryInterface)(nil).IsAdmin), ctx, userID)
+}
+
+// IsEmailExists mocks base method.
+func (m *MockRepositoryInterface) IsEmailExists(ctx context.Context, email string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsEmailExists", ctx, email)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsEmailExists indicates an expected call of IsEmailExists.
+func (mr *MockRepositoryInterfaceMockRecorder) IsEmailExists(ctx, email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsEmailExists", reflect.TypeOf((*MockRepositoryInterface)(nil).IsEmailExists), ctx, email)
+}
+
+// IsPasswordReused mocks base method.
+func (m *MockRepositoryInterface) IsPasswordReused(ctx context.Context, userID, password string, historyDepth int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsPasswordReused", ctx, userID, password, historyDepth)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsPasswordReused indicates an expected call of IsPasswordReused.
+func (mr *MockRepositoryInterfaceMockRecorder) IsPasswordReused(ctx, userID, password, historyDepth interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPasswordReused", reflect.TypeOf((*MockRepositoryInterface)(nil).IsPasswordReused), ctx, userID, password, historyDepth)
+}
+
 // IsPhoneNumberExists mocks base method.
 func (m *MockRepositoryInterface) IsPhoneNumberExists(ctx context.Context, phoneNumber string) (bool, error) {
 	m.ctrl.T.Helper()
@@ -65,47 +215,220 @@ func (mr *MockRepositoryInterfaceMockRecorder) IsPhoneNumberExists(ctx, phoneNum
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPhoneNumberExists", reflect.TypeOf((*MockRepositoryInterface)(nil).IsPhoneNumberExists), ctx, phoneNumber)
 }
 
+// IsUsernameExists mocks base method.
+func (m *MockRepositoryInterface) IsUsernameExists(ctx context.Context, username string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsUsernameExists", ctx, username)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsUsernameExists indicates an expected call of IsUsernameExists.
+func (mr *MockRepositoryInterfaceMockRecorder) IsUsernameExists(ctx, username interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsUsernameExists", reflect.TypeOf((*MockRepositoryInterface)(nil).IsUsernameExists), ctx, username)
+}
+
+// ListPhoneNumbers mocks base method.
+func (m *MockRepositoryInterface) ListPhoneNumbers(ctx context.Context, fn func(string, string) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPhoneNumbers", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListPhoneNumbers indicates an expected call of ListPhoneNumbers.
+func (mr *MockRepositoryInterfaceMockRecorder) ListPhoneNumbers(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPhoneNumbers", reflect.TypeOf((*MockRepositoryInterface)(nil).ListPhoneNumbers), ctx, fn)
+}
+
 // Login mocks base method.
-func (m *MockRepositoryInterface) Login(ctx context.Context, loginRequest generated.LoginRequest) (string, error) {
+func (m *MockRepositoryInterface) Login(ctx context.Context, credentials LoginCredentials) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Login", ctx, loginRequest)
+	ret := m.ctrl.Call(m, "Login", ctx, credentials)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Login indicates an expected call of Login.
-func (mr *MockRepositoryInterfaceMockRecorder) Login(ctx, loginRequest interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) Login(ctx, credentials interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockRepositoryInterface)(nil).Login), ctx, credentials)
+}
+
+// Ping mocks base method.
+func (m *MockRepositoryInterface) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockRepositoryInterfaceMockRecorder) Ping(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockRepositoryInterface)(nil).Login), ctx, loginRequest)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockRepositoryInterface)(nil).Ping), ctx)
+}
+
+// PoolStats mocks base method.
+func (m *MockRepositoryInterface) PoolStats() sql.DBStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PoolStats")
+	ret0, _ := ret[0].(sql.DBStats)
+	return ret0
+}
+
+// PoolStats indicates an expected call of PoolStats.
+func (mr *MockRepositoryInterfaceMockRecorder) PoolStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PoolStats", reflect.TypeOf((*MockRepositoryInterface)(nil).PoolStats))
 }
 
 // Register mocks base method.
-func (m *MockRepositoryInterface) Register(ctx context.Context, regRequest generated.RegistrationRequest, salt string) (string, error) {
+func (m *MockRepositoryInterface) Register(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Register", ctx, regRequest, salt)
+	ret := m.ctrl.Call(m, "Register", ctx, regRequest)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Register indicates an expected call of Register.
-func (mr *MockRepositoryInterfaceMockRecorder) Register(ctx, regRequest, salt interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) Register(ctx, regRequest interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockRepositoryInterface)(nil).Register), ctx, regRequest)
+}
+
+// RegisterAdmin mocks base method.
+func (m *MockRepositoryInterface) RegisterAdmin(ctx context.Context, regRequest generated.RegistrationRequest) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterAdmin", ctx, regRequest)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterAdmin indicates an expected call of RegisterAdmin.
+func (mr *MockRepositoryInterfaceMockRecorder) RegisterAdmin(ctx, regRequest interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockRepositoryInterface)(nil).Register), ctx, regRequest, salt)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterAdmin", reflect.TypeOf((*MockRepositoryInterface)(nil).RegisterAdmin), ctx, regRequest)
+}
+
+// ReleaseIdempotencyKey mocks base method.
+func (m *MockRepositoryInterface) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseIdempotencyKey", ctx, scope, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseIdempotencyKey indicates an expected call of ReleaseIdempotencyKey.
+func (mr *MockRepositoryInterfaceMockRecorder) ReleaseIdempotencyKey(ctx, scope, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseIdempotencyKey", reflect.TypeOf((*MockRepositoryInterface)(nil).ReleaseIdempotencyKey), ctx, scope, key)
+}
+
+// ReserveIdempotencyKey mocks base method.
+func (m *MockRepositoryInterface) ReserveIdempotencyKey(ctx context.Context, request IdempotentRequest) (IdempotentRequest, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveIdempotencyKey", ctx, request)
+	ret0, _ := ret[0].(IdempotentRequest)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ReserveIdempotencyKey indicates an expected call of ReserveIdempotencyKey.
+func (mr *MockRepositoryInterfaceMockRecorder) ReserveIdempotencyKey(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveIdempotencyKey", reflect.TypeOf((*MockRepositoryInterface)(nil).ReserveIdempotencyKey), ctx, request)
+}
+
+// SetAdmin mocks base method.
+func (m *MockRepositoryInterface) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetAdmin", ctx, userID, admin)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetAdmin indicates an expected call of SetAdmin.
+func (mr *MockRepositoryInterfaceMockRecorder) SetAdmin(ctx, userID, admin interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAdmin", reflect.TypeOf((*MockRepositoryInterface)(nil).SetAdmin), ctx, userID, admin)
+}
+
+// SetLocked mocks base method.
+func (m *MockRepositoryInterface) SetLocked(ctx context.Context, userID string, locked bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLocked", ctx, userID, locked)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLocked indicates an expected call of SetLocked.
+func (mr *MockRepositoryInterfaceMockRecorder) SetLocked(ctx, userID, locked interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLocked", reflect.TypeOf((*MockRepositoryInterface)(nil).SetLocked), ctx, userID, locked)
+}
+
+// SetPhoneNumber mocks base method.
+func (m *MockRepositoryInterface) SetPhoneNumber(ctx context.Context, userID, phoneNumber string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPhoneNumber", ctx, userID, phoneNumber)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPhoneNumber indicates an expected call of SetPhoneNumber.
+func (mr *MockRepositoryInterfaceMockRecorder) SetPhoneNumber(ctx, userID, phoneNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).SetPhoneNumber), ctx, userID, phoneNumber)
+}
+
+// UpdatePassword mocks base method.
+func (m *MockRepositoryInterface) UpdatePassword(ctx context.Context, userID, hashedPassword string, historyDepth int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", ctx, userID, hashedPassword, historyDepth)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockRepositoryInterfaceMockRecorder) UpdatePassword(ctx, userID, hashedPassword, historyDepth interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdatePassword), ctx, userID, hashedPassword, historyDepth)
 }
 
 // UpdateUserProfile mocks base method.
-func (m *MockRepositoryInterface) UpdateUserProfile(ctx context.Context, updateUserProfileRequest map[string]string, userID string) (generated.UserProfile, error) {
+func (m *MockRepositoryInterface) UpdateUserProfile(ctx context.Context, update UserProfileUpdate, userID string) (generated.UserProfile, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateUserProfile", ctx, updateUserProfileRequest, userID)
+	ret := m.ctrl.Call(m, "UpdateUserProfile", ctx, update, userID)
 	ret0, _ := ret[0].(generated.UserProfile)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateUserProfile indicates an expected call of UpdateUserProfile.
-func (mr *MockRepositoryInterfaceMockRecorder) UpdateUserProfile(ctx, updateUserProfileRequest, userID interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) UpdateUserProfile(ctx, update, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserProfile", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateUserProfile), ctx, update, userID)
+}
+
+// VerifyEmail mocks base method.
+func (m *MockRepositoryInterface) VerifyEmail(ctx context.Context, userID, email string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyEmail", ctx, userID, email)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyEmail indicates an expected call of VerifyEmail.
+func (mr *MockRepositoryInterfaceMockRecorder) VerifyEmail(ctx, userID, email interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserProfile", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateUserProfile), ctx, updateUserProfileRequest, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyEmail", reflect.TypeOf((*MockRepositoryInterface)(nil).VerifyEmail), ctx, userID, email)
 }
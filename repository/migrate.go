@@ -0,0 +1,330 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationLockID is the key of the Postgres advisory lock held while
+// migrating, so replicas starting together migrate one after the other.
+const migrationLockID = 7_262_051_947
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+  version int PRIMARY KEY,
+  name varchar(255) NOT NULL,
+  applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// migrationFileRegex matches the names of migration files, e.g.
+// "0002_add_avatars.up.sql".
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a numbered change of the schema, applied by Up and reverted
+// by Down.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration along with when it was applied, AppliedAt
+// is nil while it is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	Db *sql.DB
+	// Migrations are sorted by version.
+	Migrations []Migration
+}
+
+type NewMigratorOptions struct {
+	Dsn string
+	// Migrations holds the migration files, it defaults to the ones embedded
+	// in the binary.
+	Migrations fs.FS
+}
+
+// NewMigrator returns a Migrator of the migration files, which are named
+// "VERSION_NAME.up.sql" and "VERSION_NAME.down.sql".
+func NewMigrator(opts NewMigratorOptions) (*Migrator, error) {
+	files := opts.Migrations
+	if files == nil {
+		sub, err := fs.Sub(embeddedMigrations, "migrations")
+		if err != nil {
+			return nil, err
+		}
+		files = sub
+	}
+	migrations, err := LoadMigrations(files)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", opts.Dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{Db: db, Migrations: migrations}, nil
+}
+
+// LoadMigrations reads the migration files at the root of files. Every
+// migration must have both an up and a down file.
+func LoadMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version,
+				migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest is the version of the last migration, 0 without any.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Status returns every migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			status := MigrationStatus{Migration: migration}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Up applies every pending migration and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the last steps applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var migrated []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		target := 0
+		if steps < len(versions) {
+			target = versions[steps]
+		}
+		migrated, err = m.migrate(ctx, conn, applied, target)
+		return err
+	})
+	return migrated, err
+}
+
+// To applies or reverts migrations until version is the last one applied,
+// and returns them in the order they ran. Version 0 reverts every migration.
+func (m *Migrator) To(ctx context.Context, version int) ([]Migration, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	var migrated []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		migrated, err = m.migrate(ctx, conn, applied, version)
+		return err
+	})
+	return migrated, err
+}
+
+// migrate reverts the applied migrations after target, newest first, then
+// applies the pending ones up to target, oldest first.
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, applied map[int]time.Time,
+	target int) ([]Migration, error) {
+	var migrated []Migration
+
+	var reverted []int
+	for version := range applied {
+		if version > target {
+			reverted = append(reverted, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(reverted)))
+	for _, version := range reverted {
+		migration := m.find(version)
+		if migration == nil {
+			return migrated, fmt.Errorf("applied migration %d is unknown, revert it with the binary that applied it",
+				version)
+		}
+		if err := runMigration(ctx, conn, *migration, false); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, *migration)
+	}
+
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > target {
+			continue
+		}
+		if err := runMigration(ctx, conn, migration, true); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, migration)
+	}
+	return migrated, nil
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.Migrations {
+		if m.Migrations[i].Version == version {
+			return &m.Migrations[i]
+		}
+	}
+	return nil
+}
+
+// withLock runs fn on a connection holding the migration lock, once the
+// schema_migrations table exists.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, they are taken and released on
+	// the same connection.
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// appliedMigrations returns when each applied migration was applied, by
+// version.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM public.schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration applies or reverts migration in a transaction, along with its
+// row of schema_migrations.
+func runMigration(ctx context.Context, conn *sql.Conn, migration Migration, up bool) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}()
+
+	if up {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)",
+			migration.Version, migration.Name); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM public.schema_migrations WHERE version = $1",
+			migration.Version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
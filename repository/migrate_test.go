@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"regexp"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Migrator", func() {
+	var (
+		db       *sql.DB
+		mock     sqlmock.Sqlmock
+		migrator *Migrator
+		ctx      context.Context
+	)
+
+	migrations := []Migration{
+		{Version: 1, Name: "initial", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "add_b", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+		{Version: 3, Name: "add_c", Up: "CREATE TABLE c ()", Down: "DROP TABLE c"},
+	}
+
+	expectLock := func(applied ...int) {
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).
+			WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.schema_migrations").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"version", "applied_at"})
+		for _, version := range applied {
+			rows.AddRow(version, time.Date(2024, 1, version, 0, 0, 0, 0, time.UTC))
+		}
+		mock.ExpectQuery("SELECT version, applied_at FROM public.schema_migrations").WillReturnRows(rows)
+	}
+
+	expectUnlock := func() {
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+			WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	expectUp := func(migration Migration) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migration.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO public.schema_migrations").
+			WithArgs(migration.Version, migration.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	expectDown := func(migration Migration) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migration.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM public.schema_migrations").
+			WithArgs(migration.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		db, mock, err = sqlmock.New()
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+
+		migrator = &Migrator{Db: db, Migrations: migrations}
+		ctx = context.Background()
+	})
+
+	ginkgo.Describe("LoadMigrations", func() {
+		ginkgo.It("should load the embedded migrations", func() {
+			files, err := fs.Sub(embeddedMigrations, "migrations")
+			gomega.Expect(err).To(gomega.BeNil())
+
+			loaded, err := LoadMigrations(files)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(loaded).ToNot(gomega.BeEmpty())
+			gomega.Expect(loaded[0].Version).To(gomega.Equal(1))
+			gomega.Expect(loaded[0].Name).To(gomega.Equal("initial"))
+			gomega.Expect(loaded[0].Up).To(gomega.ContainSubstring(`CREATE TABLE IF NOT EXISTS "user"`))
+			gomega.Expect(loaded[0].Down).To(gomega.ContainSubstring(`DROP TABLE IF EXISTS "user"`))
+		})
+
+		ginkgo.It("should sort the migrations by version", func() {
+			loaded, err := LoadMigrations(fstest.MapFS{
+				"10_c.up.sql":   {Data: []byte("c")},
+				"10_c.down.sql": {Data: []byte("c")},
+				"2_b.up.sql":    {Data: []byte("b")},
+				"2_b.down.sql":  {Data: []byte("b")},
+			})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(loaded).To(gomega.HaveLen(2))
+			gomega.Expect(loaded[0].Version).To(gomega.Equal(2))
+			gomega.Expect(loaded[1].Version).To(gomega.Equal(10))
+		})
+
+		ginkgo.It("should reject a migration without a down file", func() {
+			_, err := LoadMigrations(fstest.MapFS{"0001_a.up.sql": {Data: []byte("a")}})
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("needs both an up and a down file")))
+		})
+
+		ginkgo.It("should reject invalid file names", func() {
+			_, err := LoadMigrations(fstest.MapFS{"add_a.sql": {Data: []byte("a")}})
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("invalid migration file name")))
+		})
+	})
+
+	ginkgo.Describe("Up", func() {
+		ginkgo.It("should apply the pending migrations in order under the lock", func() {
+			expectLock(1)
+			expectUp(migrations[1])
+			expectUp(migrations[2])
+			expectUnlock()
+
+			migrated, err := migrator.Up(ctx)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(migrated).To(gomega.Equal(migrations[1:]))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should migrate a database created from database.sql", func() {
+			files, err := fs.Sub(embeddedMigrations, "migrations")
+			gomega.Expect(err).To(gomega.BeNil())
+			embedded, err := LoadMigrations(files)
+			gomega.Expect(err).To(gomega.BeNil())
+			migrator.Migrations = embedded
+
+			// The baseline has no schema_migrations, every migration runs,
+			// starting with the one creating the baseline tables that exist.
+			gomega.Expect(embedded[0].Up).To(gomega.ContainSubstring(`"full_name" varchar(60)`))
+			gomega.Expect(embedded[0].Up).ToNot(gomega.ContainSubstring("email"))
+			expectLock()
+			for _, migration := range embedded {
+				expectUp(migration)
+			}
+			expectUnlock()
+
+			migrated, err := migrator.Up(ctx)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(migrated).To(gomega.Equal(embedded))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+
+			// Every column of the profiles is added by a migration after the
+			// baseline.
+			added := map[string]bool{"full_name": true, "phone_number": true}
+			addColumn := regexp.MustCompile(`ADD COLUMN "(\w+)"`)
+			for _, migration := range embedded[1:] {
+				for _, match := range addColumn.FindAllStringSubmatch(migration.Up, -1) {
+					added[match[1]] = true
+				}
+			}
+			for _, column := range regexp.MustCompile(`\b[a-z_]+\b`).FindAllString(userProfileColumns, -1) {
+				if column != "to_char" {
+					gomega.Expect(added).To(gomega.HaveKey(column))
+				}
+			}
+			gomega.Expect(added).To(gomega.HaveKey("is_admin"))
+			gomega.Expect(added).To(gomega.HaveKey("locked_at"))
+		})
+
+		ginkgo.It("should stop at a failing migration and roll it back", func() {
+			expectLock()
+			expectUp(migrations[0])
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(migrations[1].Up)).WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
+			expectUnlock()
+
+			migrated, err := migrator.Up(ctx)
+			gomega.Expect(err).To(gomega.MatchError(sql.ErrConnDone))
+			gomega.Expect(err.Error()).To(gomega.ContainSubstring("migration 2_add_b"))
+			gomega.Expect(migrated).To(gomega.Equal(migrations[:1]))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("Down", func() {
+		ginkgo.It("should revert the last applied migrations, newest first", func() {
+			expectLock(1, 2, 3)
+			expectDown(migrations[2])
+			expectDown(migrations[1])
+			expectUnlock()
+
+			migrated, err := migrator.Down(ctx, 2)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(migrated).To(gomega.Equal([]Migration{migrations[2], migrations[1]}))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should refuse to revert a migration unknown to the binary", func() {
+			expectLock(1, 4)
+			expectUnlock()
+
+			_, err := migrator.Down(ctx, 1)
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("applied migration 4 is unknown")))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Describe("To", func() {
+		ginkgo.It("should apply migrations up to the version", func() {
+			expectLock()
+			expectUp(migrations[0])
+			expectUp(migrations[1])
+			expectUnlock()
+
+			migrated, err := migrator.To(ctx, 2)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(migrated).To(gomega.Equal(migrations[:2]))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should revert every migration at version 0", func() {
+			expectLock(1, 2)
+			expectDown(migrations[1])
+			expectDown(migrations[0])
+			expectUnlock()
+
+			migrated, err := migrator.To(ctx, 0)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(migrated).To(gomega.HaveLen(2))
+			gomega.Expect(mock.ExpectationsWereMet()).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should reject an unknown version", func() {
+			_, err := migrator.To(ctx, 7)
+			gomega.Expect(err).To(gomega.MatchError("unknown migration version 7"))
+		})
+	})
+
+	ginkgo.Describe("Status", func() {
+		ginkgo.It("should tell which migrations are applied", func() {
+			expectLock(1)
+			expectUnlock()
+
+			statuses, err := migrator.Status(ctx)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(statuses).To(gomega.HaveLen(3))
+			gomega.Expect(statuses[0].AppliedAt).ToNot(gomega.BeNil())
+			gomega.Expect(*statuses[0].AppliedAt).To(gomega.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+			gomega.Expect(statuses[1].AppliedAt).To(gomega.BeNil())
+			gomega.Expect(statuses[2].AppliedAt).To(gomega.BeNil())
+		})
+	})
+})
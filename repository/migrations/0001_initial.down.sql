@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "login";
+DROP TABLE IF EXISTS "password";
+DROP TABLE IF EXISTS "user";
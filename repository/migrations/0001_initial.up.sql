@@ -0,0 +1,22 @@
+-- The schema of database.sql, which databases were created from before
+-- migrations existed. Its tables are only created when they are missing, so
+-- such databases are migrated from here by the migrations that follow.
+
+CREATE TABLE IF NOT EXISTS "user" (
+  "id" INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "full_name" varchar(60),
+  "phone_number" varchar(16) UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS "password" (
+  "id" INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "user_id" int REFERENCES "user" ("id"),
+  "password" varchar(255),
+  "salt" varchar(16)
+);
+
+CREATE TABLE IF NOT EXISTS "login" (
+  "id" INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "user_id" int REFERENCES "user" ("id"),
+  "success_login" int
+);
@@ -0,0 +1,11 @@
+-- Previous password hashes of the users, newest first by id, so that they
+-- can't reuse a recent password.
+CREATE TABLE "password_history" (
+  "id" INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "user_id" int REFERENCES "user" ("id"),
+  "password" varchar(255),
+  "salt" varchar(16),
+  "created_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "password_history_user_id_id_idx" ON "password_history" ("user_id", "id" DESC);
@@ -0,0 +1,3 @@
+DROP INDEX "user_email_key";
+ALTER TABLE "user" DROP COLUMN "email_verified_at";
+ALTER TABLE "user" DROP COLUMN "email";
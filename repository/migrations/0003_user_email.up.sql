@@ -0,0 +1,6 @@
+ALTER TABLE "user" ADD COLUMN "email" varchar(254);
+ALTER TABLE "user" ADD COLUMN "email_verified_at" timestamptz;
+
+-- Emails are stored in lower case, the index also guards against rows
+-- written around the application.
+CREATE UNIQUE INDEX "user_email_key" ON "user" (lower("email"));
@@ -0,0 +1,3 @@
+ALTER TABLE "user" DROP COLUMN "username_changed_at";
+ALTER TABLE "user" DROP COLUMN "username_skeleton";
+ALTER TABLE "user" DROP COLUMN "username";
@@ -0,0 +1,4 @@
+ALTER TABLE "user" ADD COLUMN "username" varchar(30) UNIQUE;
+-- Usernames that look alike share a skeleton, only one of them can be taken.
+ALTER TABLE "user" ADD COLUMN "username_skeleton" varchar(30) UNIQUE;
+ALTER TABLE "user" ADD COLUMN "username_changed_at" timestamptz;
@@ -0,0 +1,2 @@
+-- BCP 47 tag of the preferred language of messages, e.g. "id-ID".
+ALTER TABLE "user" ADD COLUMN "language" varchar(35);
@@ -0,0 +1,2 @@
+-- Fails while a full name is longer than 60 code points.
+ALTER TABLE "user" ALTER COLUMN "full_name" TYPE varchar(60);
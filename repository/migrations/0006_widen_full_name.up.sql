@@ -0,0 +1,3 @@
+-- Full names are NFC normalized and limited to 60 characters as users perceive
+-- them, each of which can take several code points with combining marks.
+ALTER TABLE "user" ALTER COLUMN "full_name" TYPE varchar(240);
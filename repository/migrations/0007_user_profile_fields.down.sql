@@ -0,0 +1,7 @@
+ALTER TABLE "user" DROP COLUMN "custom_attributes";
+ALTER TABLE "user" DROP COLUMN "timezone";
+ALTER TABLE "user" DROP COLUMN "avatar_thumbnails";
+ALTER TABLE "user" DROP COLUMN "avatar_url";
+ALTER TABLE "user" DROP COLUMN "address";
+ALTER TABLE "user" DROP COLUMN "gender";
+ALTER TABLE "user" DROP COLUMN "date_of_birth";
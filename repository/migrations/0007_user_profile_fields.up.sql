@@ -0,0 +1,11 @@
+ALTER TABLE "user" ADD COLUMN "date_of_birth" date;
+ALTER TABLE "user" ADD COLUMN "gender" varchar(16);
+ALTER TABLE "user" ADD COLUMN "address" varchar(500);
+ALTER TABLE "user" ADD COLUMN "avatar_url" varchar(2048);
+-- URLs of the thumbnails of an uploaded avatar keyed by their width.
+ALTER TABLE "user" ADD COLUMN "avatar_thumbnails" jsonb;
+-- IANA time zone name, e.g. "Asia/Jakarta".
+ALTER TABLE "user" ADD COLUMN "timezone" varchar(64);
+-- Values of the custom attributes defined by the administrators, keyed by
+-- their name.
+ALTER TABLE "user" ADD COLUMN "custom_attributes" jsonb NOT NULL DEFAULT '{}';
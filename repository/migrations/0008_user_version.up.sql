@@ -0,0 +1,2 @@
+-- Incremented by every change of the profile, it is the ETag of the profile.
+ALTER TABLE "user" ADD COLUMN "version" int NOT NULL DEFAULT 1;
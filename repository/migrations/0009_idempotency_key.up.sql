@@ -0,0 +1,17 @@
+-- Requests sent with an Idempotency-Key and their response, replayed when they
+-- are retried until expires_at.
+CREATE TABLE "idempotency_key" (
+  -- ID of the user sending the request, empty for anonymous requests.
+  "scope" varchar(64) NOT NULL,
+  "key" varchar(255) NOT NULL,
+  -- Hex SHA-256 of the request.
+  "fingerprint" char(64) NOT NULL,
+  "expires_at" timestamptz NOT NULL,
+  -- NULL while the request is in progress.
+  "status" int,
+  "header" jsonb,
+  "body" bytea,
+  PRIMARY KEY ("scope", "key")
+);
+
+CREATE INDEX "idempotency_key_expires_at_idx" ON "idempotency_key" ("expires_at");
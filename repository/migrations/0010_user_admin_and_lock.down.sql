@@ -0,0 +1,2 @@
+ALTER TABLE "user" DROP COLUMN "locked_at";
+ALTER TABLE "user" DROP COLUMN "is_admin";
@@ -0,0 +1,6 @@
+-- Administrators are created with the create-admin command, their tokens
+-- carry the "admin" scope.
+ALTER TABLE "user" ADD COLUMN "is_admin" boolean NOT NULL DEFAULT false;
+-- Locked users can't log in, they are locked and unlocked with the lock-user
+-- command.
+ALTER TABLE "user" ADD COLUMN "locked_at" timestamptz;
@@ -0,0 +1,4 @@
+-- When the password was set, passwords older than the max_age of the password
+-- policy have to be changed when logging in. Existing passwords count from
+-- this migration.
+ALTER TABLE "password" ADD COLUMN "changed_at" timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+DROP INDEX "user_verified_email_key";
+CREATE UNIQUE INDEX "user_email_key" ON "user" (lower("email"));
@@ -0,0 +1,4 @@
+-- Only verified addresses are unique, so that an address entered by someone
+-- who doesn't own it can't keep its owner from using it.
+DROP INDEX "user_email_key";
+CREATE UNIQUE INDEX "user_verified_email_key" ON "user" (lower("email")) WHERE "email_verified_at" IS NOT NULL;
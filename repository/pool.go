@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultConnectAttempts is how many times WaitForDatabase pings the database
+// by default.
+const DefaultConnectAttempts = 10
+
+// Delays between the pings of WaitForDatabase, doubling after every failure.
+const (
+	defaultConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 10 * time.Second
+)
+
+// PoolOptions configure the connection pool of a repository, zero values
+// keep the defaults of database/sql.
+type PoolOptions struct {
+	// MaxOpenConns is unlimited when zero.
+	MaxOpenConns int
+	// MaxIdleConns defaults to 2, no connection is kept idle when negative.
+	MaxIdleConns int
+	// ConnMaxLifetime and ConnMaxIdleTime are unlimited when zero.
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (opts PoolOptions) apply(db *sql.DB) {
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+}
+
+// WaitOptions configure WaitForDatabase.
+type WaitOptions struct {
+	// Attempts defaults to DefaultConnectAttempts.
+	Attempts int
+	// Backoff is the delay after the first failed ping, it doubles after
+	// every failure up to 10s. It defaults to 500ms.
+	Backoff time.Duration
+	// OnRetry is called before waiting to ping again, e.g. to log err.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Ping checks the database is reachable.
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.Db.PingContext(ctx)
+}
+
+// PoolStats returns the statistics of the connection pool.
+func (r *Repository) PoolStats() sql.DBStats {
+	return r.Db.Stats()
+}
+
+// WaitForDatabase pings db until it answers, so that the service can start
+// before the database is ready. It returns the error of the last ping when
+// every attempt failed, or when ctx is done.
+func WaitForDatabase(ctx context.Context, db *sql.DB, opts WaitOptions) error {
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = DefaultConnectAttempts
+	}
+	delay := opts.Backoff
+	if delay <= 0 {
+		delay = defaultConnectBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxConnectBackoff {
+			delay = maxConnectBackoff
+		}
+	}
+}
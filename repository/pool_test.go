@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Pool", func() {
+	var (
+		repo *Repository
+		mock sqlmock.Sqlmock
+		ctx  context.Context
+	)
+
+	ginkgo.BeforeEach(func() {
+		db, sqlMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		gomega.Expect(err).ToNot(gomega.HaveOccurred())
+		repo, mock, ctx = &Repository{Db: db}, sqlMock, context.Background()
+	})
+
+	ginkgo.AfterEach(func() {
+		gomega.Expect(mock.ExpectationsWereMet()).To(gomega.Succeed())
+	})
+
+	ginkgo.Describe("WaitForDatabase", func() {
+		ginkgo.It("should ping again until the database answers", func() {
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+			mock.ExpectPing()
+
+			var delays []time.Duration
+			err := WaitForDatabase(ctx, repo.Db, WaitOptions{
+				Attempts: 3,
+				Backoff:  time.Millisecond,
+				OnRetry: func(attempt int, err error, delay time.Duration) {
+					delays = append(delays, delay)
+				},
+			})
+			gomega.Expect(err).ToNot(gomega.HaveOccurred())
+			gomega.Expect(delays).To(gomega.Equal([]time.Duration{time.Millisecond, 2 * time.Millisecond}))
+		})
+
+		ginkgo.It("should return the last error when every attempt failed", func() {
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+			mock.ExpectPing().WillReturnError(errors.New("no such host"))
+
+			err := WaitForDatabase(ctx, repo.Db, WaitOptions{Attempts: 2, Backoff: time.Millisecond})
+			gomega.Expect(err).To(gomega.MatchError("no such host"))
+		})
+
+		ginkgo.It("should stop waiting when the context is done", func() {
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+			ctx, cancel := context.WithCancel(ctx)
+
+			err := WaitForDatabase(ctx, repo.Db, WaitOptions{
+				Attempts: 2,
+				Backoff:  time.Hour,
+				OnRetry:  func(attempt int, err error, delay time.Duration) { cancel() },
+			})
+			gomega.Expect(err).To(gomega.MatchError("connection refused"))
+		})
+	})
+
+	ginkgo.Describe("PoolOptions", func() {
+		ginkgo.It("should limit the connections of the pool", func() {
+			PoolOptions{MaxOpenConns: 5, MaxIdleConns: 2}.apply(repo.Db)
+			gomega.Expect(repo.PoolStats().MaxOpenConnections).To(gomega.Equal(5))
+		})
+	})
+})
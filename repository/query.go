@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// updateBuilder builds a parameterized UPDATE statement. Only allow-listed
+// columns can be set and every value is bound as a parameter, columns keep
+// the order they were set in.
+type updateBuilder struct {
+	table      string
+	allowed    map[string]struct{}
+	columns    []string
+	args       []interface{}
+	increments []string
+	conditions []condition
+}
+
+// condition restricts the rows of the statement to those where column has
+// one of values.
+type condition struct {
+	column string
+	values []interface{}
+}
+
+func newUpdateBuilder(table string, allowedColumns ...string) *updateBuilder {
+	allowed := make(map[string]struct{}, len(allowedColumns))
+	for _, column := range allowedColumns {
+		allowed[column] = struct{}{}
+	}
+	return &updateBuilder{table: table, allowed: allowed}
+}
+
+func (b *updateBuilder) Set(column string, value interface{}) error {
+	if _, ok := b.allowed[column]; !ok {
+		return fmt.Errorf("column %q can't be updated", column)
+	}
+	for _, set := range b.columns {
+		if set == column {
+			return fmt.Errorf("column %q is set twice", column)
+		}
+	}
+
+	b.columns = append(b.columns, column)
+	b.args = append(b.args, value)
+	return nil
+}
+
+// Increment adds 1 to column along with the columns that are set, e.g. to a
+// version. The column is not checked, it must not come from user input.
+func (b *updateBuilder) Increment(column string) {
+	b.increments = append(b.increments, column)
+}
+
+// WhereIn restricts the statement to the rows where column has one of
+// values, besides the condition of Build. The column is not checked, it must
+// not come from user input.
+func (b *updateBuilder) WhereIn(column string, values ...interface{}) {
+	b.conditions = append(b.conditions, condition{column, values})
+}
+
+func (b *updateBuilder) IsEmpty() bool {
+	return len(b.columns) == 0
+}
+
+// Build returns the statement updating the rows where whereColumn equals
+// whereValue, along with its arguments.
+func (b *updateBuilder) Build(whereColumn string, whereValue interface{}) (string, []interface{}) {
+	assignments := make([]string, 0, len(b.columns)+len(b.increments))
+	for i, column := range b.columns {
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, i+1))
+	}
+	for _, column := range b.increments {
+		assignments = append(assignments, fmt.Sprintf("%s = %s + 1", column, column))
+	}
+
+	args := append(append([]interface{}{}, b.args...), whereValue)
+	where := fmt.Sprintf("%s = $%d", whereColumn, len(args))
+	for _, condition := range b.conditions {
+		placeholders := make([]string, len(condition.values))
+		for i, value := range condition.values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		where += fmt.Sprintf(" AND %s IN (%s)", condition.column, strings.Join(placeholders, ", "))
+	}
+
+	sqlStmt := fmt.Sprintf("UPDATE %s SET %s WHERE %s", b.table, strings.Join(assignments, ", "), where)
+	return sqlStmt, args
+}
@@ -4,15 +4,29 @@ package repository
 import (
 	"database/sql"
 
+	"github.com/SawitProRecruitment/UserService/utils"
 	_ "github.com/lib/pq"
 )
 
 type Repository struct {
-	Db *sql.DB
+	Db     *sql.DB
+	Hasher utils.PasswordHasher
+	// Isolation is the isolation level of the transactions of WithTx.
+	Isolation sql.IsolationLevel
+	// TxRetries is how many times WithTx runs a transaction again after a
+	// serialization failure or a deadlock.
+	TxRetries int
 }
 
 type NewRepositoryOptions struct {
-	Dsn string
+	Dsn    string
+	Hasher utils.PasswordHasher
+	// TxIsolation defaults to the isolation level of the database, read
+	// committed for Postgres.
+	TxIsolation sql.IsolationLevel
+	// TxRetries defaults to DefaultTxRetries, it is disabled when negative.
+	TxRetries int
+	Pool      PoolOptions
 }
 
 func NewRepository(opts NewRepositoryOptions) *Repository {
@@ -20,7 +34,17 @@ func NewRepository(opts NewRepositoryOptions) *Repository {
 	if err != nil {
 		panic(err)
 	}
+	opts.Pool.apply(db)
+	txRetries := opts.TxRetries
+	if txRetries == 0 {
+		txRetries = DefaultTxRetries
+	} else if txRetries < 0 {
+		txRetries = 0
+	}
 	return &Repository{
-		Db: db,
+		Db:        db,
+		Hasher:    opts.Hasher,
+		Isolation: opts.TxIsolation,
+		TxRetries: txRetries,
 	}
 }
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultTxRetries is how many times NewRepository lets a transaction be run
+// again after a serialization failure or a deadlock.
+const DefaultTxRetries = 3
+
+// txRetryBaseDelay is the delay before the first retry of a transaction, it
+// doubles with every retry.
+const txRetryBaseDelay = 10 * time.Millisecond
+
+// querier runs queries on a *sql.DB or in a *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// TxOptions configure a transaction of WithTxOptions.
+type TxOptions struct {
+	// Isolation defaults to the isolation level of the repository.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+}
+
+// WithTx runs fn in a transaction with the isolation level of the repository,
+// see WithTxOptions.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return r.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+// WithTxOptions runs fn in a transaction, committed when fn returns nil and
+// rolled back when it returns an error or panics. The error of fn is returned
+// as it is. Transactions failing with a serialization failure or a deadlock
+// are run again up to TxRetries times, so fn must not have effects outside
+// of tx, and must set again everything it sets.
+func (r *Repository) WithTxOptions(ctx context.Context, opts TxOptions, fn func(tx *sql.Tx) error) error {
+	isolation := opts.Isolation
+	if isolation == sql.LevelDefault {
+		isolation = r.Isolation
+	}
+	txOpts := &sql.TxOptions{Isolation: isolation, ReadOnly: opts.ReadOnly}
+
+	for attempt := 0; ; attempt++ {
+		err := r.runTx(ctx, txOpts, fn)
+		if err == nil || attempt >= r.TxRetries || !isRetryableTxError(err) {
+			return err
+		}
+
+		// Jitter keeps the transactions that conflicted from conflicting
+		// again.
+		delay := txRetryBaseDelay << attempt
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *Repository) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := r.Db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryableTxError reports whether err is a serialization failure or a
+// deadlock, which the transaction can succeed after when it is run again.
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected)
+}
+
+// ParseIsolationLevel parses an SQL isolation level such as "repeatable read"
+// or "serializable", case insensitively. The empty string is the default level
+// of the database.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	switch strings.ToLower(strings.Join(strings.Fields(name), " ")) {
+	case "", "default":
+		return sql.LevelDefault, nil
+	case "read uncommitted":
+		return sql.LevelReadUncommitted, nil
+	case "read committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	}
+	return sql.LevelDefault, fmt.Errorf("unknown isolation level %q", name)
+}
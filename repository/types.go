@@ -1,6 +1,28 @@
 // This file contains types that are used in the repository layer.
 package repository
 
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/generated"
+)
+
+var (
+	ErrUserNotFound  = errors.New("User not found.")
+	ErrWrongPassword = errors.New("Wrong password")
+	// ErrUserLocked is returned when a locked user logs in with the right
+	// password.
+	ErrUserLocked = errors.New("user is locked")
+	// ErrVersionMismatch is returned when a profile doesn't have any of the
+	// versions an update applies to.
+	ErrVersionMismatch = errors.New("profile version mismatch")
+	// ErrDuplicate is wrapped by the errors of values another user already
+	// has.
+	ErrDuplicate = errors.New("duplicate value")
+)
+
 type GetTestByIdInput struct {
 	Id string
 }
@@ -8,3 +30,112 @@ type GetTestByIdInput struct {
 type GetTestByIdOutput struct {
 	Name string
 }
+
+// UserProfileUpdate holds the new values of a user profile, nil fields are
+// left untouched.
+type UserProfileUpdate struct {
+	FullName    *string
+	PhoneNumber *string
+	// Email is verified again after a change.
+	Email *string
+	// Username is normalized, it can be changed at most once per
+	// UsernameChangeInterval.
+	Username               *string
+	UsernameChangeInterval time.Duration
+	// Language of the messages to the user, a supported BCP 47 tag.
+	Language *string
+	// DateOfBirth is formatted as "YYYY-MM-DD".
+	DateOfBirth *string
+	Gender      *string
+	Address     *string
+	// AvatarURL replaces the avatar, along with its thumbnails. They are
+	// cleared if AvatarThumbnails is nil.
+	AvatarURL        *string
+	AvatarThumbnails map[string]string
+	// Timezone is an IANA time zone name.
+	Timezone *string
+	// CustomAttributes replace all the current ones when they are not nil.
+	CustomAttributes map[string]interface{}
+	// Clear lists the optional fields to remove, by column: "email",
+	// "language", "date_of_birth", "gender", "address", "avatar_url" or
+	// "timezone". Removing the email removes its verification, and removing
+	// the avatar its thumbnails.
+	Clear []string
+	// Versions the profile must have for the update to apply, or nil for any
+	// version. Every update increments the version.
+	Versions []int
+}
+
+// UsernameChangeTooSoonError is returned when the username of a user changed
+// less than the change interval ago.
+type UsernameChangeTooSoonError struct {
+	NextChangeAt time.Time
+}
+
+func (e *UsernameChangeTooSoonError) Error() string {
+	return fmt.Sprintf("Usernames can be changed again after %s.", e.NextChangeAt.UTC().Format(time.RFC3339))
+}
+
+// DuplicateError is returned when a unique constraint rejects a value another
+// row already has, e.g. a phone number registered concurrently.
+type DuplicateError struct {
+	// Column of the value: "phone_number", "email" or "username", empty for
+	// other constraints.
+	Column     string
+	Constraint string
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("duplicate value violates unique constraint %q", e.Constraint)
+	}
+	return fmt.Sprintf("%s already exists", e.Column)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicate
+}
+
+// LoginCredentials identify a user either by PhoneNumber or by a verified
+// Email, both already normalized.
+type LoginCredentials struct {
+	PhoneNumber string
+	Email       string
+	Password    string
+}
+
+// UserAccess is what a user may currently do, whatever their tokens claim.
+type UserAccess struct {
+	IsAdmin bool
+	Locked  bool
+}
+
+// ExportedUser is a user as listed by ExportUsers.
+type ExportedUser struct {
+	ID       string
+	Profile  generated.UserProfile
+	IsAdmin  bool
+	LockedAt *time.Time
+}
+
+// IdempotentRequest is a request sent with an Idempotency-Key, along with its
+// response once it completed.
+type IdempotentRequest struct {
+	// Scope keeps the keys of different clients apart, e.g. the ID of the
+	// user, empty for anonymous clients.
+	Scope string
+	Key   string
+	// Fingerprint identifies the content of the request, a key can't be
+	// reused for another request.
+	Fingerprint string
+	ExpiresAt   time.Time
+	// Response is nil while the request is in progress.
+	Response *IdempotentResponse
+}
+
+// IdempotentResponse is the response replayed when a request is retried.
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
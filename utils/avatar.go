@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	DefaultAvatarMaxBytes = 5 << 20
+	// MinAvatarSize is the minimum width and height of an uploaded avatar.
+	MinAvatarSize = 64
+	// maxAvatarPixels bounds the memory needed to decode an avatar, the
+	// file size alone doesn't, e.g. for a PNG of a single color.
+	maxAvatarPixels = 4096 * 4096
+)
+
+// AvatarSizes are the widths of the square images made of an avatar, the
+// first one is the avatar itself and the others are thumbnails.
+var AvatarSizes = []int{512, 256, 128, 64}
+
+var (
+	ErrAvatarTooLarge = errors.New("avatar too large")
+	ErrInvalidAvatar  = errors.New("invalid avatar")
+)
+
+// AvatarImage is an avatar scaled to Size×Size pixels, encoded as JPEG.
+type AvatarImage struct {
+	Size int
+	Data []byte
+}
+
+// ProcessAvatar reads a JPEG, PNG or GIF image of at most maxBytes, crops it
+// to a centered square and scales it down to every one of AvatarSizes that
+// isn't larger than the square. The images are encoded again, which drops
+// metadata such as the location in EXIF, and transparent areas become white.
+func ProcessAvatar(r io.Reader, maxBytes int64) ([]AvatarImage, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrAvatarTooLarge
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrInvalidAvatar
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width < MinAvatarSize || config.Height < MinAvatarSize ||
+		config.Width*config.Height > maxAvatarPixels {
+		return nil, ErrInvalidAvatar
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidAvatar
+	}
+
+	square := cropSquare(src)
+	var images []AvatarImage
+	for _, size := range AvatarSizes {
+		// Avatars are at least MinAvatarSize, the smallest of AvatarSizes.
+		if size > square.Bounds().Dx() {
+			continue
+		}
+		var encoded bytes.Buffer
+		if err := jpeg.Encode(&encoded, scaleDown(square, size), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		images = append(images, AvatarImage{size, encoded.Bytes()})
+	}
+	return images, nil
+}
+
+// cropSquare returns the centered square of src on a white background.
+func cropSquare(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(square, square.Bounds(), src, origin, draw.Over)
+	return square
+}
+
+// scaleDown resizes the square src to size×size by averaging the source
+// pixels covered by every destination pixel, which keeps thumbnails free of
+// aliasing without an external imaging library.
+func scaleDown(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[offset])
+					g += uint32(src.Pix[offset+1])
+					b += uint32(src.Pix[offset+2])
+					a += uint32(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("ProcessAvatar", func() {
+	encodePNG := func(width, height int, fill color.Color) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, fill)
+			}
+		}
+		var buf bytes.Buffer
+		gomega.Expect(png.Encode(&buf, img)).To(gomega.Succeed())
+		return buf.Bytes()
+	}
+
+	ginkgo.It("should crop to a square and scale to every size that fits", func() {
+		images, err := ProcessAvatar(bytes.NewReader(encodePNG(400, 300, color.RGBA{255, 0, 0, 255})), DefaultAvatarMaxBytes)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		var sizes []int
+		for _, avatar := range images {
+			sizes = append(sizes, avatar.Size)
+			decoded, err := jpeg.Decode(bytes.NewReader(avatar.Data))
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(decoded.Bounds().Size()).To(gomega.Equal(image.Pt(avatar.Size, avatar.Size)))
+
+			r, g, b, _ := decoded.At(avatar.Size/2, avatar.Size/2).RGBA()
+			gomega.Expect(r >> 8).To(gomega.BeNumerically(">", 240))
+			gomega.Expect(g>>8 + b>>8).To(gomega.BeNumerically("<", 20))
+		}
+		gomega.Expect(sizes).To(gomega.Equal([]int{256, 128, 64}))
+	})
+
+	ginkgo.It("should put transparent images on white", func() {
+		images, err := ProcessAvatar(bytes.NewReader(encodePNG(64, 64, color.Transparent)), DefaultAvatarMaxBytes)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		decoded, err := jpeg.Decode(bytes.NewReader(images[0].Data))
+		gomega.Expect(err).To(gomega.BeNil())
+		r, g, b, _ := decoded.At(32, 32).RGBA()
+		gomega.Expect([]uint32{r >> 8, g >> 8, b >> 8}).To(gomega.HaveEach(gomega.BeNumerically(">", 250)))
+	})
+
+	ginkgo.It("should reject files that are too large", func() {
+		_, err := ProcessAvatar(bytes.NewReader(encodePNG(64, 64, color.White)), 100)
+		gomega.Expect(err).To(gomega.Equal(ErrAvatarTooLarge))
+	})
+
+	ginkgo.DescribeTable("should reject",
+		func(data []byte) {
+			_, err := ProcessAvatar(bytes.NewReader(data), DefaultAvatarMaxBytes)
+			gomega.Expect(err).To(gomega.Equal(ErrInvalidAvatar))
+		},
+		ginkgo.Entry("other files", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>")),
+		ginkgo.Entry("corrupt images", append(encodePNG(64, 64, color.White)[:40], strings.Repeat("x", 100)...)),
+		ginkgo.Entry("images that are too small", encodePNG(63, 100, color.White)),
+		ginkgo.Entry("images that are too large to decode", encodePNG(4097, 4097, color.White)),
+	)
+})
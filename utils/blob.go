@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidBlobKey = errors.New("invalid blob key")
+
+// BlobStore stores public files, such as avatars, under keys like
+// "avatars/1/256.jpg". Putting a key again replaces the blob.
+type BlobStore interface {
+	// Put stores data and returns the URL it is served at.
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+}
+
+// cleanBlobKey rejects keys that could escape the directory or bucket of the
+// store.
+func cleanBlobKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return "", ErrInvalidBlobKey
+	}
+	return key, nil
+}
+
+type localBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+type NewLocalBlobStoreOptions struct {
+	// Dir is created if it doesn't exist.
+	Dir string
+	// BaseURL the files of Dir are served at, e.g. "/blobs" or
+	// "https://cdn.example.com".
+	BaseURL string
+}
+
+// NewLocalBlobStore returns a BlobStore writing files to a directory, for a
+// single server or development.
+func NewLocalBlobStore(opts NewLocalBlobStoreOptions) (*localBlobStore, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("local blob store: no directory")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("local blob store: %v", err)
+	}
+	return &localBlobStore{opts.Dir, strings.TrimSuffix(opts.BaseURL, "/")}, nil
+}
+
+// Put writes data to a temporary file first, so a blob is never served half
+// written.
+func (s *localBlobStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(filepath.Dir(name), ".blob-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(file.Name(), name); err != nil {
+		return "", err
+	}
+	return s.BaseURL + "/" + key, nil
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const defaultBreachedFalsePositiveRate = 0.001
+
+// PasswordBlocklist tells whether a password is known to attackers, either
+// because it appeared in a breach or because it is among the most common ones.
+type PasswordBlocklist interface {
+	IsBreached(password string) bool
+	IsCommon(password string) bool
+}
+
+type NewPasswordBlocklistOptions struct {
+	// BreachedFile contains upper case SHA-1 hashes, one per line, optionally
+	// followed by ":count" as in the Have I Been Pwned "ordered by hash" dump.
+	BreachedFile string
+	// FalsePositiveRate of the bloom filter built from BreachedFile, defaults to 0.1%.
+	FalsePositiveRate float64
+	// CommonFile contains plain text passwords, one per line, most common first.
+	CommonFile string
+	// CommonTopN limits how many lines of CommonFile are used, 0 means all.
+	CommonTopN int
+}
+
+type passwordBlocklist struct {
+	Breached *bloomFilter
+	Common   map[string]struct{}
+}
+
+// NewPasswordBlocklist loads the breached and common password corpora. It is
+// meant to be called once at startup, as building the filter reads the whole
+// breached password file.
+func NewPasswordBlocklist(opts NewPasswordBlocklistOptions) (*passwordBlocklist, error) {
+	blocklist := &passwordBlocklist{Common: map[string]struct{}{}}
+
+	if opts.BreachedFile != "" {
+		if opts.FalsePositiveRate <= 0 {
+			opts.FalsePositiveRate = defaultBreachedFalsePositiveRate
+		}
+
+		breached, err := loadBreachedPasswords(opts.BreachedFile, opts.FalsePositiveRate)
+		if err != nil {
+			return nil, err
+		}
+		blocklist.Breached = breached
+	}
+
+	if opts.CommonFile != "" {
+		err := readLines(opts.CommonFile, func(line string) bool {
+			blocklist.Common[strings.ToLower(line)] = struct{}{}
+			return opts.CommonTopN == 0 || len(blocklist.Common) < opts.CommonTopN
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return blocklist, nil
+}
+
+func (b *passwordBlocklist) IsBreached(password string) bool {
+	if b.Breached == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	return b.Breached.Contains(sum)
+}
+
+func (b *passwordBlocklist) IsCommon(password string) bool {
+	_, ok := b.Common[strings.ToLower(password)]
+	return ok
+}
+
+func loadBreachedPasswords(path string, falsePositiveRate float64) (*bloomFilter, error) {
+	count := 0
+	if err := readLines(path, func(string) bool { count++; return true }); err != nil {
+		return nil, err
+	}
+
+	filter := newBloomFilter(count, falsePositiveRate)
+	err := readLines(path, func(line string) bool {
+		hash, _, _ := strings.Cut(line, ":")
+		var sum [sha1.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(hash)); err != nil || n != sha1.Size {
+			return true
+		}
+		filter.Add(sum)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// readLines calls fn with every non empty trimmed line of the file until fn
+// returns false.
+func readLines(path string, fn func(line string) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !fn(line) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// bloomFilter over SHA-1 sums. The sums are already uniformly distributed, so
+// the probe positions are derived from them by double hashing instead of
+// hashing again.
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	size := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Max(1, math.Round(float64(size)/float64(n)*math.Ln2)))
+
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+func (f *bloomFilter) positions(sum [sha1.Size]byte, fn func(position uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	for i := uint64(0); i < f.hashes; i++ {
+		if !fn((h1 + i*h2) % f.size) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) Add(sum [sha1.Size]byte) {
+	f.positions(sum, func(position uint64) bool {
+		f.bits[position/64] |= 1 << (position % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) Contains(sum [sha1.Size]byte) bool {
+	return f.positions(sum, func(position uint64) bool {
+		return f.bits[position/64]&(1<<(position%64)) != 0
+	})
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("PasswordBlocklist", func() {
+	var dir string
+
+	ginkgo.BeforeEach(func() {
+		dir = ginkgo.GinkgoT().TempDir()
+	})
+
+	writeFile := func(name string, lines ...string) string {
+		path := filepath.Join(dir, name)
+		gomega.Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)).To(gomega.Succeed())
+		return path
+	}
+
+	sha1Hex := func(password string) string {
+		sum := sha1.Sum([]byte(password))
+		return hex.EncodeToString(sum[:])
+	}
+
+	ginkgo.It("should block nothing without files", func() {
+		blocklist, err := NewPasswordBlocklist(NewPasswordBlocklistOptions{})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(blocklist.IsBreached("password")).To(gomega.BeFalse())
+		gomega.Expect(blocklist.IsCommon("password")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should read HASH:count lines in upper and lower case and skip bad lines", func() {
+		blocklist, err := NewPasswordBlocklist(NewPasswordBlocklistOptions{
+			BreachedFile: writeFile("breached.txt",
+				strings.ToUpper(sha1Hex("P@ssw0rd"))+":3861493",
+				sha1Hex("letmein1"),
+				"not a hash:12",
+				"ABCDEF:1",
+				"",
+			),
+		})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(blocklist.IsBreached("P@ssw0rd")).To(gomega.BeTrue())
+		gomega.Expect(blocklist.IsBreached("letmein1")).To(gomega.BeTrue())
+		gomega.Expect(blocklist.IsBreached("correct horse battery staple")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should only use the first CommonTopN common passwords, case insensitively", func() {
+		blocklist, err := NewPasswordBlocklist(NewPasswordBlocklistOptions{
+			CommonFile: writeFile("common.txt", "123456", "Password", "qwerty"),
+			CommonTopN: 2,
+		})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(blocklist.IsCommon("123456")).To(gomega.BeTrue())
+		gomega.Expect(blocklist.IsCommon("PASSWORD")).To(gomega.BeTrue())
+		gomega.Expect(blocklist.IsCommon("qwerty")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should use every common password when CommonTopN is 0", func() {
+		blocklist, err := NewPasswordBlocklist(NewPasswordBlocklistOptions{
+			CommonFile: writeFile("common.txt", "123456", "Password", "qwerty"),
+		})
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(blocklist.IsCommon("qwerty")).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should fail when a file is missing", func() {
+		_, err := NewPasswordBlocklist(NewPasswordBlocklistOptions{BreachedFile: filepath.Join(dir, "missing.txt")})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("missing.txt")))
+
+		_, err = NewPasswordBlocklist(NewPasswordBlocklistOptions{CommonFile: filepath.Join(dir, "missing.txt")})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("missing.txt")))
+	})
+})
+
+var _ = ginkgo.Describe("bloomFilter", func() {
+	sum := func(i int) [sha1.Size]byte {
+		return sha1.Sum([]byte(fmt.Sprint("password", i)))
+	}
+
+	ginkgo.It("should be sized for the number of items and the false positive rate", func() {
+		filter := newBloomFilter(1000, 0.01)
+		gomega.Expect(filter.size).To(gomega.Equal(uint64(9586)))
+		gomega.Expect(filter.hashes).To(gomega.Equal(uint64(7)))
+		gomega.Expect(filter.bits).To(gomega.HaveLen(150))
+	})
+
+	ginkgo.It("should contain every added item and few others", func() {
+		const n = 10000
+		filter := newBloomFilter(n, 0.01)
+		for i := 0; i < n; i++ {
+			filter.Add(sum(i))
+		}
+
+		falsePositives := 0
+		for i := 0; i < n; i++ {
+			gomega.Expect(filter.Contains(sum(i))).To(gomega.BeTrue())
+			if filter.Contains(sum(n + i)) {
+				falsePositives++
+			}
+		}
+		gomega.Expect(float64(falsePositives) / n).To(gomega.BeNumerically("<", 0.02))
+	})
+
+	ginkgo.It("should hold at least one bit when it is empty", func() {
+		filter := newBloomFilter(0, 0.01)
+		gomega.Expect(filter.Contains(sum(0))).To(gomega.BeFalse())
+	})
+})
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEmailVerificationTTL = 24 * time.Hour
+	defaultEmailVerificationURL = "http://localhost:1323/email/verify"
+)
+
+var (
+	ErrInvalidEmail                  = errors.New("invalid email address")
+	ErrInvalidEmailVerificationToken = errors.New("invalid or expired email verification token")
+)
+
+// NormalizeEmail returns the bare address of email in lower case, so lookups
+// are case insensitive.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 254 {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+// EmailVerifier mails signed links proving the recipient owns an address.
+// The link carries the user ID, the address and an expiry signed with an
+// HMAC, so nothing has to be stored until it is followed, and changing the
+// address invalidates the links sent for the previous one.
+type EmailVerifier struct {
+	mailer Mailer
+	key    []byte
+	ttl    time.Duration
+	url    string
+}
+
+type NewEmailVerifierOptions struct {
+	// Mailer defaults to writing the mails to stdout.
+	Mailer Mailer
+	// Key signs the links and must be at least 16 bytes. A random key is used
+	// when it is empty, links sent before a restart then stop working.
+	Key []byte
+	// TTL of the links, defaults to 24 hours.
+	TTL time.Duration
+	// URL the token is appended to as "token" query parameter, defaults to
+	// the verification endpoint of a local server.
+	URL string
+}
+
+func NewEmailVerifier(opts NewEmailVerifierOptions) (*EmailVerifier, error) {
+	if opts.Mailer == nil {
+		opts.Mailer = NewWriterMailer(os.Stdout)
+	}
+	if len(opts.Key) == 0 {
+		opts.Key = make([]byte, 32)
+		if _, err := rand.Read(opts.Key); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Key) < 16 {
+		return nil, fmt.Errorf("email verification key must be at least 16 bytes")
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultEmailVerificationTTL
+	}
+	if opts.URL == "" {
+		opts.URL = defaultEmailVerificationURL
+	}
+	if _, err := url.Parse(opts.URL); err != nil {
+		return nil, fmt.Errorf("invalid email verification URL: %v", err)
+	}
+
+	return &EmailVerifier{opts.Mailer, opts.Key, opts.TTL, opts.URL}, nil
+}
+
+// SendVerification mails a verification link for email to its owner.
+func (v *EmailVerifier) SendVerification(ctx context.Context, userID, email string) error {
+	link, _ := url.Parse(v.url)
+	query := link.Query()
+	query.Set("token", v.sign(userID, email, time.Now().Add(v.ttl)))
+	link.RawQuery = query.Encode()
+
+	return v.mailer.Send(ctx, Mail{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open the link below to verify your email address. It expires in %s.\n\n%s",
+			v.ttl, link.String()),
+	})
+}
+
+// Verify returns the user ID and the email address token was issued for. It
+// fails with ErrInvalidEmailVerificationToken if the token was tampered with
+// or is expired.
+func (v *EmailVerifier) Verify(token string) (userID, email string, err error) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return "", "", ErrInvalidEmailVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", "", ErrInvalidEmailVerificationToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, v.mac(payload)) {
+		return "", "", ErrInvalidEmailVerificationToken
+	}
+
+	fields := strings.SplitN(string(payload), "\n", 3)
+	if len(fields) != 3 {
+		return "", "", ErrInvalidEmailVerificationToken
+	}
+	expiresAt, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return "", "", ErrInvalidEmailVerificationToken
+	}
+
+	return fields[1], fields[2], nil
+}
+
+func (v *EmailVerifier) sign(userID, email string, expiresAt time.Time) string {
+	payload := []byte(fmt.Sprintf("%d\n%s\n%s", expiresAt.Unix(), userID, email))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(v.mac(payload))
+}
+
+func (v *EmailVerifier) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	minFullNameLength = 3
+	maxFullNameLength = 60
+)
+
+var ErrInvalidFullName = errors.New("invalid full name")
+
+// NormalizeFullName returns fullName NFC normalized, with leading and trailing
+// whitespace removed and every run of whitespace inside collapsed to a single
+// space. Names have 3 to 60 characters as users perceive them, so "Nguyễn"
+// has 6 whatever the form it was typed in. Control characters, private use
+// characters and invisible formatting characters such as zero width spaces are
+// rejected, they could make two names look the same.
+func NormalizeFullName(fullName string) (string, error) {
+	normalized := norm.NFC.String(strings.Join(strings.Fields(fullName), " "))
+
+	for _, r := range normalized {
+		if unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs) || r == unicode.ReplacementChar {
+			return "", ErrInvalidFullName
+		}
+	}
+
+	length := GraphemeCount(normalized)
+	if length < minFullNameLength || length > maxFullNameLength {
+		return "", ErrInvalidFullName
+	}
+	return normalized, nil
+}
+
+// GraphemeCount approximates the number of characters of s as users perceive
+// them: combining marks count with the character they are attached to, e.g.
+// the vowel signs of Devanagari. Sequences joined with zero width joiners,
+// such as emoji families, are not recognized.
+func GraphemeCount(s string) int {
+	count := 0
+	for i, r := range s {
+		if i > 0 && unicode.Is(unicode.M, r) {
+			continue
+		}
+		count++
+	}
+	return count
+}
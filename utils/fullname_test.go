@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"strings"
+
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Full names", func() {
+	ginkgo.DescribeTable("NormalizeFullName accepts",
+		func(fullName, normalized string) {
+			gomega.Expect(NormalizeFullName(fullName)).To(gomega.Equal(normalized))
+		},
+		ginkgo.Entry("plain names", "John Doe", "John Doe"),
+		ginkgo.Entry("surrounding whitespace", "  John Doe\n", "John Doe"),
+		ginkgo.Entry("runs of whitespace", "John \t  Doe", "John Doe"),
+		ginkgo.Entry("decomposed letters", "Nguyễn", "Nguyễn"),
+		ginkgo.Entry("Chinese names", "李小龍", "李小龍"),
+		ginkgo.Entry("60 multibyte characters", strings.Repeat("ä", 60), strings.Repeat("ä", 60)),
+		ginkgo.Entry("60 characters with combining marks", strings.Repeat("कि", 60), strings.Repeat("कि", 60)),
+	)
+
+	ginkgo.DescribeTable("NormalizeFullName rejects",
+		func(fullName string) {
+			_, err := NormalizeFullName(fullName)
+			gomega.Expect(err).To(gomega.Equal(ErrInvalidFullName))
+		},
+		ginkgo.Entry("too short names", "Jo"),
+		ginkgo.Entry("names that are too short once trimmed", "  Jo  "),
+		ginkgo.Entry("too long names", strings.Repeat("a", 61)),
+		ginkgo.Entry("control characters", "John\x00Doe"),
+		ginkgo.Entry("zero width spaces", "John​Doe"),
+		ginkgo.Entry("zero width joiners", "John‍Doe"),
+		ginkgo.Entry("bidirectional overrides", "John‮Doe"),
+		ginkgo.Entry("invalid UTF-8", "John\xffDoe"),
+	)
+
+	ginkgo.It("should count combining marks with their base character", func() {
+		gomega.Expect(GraphemeCount("Nguyễn")).To(gomega.Equal(6))
+		gomega.Expect(GraphemeCount("किताब")).To(gomega.Equal(3))
+	})
+})
@@ -0,0 +1,348 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchTestFailed is returned when a "test" operation of a JSON Patch
+	// doesn't match the document.
+	ErrPatchTestFailed = errors.New("patch test failed")
+)
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to document, a value as
+// decoded from JSON into an interface{}. Members set to null in the patch are
+// removed. document is not modified.
+func MergePatch(document interface{}, patch []byte) (interface{}, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return mergePatch(deepCopy(document), p), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// ApplyJSONPatch applies the operations of an RFC 6902 JSON Patch to
+// document, a value as decoded from JSON into an interface{}. The patch is
+// atomic, document is not modified and nothing is returned if any operation
+// fails.
+func ApplyJSONPatch(document interface{}, patch []byte) (interface{}, error) {
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	document = deepCopy(document)
+	for i, operation := range operations {
+		var err error
+		if document, err = applyOperation(document, operation); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return document, nil
+}
+
+func applyOperation(document interface{}, operation map[string]json.RawMessage) (interface{}, error) {
+	var op string
+	if err := json.Unmarshal(operation["op"], &op); err != nil {
+		return nil, fmt.Errorf(`%w: "op" must be a string`, ErrInvalidPatch)
+	}
+	path, err := operationPointer(operation, "path")
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add", "replace", "test":
+		raw, ok := operation["value"]
+		if !ok {
+			return nil, fmt.Errorf(`%w: %s has no "value"`, ErrInvalidPatch, op)
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		switch op {
+		case "add":
+			return addValue(document, path, value)
+		case "replace":
+			if _, err := getValue(document, path); err != nil {
+				return nil, err
+			}
+			if len(path) == 0 {
+				return value, nil
+			}
+			if document, _, err = removeValue(document, path); err != nil {
+				return nil, err
+			}
+			return addValue(document, path, value)
+		default:
+			current, err := getValue(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("%w: %s", ErrPatchTestFailed, formatPointer(path))
+			}
+			return document, nil
+		}
+
+	case "remove":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("%w: the whole document can't be removed", ErrInvalidPatch)
+		}
+		document, _, err = removeValue(document, path)
+		return document, err
+
+	case "move", "copy":
+		from, err := operationPointer(operation, "from")
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(document, from)
+		if err != nil {
+			return nil, err
+		}
+		if op == "copy" {
+			return addValue(document, path, deepCopy(value))
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, fmt.Errorf("%w: %s can't be moved into itself", ErrInvalidPatch, formatPointer(from))
+		}
+		if len(from) == 0 {
+			return document, nil
+		}
+		if document, _, err = removeValue(document, from); err != nil {
+			return nil, err
+		}
+		return addValue(document, path, value)
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op)
+}
+
+// operationPointer parses the RFC 6901 JSON Pointer in the member name of
+// operation into its reference tokens.
+func operationPointer(operation map[string]json.RawMessage, name string) ([]string, error) {
+	var pointer string
+	if err := json.Unmarshal(operation[name], &pointer); err != nil {
+		return nil, fmt.Errorf("%w: %q must be a string", ErrInvalidPatch, name)
+	}
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q must start with \"/\"", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func formatPointer(tokens []string) string {
+	var pointer strings.Builder
+	for _, token := range tokens {
+		pointer.WriteString("/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+	return pointer.String()
+}
+
+func getValue(document interface{}, path []string) (interface{}, error) {
+	value := document
+	for i, token := range path {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			child, ok := container[token]
+			if !ok {
+				return nil, pathNotFound(path[:i+1])
+			}
+			value = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, pathNotFound(path[:i+1])
+			}
+			value = container[index]
+		default:
+			return nil, pathNotFound(path[:i+1])
+		}
+	}
+	return value, nil
+}
+
+// addValue returns document with value added at path, replacing a member of
+// an object or inserting into an array.
+func addValue(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+
+	switch container := document.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			container[token] = value
+			return container, nil
+		}
+		child, ok := container[token]
+		if !ok {
+			return nil, pathNotFound(path[:1])
+		}
+		child, err := addValue(child, path[1:], value)
+		if err != nil {
+			return nil, prefixPointer(token, err)
+		}
+		container[token] = child
+		return container, nil
+
+	case []interface{}:
+		if len(path) == 1 {
+			index := len(container)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(container)); err != nil {
+					return nil, pathNotFound(path[:1])
+				}
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, pathNotFound(path[:1])
+		}
+		child, err := addValue(container[index], path[1:], value)
+		if err != nil {
+			return nil, prefixPointer(token, err)
+		}
+		container[index] = child
+		return container, nil
+	}
+	return nil, pathNotFound(path[:1])
+}
+
+// removeValue returns document without the value at path, and the value.
+func removeValue(document interface{}, path []string) (interface{}, interface{}, error) {
+	token := path[0]
+
+	switch container := document.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, nil, pathNotFound(path[:1])
+		}
+		if len(path) == 1 {
+			delete(container, token)
+			return container, child, nil
+		}
+		child, removed, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, nil, prefixPointer(token, err)
+		}
+		container[token] = child
+		return container, removed, nil
+
+	case []interface{}:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, nil, pathNotFound(path[:1])
+		}
+		if len(path) == 1 {
+			removed := container[index]
+			return append(container[:index], container[index+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(container[index], path[1:])
+		if err != nil {
+			return nil, nil, prefixPointer(token, err)
+		}
+		container[index] = child
+		return container, removed, nil
+	}
+	return nil, nil, pathNotFound(path[:1])
+}
+
+// arrayIndex parses an array index of at most max, without leading zeros as
+// RFC 6901 requires.
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, ErrInvalidPatch
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index > max {
+		return 0, ErrInvalidPatch
+	}
+	return index, nil
+}
+
+// pointerError is a path of a patch that doesn't exist in the document. The
+// tokens are collected on the way back from the missing value.
+type pointerError struct {
+	path []string
+}
+
+func (e *pointerError) Error() string {
+	return fmt.Sprintf("%v: %s does not exist", ErrInvalidPatch, formatPointer(e.path))
+}
+
+func (e *pointerError) Unwrap() error {
+	return ErrInvalidPatch
+}
+
+func pathNotFound(path []string) error {
+	return &pointerError{append([]string{}, path...)}
+}
+
+func prefixPointer(token string, err error) error {
+	var pointerErr *pointerError
+	if errors.As(err, &pointerErr) {
+		pointerErr.path = append([]string{token}, pointerErr.path...)
+	}
+	return err
+}
+
+// deepCopy copies the objects and arrays of a value decoded from JSON.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			copied[name] = deepCopy(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"encoding/json"
+
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+func decodeJSON(document string) interface{} {
+	var value interface{}
+	gomega.Expect(json.Unmarshal([]byte(document), &value)).To(gomega.Succeed())
+	return value
+}
+
+var _ = ginkgo.Describe("JSON patches", func() {
+	ginkgo.DescribeTable("MergePatch applies the examples of RFC 7396",
+		func(document, patch, result string) {
+			gomega.Expect(MergePatch(decodeJSON(document), []byte(patch))).To(gomega.Equal(decodeJSON(result)))
+		},
+		ginkgo.Entry("replacing a member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`),
+		ginkgo.Entry("adding a member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`),
+		ginkgo.Entry("removing a member", `{"a":"b"}`, `{"a":null}`, `{}`),
+		ginkgo.Entry("keeping other members", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`),
+		ginkgo.Entry("replacing arrays", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`),
+		ginkgo.Entry("merging nested objects", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`),
+		ginkgo.Entry("replacing non objects", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`),
+		ginkgo.Entry("replacing the document", `{"a":"foo"}`, `"bar"`, `"bar"`),
+		ginkgo.Entry("creating objects", `[1,2]`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`),
+	)
+
+	ginkgo.It("should not modify the document", func() {
+		document := decodeJSON(`{"a":{"b":"c"}}`)
+
+		_, err := MergePatch(document, []byte(`{"a":{"b":null}}`))
+		gomega.Expect(err).To(gomega.BeNil())
+		_, err = ApplyJSONPatch(document, []byte(`[{"op":"remove","path":"/a/b"}]`))
+		gomega.Expect(err).To(gomega.BeNil())
+
+		gomega.Expect(document).To(gomega.Equal(decodeJSON(`{"a":{"b":"c"}}`)))
+	})
+
+	ginkgo.DescribeTable("ApplyJSONPatch applies the examples of RFC 6902",
+		func(document, patch, result string) {
+			gomega.Expect(ApplyJSONPatch(decodeJSON(document), []byte(patch))).To(gomega.Equal(decodeJSON(result)))
+		},
+		ginkgo.Entry("adding a member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`),
+		ginkgo.Entry("adding an array element", `{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`),
+		ginkgo.Entry("appending to an array", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc"]}]`,
+			`{"foo":["bar",["abc"]]}`),
+		ginkgo.Entry("removing a member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`),
+		ginkgo.Entry("removing an array element", `{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`),
+		ginkgo.Entry("replacing a value", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`),
+		ginkgo.Entry("moving a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`),
+		ginkgo.Entry("moving an array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`),
+		ginkgo.Entry("copying a value", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`,
+			`{"foo":{"bar":1},"baz":{"bar":1}}`),
+		ginkgo.Entry("testing values", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`),
+		ginkgo.Entry("escaped paths", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},
+			{"op":"add","path":"/a~1b","value":null}]`, `{"/":9,"~1":10,"a/b":null}`),
+		ginkgo.Entry("setting null values", `{"foo":"bar"}`, `[{"op":"replace","path":"/foo","value":null}]`,
+			`{"foo":null}`),
+	)
+
+	ginkgo.DescribeTable("ApplyJSONPatch rejects",
+		func(patch string, expected error, message string) {
+			_, err := ApplyJSONPatch(decodeJSON(`{"foo":{"bar":[1]},"baz":"qux"}`), []byte(patch))
+			gomega.Expect(err).To(gomega.MatchError(expected))
+			gomega.Expect(err.Error()).To(gomega.ContainSubstring(message))
+		},
+		ginkgo.Entry("patches that are not arrays", `{"op":"add"}`, ErrInvalidPatch, "cannot unmarshal"),
+		ginkgo.Entry("unknown ops", `[{"op":"merge","path":"/baz"}]`, ErrInvalidPatch, `unknown op "merge"`),
+		ginkgo.Entry("missing values", `[{"op":"add","path":"/baz"}]`, ErrInvalidPatch, `add has no "value"`),
+		ginkgo.Entry("relative paths", `[{"op":"remove","path":"baz"}]`, ErrInvalidPatch, `must start with "/"`),
+		ginkgo.Entry("missing parents", `[{"op":"add","path":"/foo/qux/bar","value":1}]`, ErrInvalidPatch,
+			"operation 0: invalid patch: /foo/qux does not exist"),
+		ginkgo.Entry("missing members", `[{"op":"remove","path":"/foo/baz"}]`, ErrInvalidPatch,
+			"/foo/baz does not exist"),
+		ginkgo.Entry("indexes out of range", `[{"op":"replace","path":"/foo/bar/1","value":2}]`, ErrInvalidPatch,
+			"/foo/bar/1 does not exist"),
+		ginkgo.Entry("indexes with leading zeros", `[{"op":"add","path":"/foo/bar/01","value":2}]`,
+			ErrInvalidPatch, "/foo/bar/01 does not exist"),
+		ginkgo.Entry("moves into children", `[{"op":"move","from":"/foo","path":"/foo/child"}]`, ErrInvalidPatch,
+			"/foo can't be moved into itself"),
+		ginkgo.Entry("failing tests", `[{"op":"remove","path":"/baz"},{"op":"test","path":"/foo/bar/0","value":"1"}]`,
+			ErrPatchTestFailed, "operation 1: patch test failed: /foo/bar/0"),
+	)
+})
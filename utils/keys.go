@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// MinRSAKeyBits is the smallest size of the keys signing tokens.
+const MinRSAKeyBits = 2048
+
+// GenerateRSAKeyPair returns a new key pair to sign and verify tokens, PEM
+// encoded as the PRIVATE_KEY and PUBLIC_KEY files expect them: the private
+// key in PKCS #8, the public key in PKIX.
+func GenerateRSAKeyPair(bits int) (privatePEM, publicPEM []byte, err error) {
+	if bits < MinRSAKeyBits {
+		return nil, nil, fmt.Errorf("RSA keys must have at least %d bits", MinRSAKeyBits)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privatePEM, publicPEM, nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("GenerateRSAKeyPair", func() {
+	ginkgo.It("should generate keys signing and verifying tokens", func() {
+		privatePEM, publicPEM, err := GenerateRSAKeyPair(MinRSAKeyBits)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+		gomega.Expect(err).To(gomega.BeNil())
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"user_id": "1"}).SignedString(privateKey)
+		gomega.Expect(err).To(gomega.BeNil())
+		token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) { return publicKey, nil })
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(token.Valid).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should refuse keys too small to be safe", func() {
+		_, _, err := GenerateRSAKeyPair(1024)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
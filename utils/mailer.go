@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mail is a plain text message to a single recipient.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers mails. Implementations for an SMTP server or an email API
+// can be plugged in, the default writes them to stdout.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+type writerMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterMailer returns a Mailer writing every mail to w, useful in
+// development and tests.
+func NewWriterMailer(w io.Writer) *writerMailer {
+	return &writerMailer{w: w}
+}
+
+// NewFileMailer returns a Mailer appending every mail to the file at path.
+func NewFileMailer(path string) (*writerMailer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail file: %v", err)
+	}
+	return NewWriterMailer(file), nil
+}
+
+func (m *writerMailer) Send(ctx context.Context, mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z), mail.To, mail.Subject, mail.Body)
+	return err
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into self-describing encoded hashes and
+// verifies passwords against them.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encodedHash. salt is only used by
+	// legacy bcrypt hashes, which were computed over password+salt.
+	Verify(password, salt, encodedHash string) (bool, error)
+	// NeedsRehash reports whether encodedHash was produced by another algorithm
+	// or with other parameters than the ones currently configured.
+	NeedsRehash(encodedHash string) bool
+}
+
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follows the second recommended option of RFC 9106.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type argon2idHasher struct {
+	Params  Argon2idParams
+	Peppers *Peppers
+}
+
+// NewArgon2idHasher returns a PasswordHasher producing PHC formatted Argon2id
+// hashes, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>. Legacy bcrypt
+// hashes can still be verified, but always need a rehash.
+func NewArgon2idHasher(params Argon2idParams) *argon2idHasher {
+	return &argon2idHasher{Params: params}
+}
+
+// NewPepperedArgon2idHasher is like NewArgon2idHasher, but hashes the HMAC of
+// the password keyed with the current pepper. The pepper version is recorded
+// as the PHC keyid parameter, e.g. m=65536,t=3,p=4,keyid=v2, and hashes made
+// with another version need a rehash.
+func NewPepperedArgon2idHasher(params Argon2idParams, peppers *Peppers) *argon2idHasher {
+	return &argon2idHasher{Params: params, Peppers: peppers}
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	input, err := h.pepper(h.currentPepperVersion(), password)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(input, salt, h.Params.Iterations, h.Params.Memory,
+		h.Params.Parallelism, h.Params.KeyLength)
+
+	paramString := fmt.Sprintf("m=%d,t=%d,p=%d", h.Params.Memory, h.Params.Iterations, h.Params.Parallelism)
+	if version := h.currentPepperVersion(); version != "" {
+		paramString += ",keyid=" + version
+	}
+
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, paramString,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(password, salt, encodedHash string) (bool, error) {
+	if isBcryptHash(encodedHash) {
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password+salt))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	decoded, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	input, err := h.pepper(decoded.pepperVersion, password)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey(input, decoded.salt, decoded.params.Iterations, decoded.params.Memory,
+		decoded.params.Parallelism, decoded.params.KeyLength)
+
+	return subtle.ConstantTimeCompare(decoded.key, otherKey) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encodedHash string) bool {
+	decoded, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	return decoded.params != h.Params || decoded.pepperVersion != h.currentPepperVersion()
+}
+
+func (h *argon2idHasher) currentPepperVersion() string {
+	if h.Peppers == nil {
+		return ""
+	}
+	return h.Peppers.Current
+}
+
+// pepper returns the argon2 input for password, version is empty for hashes
+// made without a pepper.
+func (h *argon2idHasher) pepper(version, password string) ([]byte, error) {
+	if version == "" {
+		return []byte(password), nil
+	}
+	if h.Peppers == nil {
+		return nil, fmt.Errorf("unknown pepper version %q", version)
+	}
+	return h.Peppers.apply(version, password)
+}
+
+func isBcryptHash(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+type argon2idHash struct {
+	params        Argon2idParams
+	pepperVersion string
+	salt          []byte
+	key           []byte
+}
+
+func decodeArgon2idHash(encodedHash string) (argon2idHash, error) {
+	var decoded argon2idHash
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return decoded, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return decoded, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return decoded, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, found := strings.Cut(param, "=")
+		if !found {
+			return decoded, ErrUnknownHashFormat
+		}
+
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &decoded.params.Memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &decoded.params.Iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &decoded.params.Parallelism)
+		case "keyid":
+			decoded.pepperVersion = value
+		default:
+			err = ErrUnknownHashFormat
+		}
+		if err != nil {
+			return decoded, ErrUnknownHashFormat
+		}
+	}
+	if decoded.params.Memory == 0 || decoded.params.Iterations == 0 || decoded.params.Parallelism == 0 {
+		return decoded, ErrUnknownHashFormat
+	}
+
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return decoded, ErrUnknownHashFormat
+	}
+	decoded.params.SaltLength = uint32(len(decoded.salt))
+
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return decoded, ErrUnknownHashFormat
+	}
+	decoded.params.KeyLength = uint32(len(decoded.key))
+
+	return decoded, nil
+}
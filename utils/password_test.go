@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"testing"
+
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUtils(t *testing.T) {
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "Utils Suite")
+}
+
+var _ = ginkgo.Describe("Argon2idHasher", func() {
+	params := Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hasher := NewArgon2idHasher(params)
+
+	ginkgo.It("should produce PHC formatted hashes that verify", func() {
+		hash, err := hasher.Hash("P@ssw0rd")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(hash).To(gomega.HavePrefix("$argon2id$v=19$m=1024,t=1,p=1$"))
+
+		ok, err := hasher.Verify("P@ssw0rd", "", hash)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeTrue())
+
+		ok, err = hasher.Verify("wrong", "", hash)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should salt every hash", func() {
+		first, _ := hasher.Hash("P@ssw0rd")
+		second, _ := hasher.Hash("P@ssw0rd")
+		gomega.Expect(first).NotTo(gomega.Equal(second))
+	})
+
+	ginkgo.It("should verify legacy bcrypt hashes computed over password and salt", func() {
+		legacyHash, _ := bcrypt.GenerateFromPassword([]byte("P@ssw0rd"+"salt"), bcrypt.MinCost)
+
+		ok, err := hasher.Verify("P@ssw0rd", "salt", string(legacyHash))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeTrue())
+
+		ok, err = hasher.Verify("P@ssw0rd", "other", string(legacyHash))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeFalse())
+		gomega.Expect(hasher.NeedsRehash(string(legacyHash))).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should need a rehash only when the parameters changed", func() {
+		hash, _ := hasher.Hash("P@ssw0rd")
+		gomega.Expect(hasher.NeedsRehash(hash)).To(gomega.BeFalse())
+
+		stronger := params
+		stronger.Iterations = 2
+		gomega.Expect(NewArgon2idHasher(stronger).NeedsRehash(hash)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should reject unknown hash formats", func() {
+		ok, err := hasher.Verify("P@ssw0rd", "", "hashed_password")
+		gomega.Expect(err).To(gomega.Equal(ErrUnknownHashFormat))
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+})
+
+var _ = ginkgo.Describe("Peppered Argon2idHasher", func() {
+	params := Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	v1 := "djEtcGVwcGVyLXYxLXBlcHBlci12MQ=="
+	v2 := "djItcGVwcGVyLXYyLXBlcHBlci12Mg=="
+
+	ginkgo.It("should record the pepper version as keyid", func() {
+		peppers, err := ParsePeppers("v1:" + v1)
+		gomega.Expect(err).To(gomega.BeNil())
+		hasher := NewPepperedArgon2idHasher(params, peppers)
+
+		hash, err := hasher.Hash("P@ssw0rd")
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(hash).To(gomega.HavePrefix("$argon2id$v=19$m=1024,t=1,p=1,keyid=v1$"))
+
+		ok, err := hasher.Verify("P@ssw0rd", "", hash)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(hasher.NeedsRehash(hash)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should not verify without the pepper", func() {
+		peppers, _ := ParsePeppers("v1:" + v1)
+		hash, _ := NewPepperedArgon2idHasher(params, peppers).Hash("P@ssw0rd")
+
+		ok, err := NewArgon2idHasher(params).Verify("P@ssw0rd", "", hash)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should verify old versions after a rotation and ask for a rehash", func() {
+		oldPeppers, _ := ParsePeppers("v1:" + v1)
+		hash, _ := NewPepperedArgon2idHasher(params, oldPeppers).Hash("P@ssw0rd")
+
+		peppers, err := ParsePeppers("v2:" + v2 + ",v1:" + v1)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(peppers.Current).To(gomega.Equal("v2"))
+		hasher := NewPepperedArgon2idHasher(params, peppers)
+
+		ok, err := hasher.Verify("P@ssw0rd", "", hash)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(hasher.NeedsRehash(hash)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should ask unpeppered hashes for a rehash", func() {
+		hash, _ := NewArgon2idHasher(params).Hash("P@ssw0rd")
+		peppers, _ := ParsePeppers("v1:" + v1)
+
+		gomega.Expect(NewPepperedArgon2idHasher(params, peppers).NeedsRehash(hash)).To(gomega.BeTrue())
+	})
+
+	ginkgo.DescribeTable("ParsePeppers rejects",
+		func(value string) {
+			_, err := ParsePeppers(value)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		},
+		ginkgo.Entry("an empty value", ""),
+		ginkgo.Entry("a missing version", v1),
+		ginkgo.Entry("a short key", "v1:c2hvcnQ="),
+		ginkgo.Entry("a duplicate version", "v1:"+v1+",v1:"+v2),
+	)
+
+	ginkgo.It("should not reveal a malformed pepper in its error", func() {
+		_, err := ParsePeppers("v1:" + v1 + "\n# rotated\n" + v2)
+		gomega.Expect(err).To(gomega.MatchError("pepper entry 2: expected version:base64key"))
+	})
+})
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const minPepperLength = 16
+
+var pepperVersionRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+// Peppers holds the server side secrets mixed into every password hash. They
+// live outside the database, so a leaked password table alone can't be
+// brute forced. Old versions are kept to verify hashes that have not been
+// upgraded yet.
+type Peppers struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// ParsePeppers parses comma or newline separated "version:base64key" entries.
+// The first entry is the current pepper used for new hashes. Errors never
+// quote a malformed entry, which may be a secret, only its position.
+func ParsePeppers(s string) (*Peppers, error) {
+	peppers := &Peppers{Keys: map[string][]byte{}}
+
+	position := 0
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		position++
+
+		version, encodedKey, found := strings.Cut(entry, ":")
+		if !found || !pepperVersionRegex.MatchString(version) {
+			return nil, fmt.Errorf("pepper entry %d: expected version:base64key", position)
+		}
+		if _, ok := peppers.Keys[version]; ok {
+			return nil, fmt.Errorf("duplicate pepper version %q", version)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pepper %q: %v", version, err)
+		}
+		if len(key) < minPepperLength {
+			return nil, fmt.Errorf("pepper %q must be at least %d bytes", version, minPepperLength)
+		}
+
+		if peppers.Current == "" {
+			peppers.Current = version
+		}
+		peppers.Keys[version] = key
+	}
+
+	if peppers.Current == "" {
+		return nil, fmt.Errorf("no pepper configured")
+	}
+	return peppers, nil
+}
+
+// LoadPeppers reads the peppers from the file at path, or parses value when
+// there is no file. It returns nil when neither is set.
+func LoadPeppers(path, value string) (*Peppers, error) {
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pepper file: %v", err)
+		}
+		return ParsePeppers(string(content))
+	}
+
+	if value != "" {
+		return ParsePeppers(value)
+	}
+
+	return nil, nil
+}
+
+// apply returns the HMAC of password keyed with the given pepper version.
+func (p *Peppers) apply(version, password string) ([]byte, error) {
+	key, ok := p.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown pepper version %q", version)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var (
+	ErrInvalidPhoneNumber          = errors.New("invalid phone number")
+	ErrPhoneNumberRegionNotAllowed = errors.New("phone number region not allowed")
+)
+
+// PhoneNumberParser parses phone numbers written in any common format, e.g.
+// "+62 812-3456-7890" or "0812 3456 7890", into their E.164 form. Numbers are
+// stored and looked up in that form only, so every way of writing a number
+// resolves to the same account.
+type PhoneNumberParser struct {
+	DefaultRegion  string
+	AllowedRegions []string
+}
+
+type NewPhoneNumberParserOptions struct {
+	// DefaultRegion is used for numbers written without a country code,
+	// defaults to the first allowed region.
+	DefaultRegion string
+	// AllowedRegions are ISO 3166-1 alpha-2 country codes, defaults to ID.
+	AllowedRegions []string
+}
+
+func NewPhoneNumberParser(opts NewPhoneNumberParserOptions) *PhoneNumberParser {
+	allowedRegions := make([]string, 0, len(opts.AllowedRegions))
+	for _, region := range opts.AllowedRegions {
+		if region = strings.ToUpper(strings.TrimSpace(region)); region != "" {
+			allowedRegions = append(allowedRegions, region)
+		}
+	}
+	if len(allowedRegions) == 0 {
+		allowedRegions = []string{"ID"}
+	}
+
+	defaultRegion := strings.ToUpper(opts.DefaultRegion)
+	if defaultRegion == "" {
+		defaultRegion = allowedRegions[0]
+	}
+
+	return &PhoneNumberParser{defaultRegion, allowedRegions}
+}
+
+// Normalize returns phoneNumber in E.164 format. It fails with
+// ErrInvalidPhoneNumber or ErrPhoneNumberRegionNotAllowed.
+func (p *PhoneNumberParser) Normalize(phoneNumber string) (string, error) {
+	number, err := phonenumbers.Parse(phoneNumber, p.DefaultRegion)
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	region := phonenumbers.GetRegionCodeForNumber(number)
+	for _, allowed := range p.AllowedRegions {
+		if region == allowed {
+			return phonenumbers.Format(number, phonenumbers.E164), nil
+		}
+	}
+	return "", ErrPhoneNumberRegionNotAllowed
+}
+
+// NationalNumber returns the digits of phoneNumber without country code and
+// trunk prefix, e.g. "81234567890" for "+6281234567890", or an empty string if
+// it can't be parsed.
+func (p *PhoneNumberParser) NationalNumber(phoneNumber string) string {
+	number, err := phonenumbers.Parse(phoneNumber, p.DefaultRegion)
+	if err != nil {
+		return ""
+	}
+	return phonenumbers.GetNationalSignificantNumber(number)
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type s3BlobStore struct {
+	Endpoint        *url.URL
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string
+	Client          *http.Client
+	now             func() time.Time
+}
+
+type NewS3BlobStoreOptions struct {
+	// Endpoint of the S3 compatible service, e.g. "https://s3.amazonaws.com"
+	// or "http://localhost:9000" for MinIO. Buckets are addressed in the
+	// path, which every S3 compatible service supports.
+	Endpoint string
+	// Region defaults to "us-east-1".
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PublicURL the objects of the bucket are served at, e.g. by a CDN.
+	// Defaults to the URL of the bucket on the endpoint.
+	PublicURL string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewS3BlobStore returns a BlobStore putting objects into a bucket of an S3
+// compatible service, signing the requests with AWS Signature Version 4.
+func NewS3BlobStore(opts NewS3BlobStoreOptions) (*s3BlobStore, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 blob store: invalid endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 blob store: no bucket")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PublicURL == "" {
+		opts.PublicURL = strings.TrimSuffix(endpoint.String(), "/") + "/" + opts.Bucket
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &s3BlobStore{endpoint, opts.Region, opts.Bucket, opts.AccessKeyID, opts.SecretAccessKey,
+		strings.TrimSuffix(opts.PublicURL, "/"), opts.Client, time.Now}, nil
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	objectURL := *s.Endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + s.Bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("s3 blob store: put %s: %s: %s", key, resp.Status, body)
+	}
+	return s.PublicURL + "/" + key, nil
+}
+
+// sign adds the headers of AWS Signature Version 4 to req. Every header set
+// so far is signed.
+func (s *s3BlobStore) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters of RFC
+// 3986, as Signature Version 4 requires.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 30
+)
+
+var (
+	ErrInvalidUsername     = errors.New("invalid username")
+	ErrMixedScriptUsername = errors.New("username mixes scripts")
+)
+
+// DefaultReservedUsernames can't be taken by users, as they could be mistaken
+// for the service itself or clash with paths of a web front end.
+var DefaultReservedUsernames = []string{
+	"about", "abuse", "account", "admin", "administrator", "api", "app", "auth", "billing", "blog",
+	"contact", "dashboard", "help", "info", "login", "logout", "me", "moderator", "mod", "news",
+	"noreply", "null", "official", "owner", "password", "postmaster", "privacy", "profile",
+	"register", "root", "sawit", "sawitpro", "security", "settings", "signin", "signup", "staff",
+	"status", "support", "system", "team", "terms", "undefined", "user", "usernames", "webmaster",
+	"www",
+}
+
+// NormalizeUsername returns username in its canonical form: NFKC normalized
+// and case folded, so "JOHN", "john" and "ｊｏｈｎ" are the same handle.
+// Usernames have 3 to 30 letters, digits, "_" or "." that start and end with a
+// letter or digit, without consecutive separators, and the letters are all
+// from a single script.
+func NormalizeUsername(username string) (string, error) {
+	normalized := norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username))))
+
+	length := utf8.RuneCountInString(normalized)
+	if length < minUsernameLength || length > maxUsernameLength {
+		return "", ErrInvalidUsername
+	}
+
+	var previous rune
+	for i, r := range normalized {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+		case r == '_' || r == '.':
+			if i == 0 || previous == '_' || previous == '.' {
+				return "", ErrInvalidUsername
+			}
+		default:
+			return "", ErrInvalidUsername
+		}
+		previous = r
+	}
+	if previous == '_' || previous == '.' {
+		return "", ErrInvalidUsername
+	}
+
+	if !isSingleScript(normalized) {
+		return "", ErrMixedScriptUsername
+	}
+	return normalized, nil
+}
+
+// UsernameSkeleton maps the characters of a normalized username that look
+// alike to the same character, following the idea of the skeletons of Unicode
+// TR39. Two usernames with the same skeleton are confusable, only one of them
+// can be registered.
+func UsernameSkeleton(username string) string {
+	var skeleton strings.Builder
+	for _, r := range username {
+		if prototype, ok := confusables[r]; ok {
+			r = prototype
+		}
+		skeleton.WriteRune(r)
+	}
+
+	result := skeleton.String()
+	for _, confusable := range confusableSequences {
+		result = strings.ReplaceAll(result, confusable.sequence, confusable.prototype)
+	}
+	return result
+}
+
+// ReservedUsernames is a set of usernames nobody can register, nor anything
+// confusable with them.
+type ReservedUsernames struct {
+	skeletons map[string]struct{}
+}
+
+func NewReservedUsernames(usernames []string) *ReservedUsernames {
+	reserved := &ReservedUsernames{skeletons: map[string]struct{}{}}
+	for _, username := range usernames {
+		if normalized, err := NormalizeUsername(username); err == nil {
+			reserved.skeletons[UsernameSkeleton(normalized)] = struct{}{}
+		}
+	}
+	return reserved
+}
+
+// LoadReservedUsernames reserves DefaultReservedUsernames and the usernames in
+// the file at path, one per line.
+func LoadReservedUsernames(path string) (*ReservedUsernames, error) {
+	usernames := append([]string{}, DefaultReservedUsernames...)
+	err := readLines(path, func(line string) bool {
+		if !strings.HasPrefix(line, "#") {
+			usernames = append(usernames, line)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewReservedUsernames(usernames), nil
+}
+
+// Contains reports whether the normalized username is reserved.
+func (r *ReservedUsernames) Contains(username string) bool {
+	_, ok := r.skeletons[UsernameSkeleton(username)]
+	return ok
+}
+
+// isSingleScript reports whether the letters of s are from one script. The
+// scripts written together in Chinese, Japanese and Korean count as one.
+func isSingleScript(s string) bool {
+	var script string
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		current := scriptOf(r)
+		switch current {
+		case "Han", "Hiragana", "Katakana", "Hangul", "Bopomofo":
+			current = "CJK"
+		}
+		if script == "" {
+			script = current
+		} else if script != current {
+			return false
+		}
+	}
+	return true
+}
+
+func scriptOf(r rune) string {
+	if r <= unicode.MaxASCII || unicode.Is(unicode.Latin, r) {
+		return "Latin"
+	}
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return "Unknown"
+}
+
+// confusables maps case folded characters to the Latin character they can be
+// mistaken for. Mixed scripts are rejected, so the other scripts matter for
+// usernames written entirely in Cyrillic or Greek that imitate a Latin one.
+// Usernames are shown in lower case, but "i", "l" and "1" are still too close
+// in many fonts and are all mapped to "l".
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', 'i': 'l',
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'l', 'ї': 'l', 'ј': 'j', 'к': 'k',
+	'ӏ': 'l', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ѵ': 'v', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	// Greek
+	'α': 'a', 'ϲ': 'c', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'γ': 'y',
+	// Latin
+	'ı': 'l', 'ɡ': 'g',
+}
+
+// confusableSequences are runs of Latin letters that look like a single one.
+// They are replaced in this order, skeletons are stored in a unique column and
+// must not depend on the order of map iteration.
+var confusableSequences = []struct {
+	sequence, prototype string
+}{
+	{"rn", "m"},
+	{"vv", "w"},
+}
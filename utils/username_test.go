@@ -0,0 +1,53 @@
+package utils
+
+import (
+	ginkgo "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Usernames", func() {
+	ginkgo.DescribeTable("NormalizeUsername accepts",
+		func(username, normalized string) {
+			gomega.Expect(NormalizeUsername(username)).To(gomega.Equal(normalized))
+		},
+		ginkgo.Entry("lower case", "john_doe", "john_doe"),
+		ginkgo.Entry("upper case", "John.Doe", "john.doe"),
+		ginkgo.Entry("full width letters", "ｊｏｈｎ", "john"),
+		ginkgo.Entry("other scripts", "Иван", "иван"),
+		ginkgo.Entry("Japanese", "たなか太郎", "たなか太郎"),
+	)
+
+	ginkgo.DescribeTable("NormalizeUsername rejects",
+		func(username string, expected error) {
+			_, err := NormalizeUsername(username)
+			gomega.Expect(err).To(gomega.Equal(expected))
+		},
+		ginkgo.Entry("too short names", "jo", ErrInvalidUsername),
+		ginkgo.Entry("too long names", "abcdefghijklmnopqrstuvwxyz12345", ErrInvalidUsername),
+		ginkgo.Entry("spaces", "john doe", ErrInvalidUsername),
+		ginkgo.Entry("zero width characters", "jo​hn", ErrInvalidUsername),
+		ginkgo.Entry("leading separators", "_john", ErrInvalidUsername),
+		ginkgo.Entry("trailing separators", "john.", ErrInvalidUsername),
+		ginkgo.Entry("consecutive separators", "john__doe", ErrInvalidUsername),
+		ginkgo.Entry("Cyrillic letters in a Latin name", "pаypal", ErrMixedScriptUsername),
+	)
+
+	ginkgo.It("should give confusable usernames the same skeleton", func() {
+		gomega.Expect(UsernameSkeleton("j0hn")).To(gomega.Equal(UsernameSkeleton("john")))
+		gomega.Expect(UsernameSkeleton("rnark")).To(gomega.Equal(UsernameSkeleton("mark")))
+		gomega.Expect(UsernameSkeleton("асе")).To(gomega.Equal(UsernameSkeleton("ace")))
+		gomega.Expect(UsernameSkeleton("john")).NotTo(gomega.Equal(UsernameSkeleton("joan")))
+	})
+
+	ginkgo.It("should replace confusable sequences in a fixed order", func() {
+		gomega.Expect(UsernameSkeleton("rnvv")).To(gomega.Equal("mw"))
+		gomega.Expect(UsernameSkeleton("vvrnrn")).To(gomega.Equal("wmm"))
+	})
+
+	ginkgo.It("should reserve usernames confusable with reserved ones", func() {
+		reserved := NewReservedUsernames(DefaultReservedUsernames)
+		gomega.Expect(reserved.Contains("admin")).To(gomega.BeTrue())
+		gomega.Expect(reserved.Contains("adm1n")).To(gomega.BeTrue())
+		gomega.Expect(reserved.Contains("john")).To(gomega.BeFalse())
+	})
+})
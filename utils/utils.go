@@ -1,71 +1,67 @@
 package utils
 
 import (
+	"crypto/rsa"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Utils interface {
-	HashingPassword(password, salt string) (string, error)
-	GenerateRandomSalt() string
+	HashingPassword(password string) (string, error)
 	GenerateJWTToken(claims jwt.MapClaims) (string, error)
 	ExtractJWTToken(ctx echo.Context) (string, error)
+	// TokenTTL is how long the tokens of GenerateJWTToken are valid.
+	TokenTTL() time.Duration
 }
 
-type utils struct{}
+// DefaultTokenTTL is how long tokens are valid by default.
+const DefaultTokenTTL = 24 * time.Hour
 
-func NewUtils() *utils {
-	return &utils{}
+type utils struct {
+	Hasher     PasswordHasher
+	PrivateKey *rsa.PrivateKey
+	TTL        time.Duration
 }
 
-func (u *utils) HashingPassword(password, salt string) (string, error) {
-	passwordWithSalt := []byte(password + salt)
-	hashedPassword, err := bcrypt.GenerateFromPassword(passwordWithSalt, bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+type NewUtilsOptions struct {
+	// Hasher defaults to Argon2id with DefaultArgon2idParams.
+	Hasher PasswordHasher
+	// PrivateKey signs tokens, none can be issued without it.
+	PrivateKey *rsa.PrivateKey
+	// TokenTTL defaults to DefaultTokenTTL.
+	TokenTTL time.Duration
 }
 
-func (u *utils) GenerateRandomSalt() string {
-	const saltLength = 16
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	rand.Seed(time.Now().UnixNano())
-
-	salt := make([]byte, saltLength)
-	for i := range salt {
-		salt[i] = charset[rand.Intn(len(charset))]
+func NewUtils(opts NewUtilsOptions) *utils {
+	if opts.Hasher == nil {
+		opts.Hasher = NewArgon2idHasher(DefaultArgon2idParams)
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = DefaultTokenTTL
 	}
+	return &utils{opts.Hasher, opts.PrivateKey, opts.TokenTTL}
+}
 
-	return string(salt)
+func (u *utils) HashingPassword(password string) (string, error) {
+	return u.Hasher.Hash(password)
 }
 
 func (u *utils) GenerateJWTToken(claims jwt.MapClaims) (string, error) {
-	var privateKeyPath = os.Getenv("PRIVATE_KEY")
-	privateKeyBytes, err := ioutil.ReadFile(privateKeyPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read private key file: %v", err)
-	}
-
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse private key: %v", err)
+	if u.PrivateKey == nil {
+		return "", errors.New("no private key configured")
 	}
 
 	// Set the expiration time
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(u.TTL)
 
 	claims["exp"] = expirationTime.Unix()
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	tokenString, err := token.SignedString(privateKey)
+	tokenString, err := token.SignedString(u.PrivateKey)
 	if err != nil {
 		return "", err
 	}
@@ -73,6 +69,10 @@ func (u *utils) GenerateJWTToken(claims jwt.MapClaims) (string, error) {
 	return tokenString, nil
 }
 
+func (u *utils) TokenTTL() time.Duration {
+	return u.TTL
+}
+
 func (u *utils) ExtractJWTToken(ctx echo.Context) (string, error) {
 	authHeader := ctx.Request().Header.Get("Authorization")
 	if authHeader == "" {